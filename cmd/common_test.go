@@ -34,6 +34,17 @@ func (m *mockExecs) Prune(ctx context.Context, opts core.PruneOptions) error {
 	args := m.Called(opts)
 	return args.Error(0)
 }
+
+func (m *mockExecs) Sync(ctx context.Context, opts core.SyncOptions) error {
+	args := m.Called(opts)
+	return args.Error(0)
+}
+
+func (m *mockExecs) Rekey(ctx context.Context, opts core.RekeyOptions) error {
+	args := m.Called(opts)
+	return args.Error(0)
+}
+
 func (m *mockExecs) Timer(timerOpts core.TimerOptions, cmd func() error) error {
 	args := m.Called(timerOpts)
 	err := args.Error(0)
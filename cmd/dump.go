@@ -3,8 +3,10 @@ package cmd
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
@@ -17,6 +19,9 @@ import (
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/core"
 	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/secret"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/storage"
 	"github.com/databacker/mysql-backup/pkg/util"
 )
@@ -159,7 +164,7 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 				compressionAlgo = compressionVar
 			}
 			if compressionAlgo != "" {
-				compressor, err = compression.GetCompressor(compressionAlgo)
+				compressor, err = compression.GetCompressor(compressionAlgo, parseCompressionOptions(v, cmdConfig)...)
 				if err != nil {
 					return fmt.Errorf("failure to get compression '%s': %v", compressionAlgo, err)
 				}
@@ -170,6 +175,7 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 				encryptionAlgo string
 				encryptionKey  []byte
 				encryptor      encrypt.Encryptor
+				envelope       *encrypt.Envelope
 			)
 			if cmdConfig.configuration != nil && dumpConfig != nil && dumpConfig.Encryption != nil {
 				if dumpConfig.Encryption.Algorithm == nil {
@@ -182,7 +188,11 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 				case dumpConfig.Encryption.Key != nil && *dumpConfig.Encryption.Key == "" && dumpConfig.Encryption.KeyPath != nil && *dumpConfig.Encryption.KeyPath == "":
 					return fmt.Errorf("must set at least one of encryption key or path in config file")
 				case dumpConfig.Encryption.Key != nil && *dumpConfig.Encryption.Key != "":
-					encryptionKey, err = base64.StdEncoding.DecodeString(*dumpConfig.Encryption.Key)
+					keyContent, err := secret.Resolve(*dumpConfig.Encryption.Key)
+					if err != nil {
+						return fmt.Errorf("error getting encryption key from config file: %v", err)
+					}
+					encryptionKey, err = base64.StdEncoding.DecodeString(keyContent)
 					if err != nil {
 						return fmt.Errorf("error decoding encryption key from config file: %v", err)
 					}
@@ -198,38 +208,88 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 			if encryptionVar != "" {
 				encryptionAlgo = encryptionVar
 			}
-			if encryptionAlgo != "" {
-				keyContent := v.GetString("encryption-key")
-				keyPath := v.GetString("encryption-key-path")
-				switch {
-				case keyContent != "" && keyPath != "":
-					return fmt.Errorf("encryption key and path cannot both be set in CLI")
-				case keyContent == "" && keyPath == "":
-					return fmt.Errorf("must set at least one of encryption key or path in CLI")
-				case keyContent != "":
-					encryptionKey, err = base64.StdEncoding.DecodeString(keyContent)
-					if err != nil {
-						return fmt.Errorf("error decoding encryption key from CLI flag: %v", err)
-					}
-				case keyPath != "":
-					key, err := os.ReadFile(keyPath)
+			masterKeys := v.GetStringSlice("encryption-master-key")
+			if len(masterKeys) > 0 && encryptionAlgo == "" {
+				return fmt.Errorf("encryption master key requires an encryption algorithm")
+			}
+			if encryptionAlgo != "" && len(masterKeys) > 0 {
+				if v.GetString("encryption-key") != "" || v.GetString("encryption-key-path") != "" || len(v.GetStringSlice("key-share")) > 0 {
+					return fmt.Errorf("encryption master key cannot be set with encryption key, path or shares")
+				}
+				var keys [][]byte
+				for _, masterKey := range masterKeys {
+					key, err := parseMasterKey(masterKey)
 					if err != nil {
-						return fmt.Errorf("error reading encryption key from path: %v", err)
+						return err
 					}
-					encryptionKey = key
+					keys = append(keys, key)
+				}
+				if envelope, err = encrypt.NewEnvelope(encryptionAlgo, keys); err != nil {
+					return fmt.Errorf("failure to set up envelope encryption: %v", err)
+				}
+			} else if encryptionAlgo != "" {
+				if encryptionKey, err = parseEncryptionKey(v); err != nil {
+					return err
+				}
+				if encryptionKey == nil {
+					return fmt.Errorf("must set at least one of encryption key, path or shares in CLI")
 				}
 
-				encryptor, err = encrypt.GetEncryptor(encryptionAlgo, encryptionKey)
+				encryptionKeyPassword, err := secret.Resolve(v.GetString("encryption-key-password"))
+				if err != nil {
+					return fmt.Errorf("error getting encryption key password from CLI flag: %v", err)
+				}
+				encryptor, err = encrypt.GetEncryptor(encryptionAlgo, encryptionKey, encrypt.WithKeyPassword(encryptionKeyPassword))
 				if err != nil {
 					return fmt.Errorf("failure to get encryptor '%s': %v", encryptionAlgo, err)
 				}
 			}
 
+			signer, err := parseSigningKey(v)
+			if err != nil {
+				return err
+			}
+
 			// retention, if enabled
 			retention := v.GetString("retention")
 			if retention == "" && cmdConfig.configuration != nil && cmdConfig.configuration.Prune != nil && cmdConfig.configuration.Prune.Retention != nil {
 				retention = *cmdConfig.configuration.Prune.Retention
 			}
+			// upload retries and failure policy
+			uploadRetries := v.GetInt("upload-retries")
+			if uploadRetries < 0 {
+				return fmt.Errorf("upload-retries must not be negative")
+			}
+			uploadRetryBackoff := v.GetDuration("upload-retry-backoff")
+			uploadRetryMaxBackoff := v.GetDuration("upload-retry-max-backoff")
+			continueOnUploadFailure := v.GetBool("upload-continue-on-failure")
+			uploadConcurrency := v.GetInt("upload-concurrency")
+			if uploadConcurrency < 0 {
+				return fmt.Errorf("upload-concurrency must not be negative")
+			}
+
+			// bandwidth limits
+			uploadRateLimit, err := parseRateLimit(v, "upload-rate-limit")
+			if err != nil {
+				return err
+			}
+			dbReadRateLimit, err := parseRateLimit(v, "db-read-rate-limit")
+			if err != nil {
+				return err
+			}
+
+			// checksums of the backup file; if none are given, core uses the default
+			var checksums []string
+			if v.IsSet("checksum") {
+				checksums = v.GetStringSlice("checksum")
+			}
+
+			// what counts as success with several targets
+			targetPolicy, err := parseTargetPolicy(v, cmdConfig)
+			if err != nil {
+				return fmt.Errorf("invalid target policy: %v", err)
+			}
+
 			filenamePattern := v.GetString("filename-pattern")
 
 			if !v.IsSet("filename-pattern") && dumpConfig != nil && dumpConfig.FilenamePattern != nil {
@@ -322,6 +382,8 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 					DBConn:              cmdConfig.dbconn,
 					Compressor:          compressor,
 					Encryptor:           encryptor,
+					Envelope:            envelope,
+					Signer:              signer,
 					Exclude:             exclude,
 					PreBackupScripts:    preBackupScripts,
 					PostBackupScripts:   postBackupScripts,
@@ -336,17 +398,39 @@ func dumpCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, er
 					Parallelism:         parallel,
 					IgnoreTables:        ignoreTables,
 					ServerUUID:          serverUUID,
+					Retention:           retention,
+					UploadRetry: core.RetryOptions{
+						Retries:    uploadRetries,
+						Backoff:    uploadRetryBackoff,
+						MaxBackoff: uploadRetryMaxBackoff,
+					},
+					UploadConcurrency:       uploadConcurrency,
+					ContinueOnUploadFailure: continueOnUploadFailure,
+					TargetPolicy:            targetPolicy,
+					Checksums:               checksums,
+					UploadRateLimit:         uploadRateLimit,
+					DBReadRateLimit:         dbReadRateLimit,
 				}
 				results, err := executor.Dump(tracerCtx, dumpOpts)
 				if err != nil {
 					exitCode = 1
 					backupStatus = string(api.BackupStatusError)
+					if errors.Is(err, core.ErrPartialUpload) {
+						backupStatus = string(core.BackupStatusPartial)
+					}
 					dumpSpan.SetStatus(codes.Error, fmt.Sprintf("error running dump: %v", err))
 					return fmt.Errorf("error running dump: %w", err)
 				}
 				bytes = results.Bytes
+				// the target policy was met, but some targets did not receive the backup
+				failed := results.FailedTargets()
+				if len(failed) > 0 {
+					backupStatus = string(core.BackupStatusPartial)
+				}
 				if retention != "" {
-					if err := executor.Prune(tracerCtx, core.PruneOptions{Targets: targets, Retention: retention, Run: uid}); err != nil {
+					// only prune the targets that received the backup
+					pruneTargets := slices.DeleteFunc(slices.Clone(targets), func(t storage.Storage) bool { return slices.Contains(failed, t.URL()) })
+					if err := executor.Prune(tracerCtx, core.PruneOptions{Targets: pruneTargets, Retention: retention, Run: uid}); err != nil {
 						exitCode = 1
 						backupStatus = string(api.BackupStatusError)
 						dumpSpan.SetStatus(codes.Error, fmt.Sprintf("error running prune: %v", err))
@@ -406,7 +490,10 @@ S3: If it is a URL of the format s3://bucketname/path then it will connect via S
 	flags.Bool("safechars", false, "The dump filename usually includes the character `:` in the date, to comply with RFC3339. Some systems and shells don't like that character. If true, will replace all `:` with `-`.")
 
 	// compression
-	flags.String("compression", defaultCompression, "Compression to use. Supported are: `gzip`, `bzip2`, `none`")
+	flags.String("compression", defaultCompression, "Compression to use. Supported are: `gzip`, `pgzip`, `bzip2`, `zstd`, `xz`, `none`")
+	flags.Int("compression-level", 0, "Compression level: 1-9 for gzip, pgzip, bzip2 and xz, 1-22 for zstd. Default 0, the default level of the compression.")
+	flags.Int("compression-threads", 0, "How many threads to compress with, for pgzip, zstd and xz. Default 0, one per CPU, but for xz only as many as fit in 1 GiB. Each xz thread takes about 8 times the dictionary, e.g. 512 MiB at level 9.")
+	flags.Bool("compression-long", false, "Find matches further apart, using a 128 MiB window, for zstd. Improves compression of large dumps with repeated data.")
 
 	// source filename pattern
 	flags.String("filename-pattern", defaultFilenamePattern, "Pattern to use for filename in target. See documentation.")
@@ -434,13 +521,38 @@ S3: If it is a URL of the format s3://bucketname/path then it will connect via S
 	// retention
 	flags.String("retention", "", "Retention period for backups. Optional. If not specified, no pruning will be done. Can be number of backups or time-based. For time-based, the format is: 1d, 1w, 1m, 1y for days, weeks, months, years, respectively. For number-based, the format is: 1c, 2c, 3c, etc. for the count of backups to keep.")
 
+	// upload retries and failure policy
+	flags.Int("upload-retries", 0, "How many times to retry a failed upload to each target, with exponential backoff and jitter.")
+	flags.Duration("upload-retry-backoff", 0, "How long to wait before the first retry of a failed upload, doubling for each retry after that. Default 1s.")
+	flags.Duration("upload-retry-max-backoff", 0, "The longest to wait between retries of a failed upload. Default 1m.")
+	flags.Int("upload-concurrency", 0, "How many targets to upload to at once. Default 0, upload to all targets at once.")
+	flags.Bool("upload-continue-on-failure", false, "If the upload to a target fails after all retries, continue uploading to the other targets, and report a partial failure, rather than stopping.")
+
+	// bandwidth limits
+	flags.String("upload-rate-limit", "", "The most bytes per second to upload to all of the targets together. "+rateLimitUsage)
+	flags.String("db-read-rate-limit", "", "The most bytes per second to read from the database, for all of the databases together. "+rateLimitUsage)
+
+	// checksums
+	flags.StringSlice("checksum", []string{}, "Checksums of the backup file to calculate and store with it on each target, as a sidecar file, e.g. `<file>.sha256`, or as object metadata on S3. One or more of `sha256`, `blake3`, or `none` for no checksums. Default `sha256`.")
+
+	// target policy
+	flags.StringSlice("target-policy-required", []string{}, "Targets that must receive the backup for it to succeed, as the same URL as in --target, or a reference to a target in the configuration file, e.g. `config://targetname`. Setting a target policy allows the upload to any other target to fail, as long as --target-policy-min-success targets succeed.")
+	flags.Int("target-policy-min-success", 0, "The minimum number of targets that must receive the backup for it to succeed. Setting a target policy allows the upload to any target not in --target-policy-required to fail, as long as this many succeed.")
+
 	// ignore-tables: tables to exclude from the dump (formats: database.table or table)
 	flags.StringSlice("ignore-tables", []string{}, "Tables to exclude from the dump. Formats: database.table (e.g. mydb.mytable) or table (applies to all databases/schemas). Can be specified multiple times or as a comma-separated list.")
 
 	// encryption options
 	flags.String("encryption", "", fmt.Sprintf("Encryption algorithm to use, none if blank. Supported are: %s. Format must match the specific algorithm.", strings.Join(encrypt.All, ", ")))
-	flags.String("encryption-key", "", "Encryption key to use, base64-encoded. Useful for debugging, not recommended for production. If encryption is enabled, and both are provided or neither is provided, returns an error.")
+	flags.String("encryption-key", "", "Encryption key to use, base64-encoded, or a reference to it, e.g. env:VAR, file:/path or exec:command args. If encryption is enabled, and both are provided or neither is provided, returns an error.")
 	flags.String("encryption-key-path", "", "Path to encryption key file. If encryption is enabled, and both are provided or neither is provided, returns an error.")
+	flags.StringSlice("key-share", []string{}, "Share of the encryption key, as keys split made it, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Accepts multiple shares; the key is reconstructed in memory from at least the threshold of them. Cannot be set with encryption-key or encryption-key-path.")
+	flags.StringSlice("encryption-master-key", []string{}, "Master key for envelope encryption, base64-encoded 32 bytes, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Accepts multiple keys. Each backup is encrypted with a new random data key, which is wrapped with each master key and stored in the header, so that any one of them restores it, and they can be changed with rekey. Cannot be combined with encryption-key or encryption-key-path.")
+	flags.String("encryption-key-password", "", "Password of the encryption key, for keys protected by one, e.g. an OpenPGP private key to sign with, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
+
+	// signing
+	flags.String("signing-key", "", "minisign secret key to sign backups with, or a reference to it, e.g. env:VAR, file:/path or exec:command args. The signature is uploaded next to each backup, with the extension .minisig.")
+	flags.String("signing-key-password", "", "Password of the signing key, if it is encrypted, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
 	return cmd, nil
 }
 
@@ -481,9 +593,9 @@ func parseTargets(urls []string, cmdConfig *cmdConfiguration) ([]storage.Storage
 	var targets []storage.Storage
 	if len(urls) > 0 {
 		for _, t := range urls {
-			store, err := storage.ParseURL(t, cmdConfig.creds)
+			store, err := parseTarget(t, cmdConfig)
 			if err != nil {
-				return nil, fmt.Errorf("invalid target url: %v", err)
+				return nil, err
 			}
 			targets = append(targets, store)
 		}
@@ -520,3 +632,188 @@ func parseTargets(urls []string, cmdConfig *cmdConfiguration) ([]storage.Storage
 	}
 	return targets, nil
 }
+
+// parseTargetPolicy get the target policy from the flags, or from the configuration file.
+// Required targets are converted to the URL of their storage, so that they can be matched to the targets.
+func parseTargetPolicy(v *viper.Viper, cmdConfig *cmdConfiguration) (core.TargetPolicy, error) {
+	var (
+		policy   core.TargetPolicy
+		required []string
+	)
+	if cmdConfig.extensions != nil && cmdConfig.extensions.Dump != nil && cmdConfig.extensions.Dump.TargetPolicy != nil {
+		configPolicy := cmdConfig.extensions.Dump.TargetPolicy
+		for _, name := range configPolicy.Required {
+			required = append(required, fmt.Sprintf("config://%s", name))
+		}
+		policy.MinSuccess = configPolicy.MinSuccess
+	}
+	if v.IsSet("target-policy-required") {
+		required = v.GetStringSlice("target-policy-required")
+	}
+	if v.IsSet("target-policy-min-success") {
+		policy.MinSuccess = v.GetInt("target-policy-min-success")
+	}
+	for _, r := range required {
+		store, err := parseTarget(r, cmdConfig)
+		if err != nil {
+			return policy, fmt.Errorf("invalid required target %s: %v", r, err)
+		}
+		policy.Required = append(policy.Required, store.URL())
+	}
+	if policy.MinSuccess < 0 {
+		return policy, fmt.Errorf("target-policy-min-success must not be negative")
+	}
+	return policy, nil
+}
+
+// parseCompressionOptions get the compression options from the flags, or from the configuration file
+func parseCompressionOptions(v *viper.Viper, cmdConfig *cmdConfiguration) []compression.Option {
+	var (
+		level, threads int
+		longWindow     bool
+	)
+	if cmdConfig.extensions != nil && cmdConfig.extensions.Dump != nil {
+		dumpExtensions := cmdConfig.extensions.Dump
+		if dumpExtensions.CompressionLevel != nil {
+			level = *dumpExtensions.CompressionLevel
+		}
+		if dumpExtensions.CompressionThreads != nil {
+			threads = *dumpExtensions.CompressionThreads
+		}
+		if dumpExtensions.CompressionLongWindow != nil {
+			longWindow = *dumpExtensions.CompressionLongWindow
+		}
+	}
+	if v.IsSet("compression-level") {
+		level = v.GetInt("compression-level")
+	}
+	if v.IsSet("compression-threads") {
+		threads = v.GetInt("compression-threads")
+	}
+	if v.IsSet("compression-long") {
+		longWindow = v.GetBool("compression-long")
+	}
+	opts := []compression.Option{compression.WithLevel(level), compression.WithThreads(threads)}
+	if longWindow {
+		opts = append(opts, compression.WithLongWindow())
+	}
+	return opts
+}
+
+// rateLimitUsage how to set a rate limit, for the usage of each rate limit flag
+const rateLimitUsage = "A number of bytes, optionally followed by K, M or G, e.g. 10M, or a schedule by time of day, e.g. 08:00-18:00=1M,10M for 1 MiB/s from 08:00 to 18:00 and 10 MiB/s the rest of the time. Default 0, unlimited."
+
+// parseEncryptionKey read the encryption key from the CLI flags or env vars, either base64-encoded, a path
+// to it, or reconstructed from its shares; nil if none is set
+func parseEncryptionKey(v *viper.Viper) ([]byte, error) {
+	keyContent := v.GetString("encryption-key")
+	keyPath := v.GetString("encryption-key-path")
+	keyShares := v.GetStringSlice("key-share")
+	switch {
+	case keyContent != "" && keyPath != "":
+		return nil, fmt.Errorf("encryption key and path cannot both be set in CLI")
+	case len(keyShares) > 0 && (keyContent != "" || keyPath != ""):
+		return nil, fmt.Errorf("key shares cannot be set with encryption key or path in CLI")
+	case len(keyShares) > 0:
+		return parseKeyShares(keyShares)
+	case keyContent != "":
+		resolved, err := secret.Resolve(keyContent)
+		if err != nil {
+			return nil, fmt.Errorf("error getting encryption key from CLI flag: %v", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding encryption key from CLI flag: %v", err)
+		}
+		return key, nil
+	case keyPath != "":
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading encryption key from path: %v", err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// parseMasterKey resolve and decode a base64-encoded master key for envelope encryption
+func parseMasterKey(value string) ([]byte, error) {
+	resolved, err := secret.Resolve(value)
+	if err != nil {
+		return nil, fmt.Errorf("error getting master key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(resolved))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding master key: %v", err)
+	}
+	if len(key) != encrypt.MasterKeySize {
+		return nil, fmt.Errorf("master key has length %d, must be %d", len(key), encrypt.MasterKeySize)
+	}
+	return key, nil
+}
+
+// parseSigningKey read the minisign secret key to sign with, and its password, from the CLI flags or env vars;
+// nil if it is not set
+func parseSigningKey(v *viper.Viper) (*signature.SecretKey, error) {
+	value := v.GetString("signing-key")
+	if value == "" {
+		return nil, nil
+	}
+	key, err := secret.Resolve(value)
+	if err != nil {
+		return nil, fmt.Errorf("error getting signing key: %v", err)
+	}
+	password, err := secret.Resolve(v.GetString("signing-key-password"))
+	if err != nil {
+		return nil, fmt.Errorf("error getting signing key password: %v", err)
+	}
+	signer, err := signature.ParseSecretKey([]byte(key), password)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %v", err)
+	}
+	return signer, nil
+}
+
+// parseRateLimit parse the rate limit schedule from a flag
+func parseRateLimit(v *viper.Viper, flag string) (ratelimit.Schedule, error) {
+	schedule, err := ratelimit.ParseSchedule(v.GetString(flag))
+	if err != nil {
+		return schedule, fmt.Errorf("invalid %s: %v", flag, err)
+	}
+	return schedule, nil
+}
+
+// parseTarget parse a single target, which can be a reference to one of the targets in the
+// config file, e.g. config://targetname, or a URL
+func parseTarget(target string, cmdConfig *cmdConfiguration) (storage.Storage, error) {
+	u, err := util.SmartParse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target url: %v", err)
+	}
+	if u.Scheme != "config" {
+		store, err := storage.ParseURL(target, cmdConfig.creds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target url: %v", err)
+		}
+		return store, nil
+	}
+	// get the target name
+	targetName := u.Host
+	// get the target from the config file
+	if cmdConfig.configuration == nil {
+		return nil, fmt.Errorf("no configuration file found")
+	}
+	var targetStructures map[string]api.Target
+	if cmdConfig.configuration.Targets != nil {
+		targetStructures = *cmdConfig.configuration.Targets
+	}
+	targetStructure, ok := targetStructures[targetName]
+	if !ok {
+		return nil, fmt.Errorf("target %s not found in configuration", targetName)
+	}
+	store, err := storage.FromTarget(targetStructure)
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage for target %s: %v", targetName, err)
+	}
+	return store, nil
+}
@@ -3,11 +3,16 @@ package cmd
 import (
 	"io"
 	"net/url"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/core"
 	"github.com/databacker/mysql-backup/pkg/database"
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/storage"
 	"github.com/databacker/mysql-backup/pkg/storage/file"
 	"github.com/go-test/deep"
@@ -19,6 +24,19 @@ func TestDumpCmd(t *testing.T) {
 
 	fileTarget := "file:///foo/bar"
 	fileTargetURL, _ := url.Parse(fileTarget)
+	otherTargetURL, _ := url.Parse("file:///foo/other")
+	envelope, err := encrypt.NewEnvelope("chacha20-poly1305", [][]byte{[]byte("12345678901234567890123456789012"), []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := os.ReadFile("testdata/minisign.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signature.ParseSecretKey(signingKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
 	tests := []struct {
 		name                 string
 		args                 []string // "dump" will be prepended automatically
@@ -51,6 +69,43 @@ func TestDumpCmd(t *testing.T) {
 			Routines:         true,
 			Parallelism:      1,
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"file URL with pass reference (resolved when connecting)", []string{"--server", "abc", "--target", "file:///foo/bar", "--pass", "file:testdata/password.txt"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort, Pass: "file:testdata/password.txt"},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"encryption key reference not found", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "chacha20-poly1305", "--encryption-key", "file:testdata/missing"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption key password reference not found", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "chacha20-poly1305", "--encryption-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=", "--encryption-key-password", "file:testdata/missing"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption master keys", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "chacha20-poly1305", "--encryption-master-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=", "--encryption-master-key", "QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWY="}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			Envelope:         envelope,
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"signing key", []string{"--server", "abc", "--target", "file:///foo/bar", "--signing-key", "file:testdata/minisign.key"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			Signer:           signer,
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"signing key reference not found", []string{"--server", "abc", "--target", "file:///foo/bar", "--signing-key", "file:testdata/missing"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"invalid signing key", []string{"--server", "abc", "--target", "file:///foo/bar", "--signing-key", "file:testdata/minisign.pub"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption master key without encryption", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption-master-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption master key with encryption key", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "chacha20-poly1305", "--encryption-master-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=", "--encryption-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption master key too short", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "chacha20-poly1305", "--encryption-master-key", "MTIz"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"encryption master key with unsupported algorithm", []string{"--server", "abc", "--target", "file:///foo/bar", "--encryption", "age-chacha20-poly1305", "--encryption-master-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
 		{"file URL with pass and pass-file (pass takes precedence)", []string{"--server", "abc", "--target", "file:///foo/bar", "--pass", "explicitpass", "--pass-file", "testdata/password.txt"}, "", false, core.DumpOptions{
 			Targets:          []storage.Storage{file.New(*fileTargetURL)},
 			MaxAllowedPacket: defaultMaxAllowedPacket,
@@ -68,6 +123,7 @@ func TestDumpCmd(t *testing.T) {
 			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
 			Routines:         true,
 			Parallelism:      1,
+			Retention:        "1h",
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, &core.PruneOptions{Targets: []storage.Storage{file.New(*fileTargetURL)}, Retention: "1h"}},
 
 		// database name and port
@@ -99,6 +155,7 @@ func TestDumpCmd(t *testing.T) {
 			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
 			Routines:         true,
 			Parallelism:      1,
+			Retention:        "1h",
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, &core.PruneOptions{Targets: []storage.Storage{file.New(*fileTargetURL)}, Retention: "1h"}},
 		{"config file with port override", []string{"--config-file", "testdata/config.yml", "--port", "3307"}, "", false, core.DumpOptions{
 			Targets:          []storage.Storage{file.New(*fileTargetURL)},
@@ -108,6 +165,7 @@ func TestDumpCmd(t *testing.T) {
 			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
 			Routines:         true,
 			Parallelism:      1,
+			Retention:        "1h",
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, &core.PruneOptions{Targets: []storage.Storage{file.New(*fileTargetURL)}, Retention: "1h"}},
 		{"config file with filename pattern override", []string{"--config-file", "testdata/pattern.yml", "--port", "3307"}, "", false, core.DumpOptions{
 			Targets:          []storage.Storage{file.New(*fileTargetURL)},
@@ -117,8 +175,31 @@ func TestDumpCmd(t *testing.T) {
 			FilenamePattern:  "foo_{{ .now }}.{{ .compression }}",
 			Routines:         true,
 			Parallelism:      1,
+			Retention:        "1h",
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, &core.PruneOptions{Targets: []storage.Storage{file.New(*fileTargetURL)}, Retention: "1h"}},
 
+		{"config file with target policy", []string{"--config-file", "testdata/policy.yml"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL), file.New(*otherTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			DBConn:           &database.Connection{Host: "abcd", Port: 3306, User: "user2", Pass: "xxxx2"},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+			TargetPolicy:     core.TargetPolicy{Required: []string{fileTarget}, MinSuccess: 1},
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"config file with target policy override", []string{"--config-file", "testdata/policy.yml", "--target-policy-required", "config://other", "--target-policy-min-success", "2"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL), file.New(*otherTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			DBConn:           &database.Connection{Host: "abcd", Port: 3306, User: "user2", Pass: "xxxx2"},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+			TargetPolicy:     core.TargetPolicy{Required: []string{"file:///foo/other"}, MinSuccess: 2},
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"target policy with unknown target", []string{"--server", "abc", "--target", "file:///foo/bar", "--target-policy-required", "config://missing"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+
 		// timer options
 		{"once flag", []string{"--server", "abc", "--target", "file:///foo/bar", "--once"}, "", false, core.DumpOptions{
 			Targets:          []storage.Storage{file.New(*fileTargetURL)},
@@ -260,6 +341,37 @@ func TestDumpCmd(t *testing.T) {
 			Parallelism:      1,
 			IgnoreTables:     []string{"db1.table1", "db2.table2"},
 		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"rate limits", []string{"--server", "abc", "--target", "file:///foo/bar", "--upload-rate-limit", "08:00-18:00=1M,10M", "--db-read-rate-limit", "512K"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.GzipCompressor{},
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+			UploadRateLimit:  ratelimit.Schedule{Default: 10 << 20, Windows: []ratelimit.Window{{Start: 8 * time.Hour, End: 18 * time.Hour, Rate: 1 << 20}}},
+			DBReadRateLimit:  ratelimit.Schedule{Default: 512 << 10},
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"zstd compression", []string{"--server", "abc", "--target", "file:///foo/bar", "--compression", "zstd", "--compression-level", "19", "--compression-threads", "2", "--compression-long"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.ZstdCompressor{Level: 19, Threads: 2, LongWindow: true},
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"xz compression", []string{"--server", "abc", "--target", "file:///foo/bar", "--compression", "xz", "--compression-level", "9", "--compression-threads", "4"}, "", false, core.DumpOptions{
+			Targets:          []storage.Storage{file.New(*fileTargetURL)},
+			MaxAllowedPacket: defaultMaxAllowedPacket,
+			Compressor:       &compression.XzCompressor{Level: 9, Threads: 4},
+			DBConn:           &database.Connection{Host: "abc", Port: defaultPort},
+			FilenamePattern:  "db_backup_{{ .now }}.{{ .compression }}",
+			Routines:         true,
+			Parallelism:      1,
+		}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}, nil},
+		{"invalid compression level", []string{"--server", "abc", "--target", "file:///foo/bar", "--compression-level", "10"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
+		{"invalid rate limit", []string{"--server", "abc", "--target", "file:///foo/bar", "--upload-rate-limit", "fast"}, "", true, core.DumpOptions{}, core.TimerOptions{}, nil},
 	}
 
 	for _, tt := range tests {
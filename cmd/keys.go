@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/remote"
+	"github.com/databacker/mysql-backup/pkg/secret"
+	"github.com/databacker/mysql-backup/pkg/shamir"
+)
+
+func keysCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, error) {
+	if cmdConfig == nil {
+		return nil, fmt.Errorf("cmdConfig is nil")
+	}
+	var cmd = &cobra.Command{
+		Use:   "keys",
+		Short: "manage encryption keys",
+		Long:  `Manage the keys that backups are encrypted with.`,
+	}
+	for _, sub := range []func() *cobra.Command{keysGenerateCmd, keysSplitCmd, keysCombineCmd} {
+		cmd.AddCommand(sub())
+	}
+	return cmd, nil
+}
+
+// credentialsAlgorithm the algorithm of keys generate for the credentials of remote configuration, rather than
+// an encryption key
+const credentialsAlgorithm = "credentials"
+
+func keysGenerateCmd() *cobra.Command {
+	var v *viper.Viper
+	var cmd = &cobra.Command{
+		Use:   "generate",
+		Short: "generate a new key",
+		Long: `Generate a new key for an encryption algorithm, or credentials for remote configuration. The private
+		key is written to the output file, readable only by the owner, and for algorithms with public keys, the
+		public key, which dump encrypts to, next to it, with the extension .pub. The fingerprint of the key is
+		printed. Neither file is overwritten if it exists.
+		`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd, v)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			algorithm := v.GetString("algorithm")
+			output := v.GetString("output")
+			switch {
+			case algorithm == "":
+				return fmt.Errorf("no algorithm specified")
+			case output == "":
+				return fmt.Errorf("no output file specified")
+			}
+			var key encrypt.GeneratedKey
+			if algorithm == credentialsAlgorithm {
+				credentials, publicKey, err := remote.GenerateCredentials()
+				if err != nil {
+					return fmt.Errorf("error generating credentials: %v", err)
+				}
+				key = encrypt.GeneratedKey{Private: []byte(credentials + "\n"), Public: []byte(publicKey + "\n"), Fingerprint: publicKey}
+			} else {
+				var err error
+				if key, err = encrypt.GenerateKey(algorithm, v.GetString("name")); err != nil {
+					return fmt.Errorf("error generating key: %v", err)
+				}
+			}
+			cmd.SilenceUsage = true
+
+			out := cmd.OutOrStdout()
+			publicFile := output + ".pub"
+			if key.Public != nil {
+				// check before writing either, so that a private key is not left without its public key
+				if _, err := os.Stat(publicFile); err == nil {
+					return fmt.Errorf("public key file %s already exists", publicFile)
+				}
+			}
+			if err := writeNewFile(output, key.Private, 0o600); err != nil {
+				return fmt.Errorf("error writing private key: %v", err)
+			}
+			_, _ = fmt.Fprintf(out, "private key: %s\n", output)
+			if key.Public != nil {
+				if err := writeNewFile(publicFile, key.Public, 0o644); err != nil {
+					return fmt.Errorf("error writing public key: %v", err)
+				}
+				_, _ = fmt.Fprintf(out, "public key: %s\n", publicFile)
+			}
+			if key.Fingerprint != "" {
+				_, _ = fmt.Fprintf(out, "fingerprint: %s\n", key.Fingerprint)
+			}
+			return nil
+		},
+	}
+	v = viper.New()
+	v.SetEnvPrefix("db_keys")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	flags := cmd.Flags()
+	flags.String("algorithm", "", fmt.Sprintf("Algorithm to generate a key for. Supported are: %s, and %s for remote configuration.", strings.Join(encrypt.All, ", "), credentialsAlgorithm))
+	flags.String("output", "", "File to write the private key to. The public key, if any, is written to the same file with the extension .pub.")
+	flags.String("name", "mysql-backup", "Owner of the key, as the subject of S/MIME certificates and the user ID of OpenPGP keys.")
+
+	return cmd
+}
+
+func keysSplitCmd() *cobra.Command {
+	var v *viper.Viper
+	var cmd = &cobra.Command{
+		Use:   "split",
+		Short: "split an encryption key into shares",
+		Long: `Split an encryption key into shares with Shamir's secret sharing, so that any threshold of the shares
+		reconstruct the key, but fewer reveal nothing about it. Hand each share to a different person, so that no
+		one of them alone can decrypt the backups. Restore takes the shares with key-share, and keys combine
+		reconstructs the key from them.
+		`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd, v)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := parseEncryptionKey(v)
+			if err != nil {
+				return err
+			}
+			if key == nil {
+				return fmt.Errorf("must set one of encryption key or path")
+			}
+			shares, err := shamir.Split(key, v.GetInt("shares"), v.GetInt("threshold"))
+			if err != nil {
+				return fmt.Errorf("error splitting key: %v", err)
+			}
+			cmd.SilenceUsage = true
+			out := cmd.OutOrStdout()
+			outputDir := v.GetString("output-dir")
+			for _, share := range shares {
+				if outputDir == "" {
+					_, _ = fmt.Fprintln(out, share.String())
+					continue
+				}
+				filename := filepath.Join(outputDir, fmt.Sprintf("share-%d", share.X))
+				if err := os.WriteFile(filename, []byte(share.String()+"\n"), 0o600); err != nil {
+					return fmt.Errorf("error writing share: %v", err)
+				}
+				_, _ = fmt.Fprintln(out, filename)
+			}
+			return nil
+		},
+	}
+	v = viper.New()
+	v.SetEnvPrefix("db_keys")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	flags := cmd.Flags()
+	flags.String("encryption-key", "", "Encryption key to split, base64-encoded, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Cannot be set with encryption-key-path.")
+	flags.String("encryption-key-path", "", "Path to the encryption key file to split. Cannot be set with encryption-key.")
+	flags.Int("shares", 0, "Number of shares to split the key into, at most 255.")
+	flags.Int("threshold", 0, "Number of shares needed to reconstruct the key, at least 2 and at most the number of shares.")
+	flags.String("output-dir", "", "Directory to write each share to its own file, share-1, share-2 and so on, readable only by the owner, instead of printing them one per line.")
+
+	return cmd
+}
+
+func keysCombineCmd() *cobra.Command {
+	var v *viper.Viper
+	var cmd = &cobra.Command{
+		Use:   "combine",
+		Short: "reconstruct an encryption key from its shares",
+		Long: `Reconstruct an encryption key from at least the threshold of the shares that keys split made. The key is
+		printed base64-encoded, as encryption-key takes it, or written to a file as it was before it was split.
+		To restore, pass the shares to restore with key-share instead, which never writes the key anywhere.
+		`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd, v)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := parseKeyShares(v.GetStringSlice("key-share"))
+			if err != nil {
+				return err
+			}
+			if key == nil {
+				return fmt.Errorf("no key shares given")
+			}
+			cmd.SilenceUsage = true
+			if output := v.GetString("output"); output != "" {
+				if err := os.WriteFile(output, key, 0o600); err != nil {
+					return fmt.Errorf("error writing key: %v", err)
+				}
+				return nil
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), base64.StdEncoding.EncodeToString(key))
+			return nil
+		},
+	}
+	v = viper.New()
+	v.SetEnvPrefix("db_keys")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	flags := cmd.Flags()
+	flags.StringSlice("key-share", []string{}, "Share of the encryption key, as keys split made it, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Accepts multiple shares.")
+	flags.String("output", "", "File to write the key to, readable only by the owner, instead of printing it.")
+
+	return cmd
+}
+
+// writeNewFile write a file that must not exist yet, with the permissions
+func writeNewFile(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseKeyShares resolve the shares of a split encryption key and reconstruct the key from them; nil if there
+// are none
+func parseKeyShares(values []string) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	shares := make([]shamir.Share, 0, len(values))
+	for _, value := range values {
+		resolved, err := secret.Resolve(value)
+		if err != nil {
+			return nil, fmt.Errorf("error getting key share: %v", err)
+		}
+		share, err := shamir.Parse(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key share: %v", err)
+		}
+		shares = append(shares, share)
+	}
+	key, err := shamir.Combine(shares)
+	if err != nil {
+		return nil, fmt.Errorf("error combining key shares: %v", err)
+	}
+	return key, nil
+}
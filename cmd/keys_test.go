@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runKeys run the keys command with the args, returning what it printed
+func runKeys(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd, err := rootCmd(newMockExecs())
+	require.NoError(t, err)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(append([]string{"keys"}, args...))
+	err = cmd.Execute()
+	return out.String(), err
+}
+
+func TestKeysCmd(t *testing.T) {
+	t.Parallel()
+	const key = "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="
+
+	t.Run("split and combine", func(t *testing.T) {
+		out, err := runKeys(t, "split", "--encryption-key", key, "--shares", "5", "--threshold", "3")
+		require.NoError(t, err)
+		shares := strings.Fields(out)
+		require.Len(t, shares, 5)
+
+		out, err = runKeys(t, "combine", "--key-share", shares[4], "--key-share", shares[0], "--key-share", shares[2])
+		require.NoError(t, err)
+		assert.Equal(t, key+"\n", out)
+
+		_, err = runKeys(t, "combine", "--key-share", shares[4], "--key-share", shares[0])
+		assert.ErrorContains(t, err, "need 3 shares")
+	})
+
+	t.Run("share files", func(t *testing.T) {
+		dir := t.TempDir()
+		keyPath := filepath.Join(dir, "key")
+		require.NoError(t, os.WriteFile(keyPath, []byte("AGE-SECRET-KEY-1ABC\n"), 0o600))
+		_, err := runKeys(t, "split", "--encryption-key-path", keyPath, "--shares", "3", "--threshold", "2", "--output-dir", dir)
+		require.NoError(t, err)
+		info, err := os.Stat(filepath.Join(dir, "share-3"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		output := filepath.Join(dir, "combined")
+		_, err = runKeys(t, "combine", "--key-share", "file:"+filepath.Join(dir, "share-1"), "--key-share", "file:"+filepath.Join(dir, "share-3"), "--output", output)
+		require.NoError(t, err)
+		combined, err := os.ReadFile(output)
+		require.NoError(t, err)
+		assert.Equal(t, "AGE-SECRET-KEY-1ABC\n", string(combined))
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		for _, algorithm := range append(slices.Clone(encrypt.All), credentialsAlgorithm) {
+			t.Run(algorithm, func(t *testing.T) {
+				output := filepath.Join(t.TempDir(), "key")
+				out, err := runKeys(t, "generate", "--algorithm", algorithm, "--output", output)
+				require.NoError(t, err)
+				info, err := os.Stat(output)
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+				assert.Contains(t, out, "private key: "+output+"\n")
+
+				symmetric := algorithm == string(encrypt.AlgoDirectAES256CBC) || algorithm == string(encrypt.AlgoChacha20Poly1305) || algorithm == string(encrypt.AlgoAES256GCM) || algorithm == string(encrypt.AlgoPBKDF2AES256CBC)
+				info, err = os.Stat(output + ".pub")
+				if symmetric {
+					assert.True(t, os.IsNotExist(err), "symmetric key has a public key file")
+				} else {
+					require.NoError(t, err)
+					assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+					assert.Contains(t, out, "public key: "+output+".pub\n")
+				}
+				assert.Equal(t, algorithm != string(encrypt.AlgoPBKDF2AES256CBC), strings.Contains(out, "fingerprint: "))
+
+				// never overwritten
+				_, err = runKeys(t, "generate", "--algorithm", algorithm, "--output", output)
+				assert.ErrorContains(t, err, "exists")
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := runKeys(t, "generate", "--output", filepath.Join(t.TempDir(), "key"))
+		assert.ErrorContains(t, err, "no algorithm")
+		_, err = runKeys(t, "generate", "--algorithm", "rot13", "--output", filepath.Join(t.TempDir(), "key"))
+		assert.ErrorContains(t, err, "unknown encryption format")
+		_, err = runKeys(t, "split", "--shares", "3", "--threshold", "2")
+		assert.ErrorContains(t, err, "must set one of encryption key or path")
+		_, err = runKeys(t, "split", "--encryption-key", key, "--shares", "3", "--threshold", "1")
+		assert.ErrorContains(t, err, "at least 2")
+		_, err = runKeys(t, "combine")
+		assert.ErrorContains(t, err, "no key shares")
+		_, err = runKeys(t, "combine", "--key-share", "file:testdata/key-share-1", "--key-share", "notashare")
+		assert.ErrorContains(t, err, "error parsing key share")
+	})
+}
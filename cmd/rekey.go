@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/databacker/mysql-backup/pkg/core"
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+func rekeyCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, error) {
+	if cmdConfig == nil {
+		return nil, fmt.Errorf("cmdConfig is nil")
+	}
+	var v *viper.Viper
+	var cmd = &cobra.Command{
+		Use:   "rekey",
+		Short: "change the master key of backups with envelope encryption",
+		Long: `Change the master key of backups made with envelope encryption, i.e. with encryption-master-key.
+		The data key of every backup on the target that is wrapped with the old master key is wrapped with the
+		new one instead, and the header of the file replaced, without encrypting the backup again. Data keys
+		wrapped with other master keys are kept. Backups are verified against their checksums before they are
+		changed, and their checksums are replaced. Signed backups need the signing key, which must have made
+		their signature, to sign them again.
+		`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd, v)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmdConfig.logger.Debug("starting rekey")
+			ctx := context.Background()
+			// this is the tracer that we will use throughout the entire run
+			defer func() {
+				tp := getTracerProvider()
+				_ = tp.ForceFlush(ctx)
+				_ = tp.Shutdown(ctx)
+			}()
+			tracer := getTracer("rekey")
+			ctx = util.ContextWithTracer(ctx, tracer)
+			_, startupSpan := tracer.Start(ctx, "startup")
+
+			targetURL := v.GetString("target")
+			if targetURL == "" {
+				return fmt.Errorf("no target specified")
+			}
+			target, err := parseTarget(targetURL, cmdConfig)
+			if err != nil {
+				return fmt.Errorf("error parsing target: %v", err)
+			}
+			if v.GetString("old-key") == "" || v.GetString("new-key") == "" {
+				return fmt.Errorf("both old and new master keys must be set")
+			}
+			oldKey, err := parseMasterKey(v.GetString("old-key"))
+			if err != nil {
+				return fmt.Errorf("invalid old key: %v", err)
+			}
+			newKey, err := parseMasterKey(v.GetString("new-key"))
+			if err != nil {
+				return fmt.Errorf("invalid new key: %v", err)
+			}
+
+			signer, err := parseSigningKey(v)
+			if err != nil {
+				return err
+			}
+
+			var executor execs
+			executor = &core.Executor{}
+			if passedExecs != nil {
+				executor = passedExecs
+			}
+			executor.SetLogger(cmdConfig.logger)
+			// done with the startup
+			startupSpan.End()
+
+			if err := executor.Rekey(ctx, core.RekeyOptions{Target: target, OldKey: oldKey, NewKey: newKey, Signer: signer, Run: uuid.New()}); err != nil {
+				return fmt.Errorf("error running rekey: %w", err)
+			}
+			executor.GetLogger().Info("Rekey complete")
+			return nil
+		},
+	}
+	v = viper.New()
+	v.SetEnvPrefix("db_rekey")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	flags := cmd.Flags()
+	flags.String("target", "", "full URL of the directory where the backups are stored. Can be a file URL, or a reference to a target in the configuration file, e.g. config://targetname.")
+	flags.String("old-key", "", "The master key that the data keys are wrapped with now, base64-encoded, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
+	flags.String("new-key", "", "The master key to wrap the data keys with instead, base64-encoded, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
+	flags.String("signing-key", "", "minisign secret key that signed the backups, to sign them again once they are changed, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Required if any of the backups are signed.")
+	flags.String("signing-key-password", "", "Password of the signing key, if it is encrypted, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
+
+	return cmd, nil
+}
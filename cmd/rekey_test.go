@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/databacker/mysql-backup/pkg/core"
+	"github.com/databacker/mysql-backup/pkg/storage/file"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRekeyCmd(t *testing.T) {
+	t.Parallel()
+	fileTarget := "file:///foo/bar"
+	fileTargetURL, _ := url.Parse(fileTarget)
+	const (
+		oldKey = "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="
+		newKey = "QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWY="
+	)
+
+	tests := []struct {
+		name                 string
+		args                 []string // "rekey" will be prepended automatically
+		wantErr              bool
+		expectedRekeyOptions core.RekeyOptions
+	}{
+		{"missing target", []string{"--old-key", oldKey, "--new-key", newKey}, true, core.RekeyOptions{}},
+		{"invalid target URL", []string{"--target", "def", "--old-key", oldKey, "--new-key", newKey}, true, core.RekeyOptions{}},
+		{"missing new key", []string{"--target", fileTarget, "--old-key", oldKey}, true, core.RekeyOptions{}},
+		{"key too short", []string{"--target", fileTarget, "--old-key", oldKey, "--new-key", "MTIz"}, true, core.RekeyOptions{}},
+		{"key reference not found", []string{"--target", fileTarget, "--old-key", "file:testdata/missing", "--new-key", newKey}, true, core.RekeyOptions{}},
+		{"signing key reference not found", []string{"--target", fileTarget, "--old-key", oldKey, "--new-key", newKey, "--signing-key", "file:testdata/missing"}, true, core.RekeyOptions{}},
+		{"file URL", []string{"--target", fileTarget, "--old-key", oldKey, "--new-key", newKey}, false, core.RekeyOptions{
+			Target: file.New(*fileTargetURL),
+			OldKey: []byte("12345678901234567890123456789012"),
+			NewKey: []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdef"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockExecs()
+			m.On("Rekey", mock.MatchedBy(func(rekeyOpts core.RekeyOptions) bool {
+				if equalIgnoreFields(rekeyOpts, tt.expectedRekeyOptions, []string{"Run"}) {
+					return true
+				}
+				t.Errorf("rekeyOpts compare failed: %#v %#v", rekeyOpts, tt.expectedRekeyOptions)
+				return false
+			})).Return(nil)
+			cmd, err := rootCmd(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd.SetOutput(io.Discard)
+			cmd.SetArgs(append([]string{"rekey"}, tt.args...))
+			err = cmd.Execute()
+			switch {
+			case err == nil && tt.wantErr:
+				t.Fatal("missing error")
+			case err != nil && !tt.wantErr:
+				t.Fatal(err)
+			case err == nil:
+				m.AssertExpectations(t)
+			}
+		})
+	}
+}
@@ -9,10 +9,11 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/databacker/api/go/api"
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/core"
-	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/secret"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/util"
 )
 
@@ -55,19 +56,13 @@ func restoreCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command,
 				}
 			}
 
-			// compression algorithm: check config, then CLI/env var overrides
+			// compression algorithm: only from the CLI/env var, not the dump compression of the config file, which
+			// older backups need not have; otherwise it is read from the header of the file, or detected
 			var (
-				compressionAlgo string
-				compressor      compression.Compressor
-				err             error
+				compressor compression.Compressor
+				err        error
 			)
-			if cmdConfig.configuration != nil && cmdConfig.configuration.Dump.Compression != nil {
-				compressionAlgo = *cmdConfig.configuration.Dump.Compression
-			}
-			compressionVar := v.GetString("compression")
-			if compressionVar != "" {
-				compressionAlgo = compressionVar
-			}
+			compressionAlgo := v.GetString("compression")
 			if compressionAlgo != "" {
 				compressor, err = compression.GetCompressor(compressionAlgo)
 				if err != nil {
@@ -81,40 +76,47 @@ func restoreCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command,
 				cmdConfig.dbconn.MaxAllowedPacket = maxAllowedPacket
 			}
 
-			// target URL can reference one from the config file, or an absolute one
-			// if it's not in the config file, it's an absolute one
-			// if it is in the config file, it's a reference to one of the targets in the config file
-			u, err := util.SmartParse(target)
+			downloadRateLimit, err := parseRateLimit(v, "download-rate-limit")
 			if err != nil {
-				return fmt.Errorf("invalid target url: %v", err)
+				return err
 			}
-			var store storage.Storage
-			if u.Scheme == "config" {
-				// get the target name
-				targetName := u.Host
-				// get the target from the config file
-				if cmdConfig.configuration == nil {
-					return fmt.Errorf("no configuration file found")
-				}
-				var targetStructures map[string]api.Target
-				if cmdConfig.configuration.Targets != nil {
-					targetStructures = *cmdConfig.configuration.Targets
-				}
 
-				if target, ok := targetStructures[targetName]; !ok {
-					return fmt.Errorf("target %s not found in configuration", targetName)
-				} else {
-					if store, err = storage.FromTarget(target); err != nil {
-						return fmt.Errorf("error creating storage for target %s: %v", targetName, err)
-					}
+			// encryption: the algorithm is recorded in the file, other than in the legacy layout, so only the key is needed
+			encryptionKey, err := parseEncryptionKey(v)
+			if err != nil {
+				return err
+			}
+			encryptionAlgo := v.GetString("encryption")
+			if encryptionAlgo != "" && encryptionKey == nil {
+				return fmt.Errorf("must set at least one of encryption key, path or shares in CLI")
+			}
+			encryptionKeyPassword, err := secret.Resolve(v.GetString("encryption-key-password"))
+			if err != nil {
+				return fmt.Errorf("error getting encryption key password from CLI flag: %v", err)
+			}
+
+			// signature verification
+			var signatureKeys []signature.PublicKey
+			for _, value := range v.GetStringSlice("signature-public-key") {
+				resolved, err := secret.Resolve(value)
+				if err != nil {
+					return fmt.Errorf("error getting signature public key: %v", err)
 				}
-				// need to add the path to the specific target file
-			} else {
-				// parse the target URL
-				store, err = storage.ParseURL(target, cmdConfig.creds)
+				keys, err := signature.ParsePublicKeys([]byte(resolved))
 				if err != nil {
-					return fmt.Errorf("invalid target url: %v", err)
+					return fmt.Errorf("error parsing signature public key: %v", err)
 				}
+				signatureKeys = append(signatureKeys, keys...)
+			}
+			requireSignature := v.GetBool("require-signature")
+			if requireSignature && len(signatureKeys) == 0 {
+				return fmt.Errorf("require-signature needs at least one signature public key")
+			}
+
+			// target URL can reference one from the config file, or an absolute one
+			store, err := parseTarget(target, cmdConfig)
+			if err != nil {
+				return err
 			}
 			var executor execs
 			executor = &core.Executor{}
@@ -127,12 +129,19 @@ func restoreCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command,
 			cmd.SilenceUsage = true
 			uid := uuid.New()
 			restoreOpts := core.RestoreOptions{
-				Target:       store,
-				TargetFile:   targetFile,
-				Compressor:   compressor,
-				DatabasesMap: databasesMap,
-				DBConn:       cmdConfig.dbconn,
-				Run:          uid,
+				Target:                store,
+				TargetFile:            targetFile,
+				Compressor:            compressor,
+				DatabasesMap:          databasesMap,
+				DBConn:                cmdConfig.dbconn,
+				Run:                   uid,
+				SkipChecksum:          v.GetBool("skip-checksum"),
+				DownloadRateLimit:     downloadRateLimit,
+				Encryption:            encryptionAlgo,
+				EncryptionKey:         encryptionKey,
+				EncryptionKeyPassword: encryptionKeyPassword,
+				SignatureKeys:         signatureKeys,
+				RequireSignature:      requireSignature,
 			}
 			startupSpan.End()
 			if err := executor.Restore(ctx, restoreOpts); err != nil {
@@ -155,7 +164,18 @@ func restoreCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command,
 	}
 
 	// compression
-	flags.String("compression", defaultCompression, "Compression to use. Supported are: `gzip`, `bzip2`, `none`")
+	flags.String("compression", "", "Compression of the backup file, if it has no header that records it. Supported are: `gzip`, `pgzip`, `bzip2`, `zstd`, `xz`, `none`. Default is to read it from the header, or detect it from the file.")
+
+	// encryption
+	flags.String("encryption", "", fmt.Sprintf("Encryption algorithm of the backup file. Needed only for encrypted backups made before the algorithm was recorded in the file, which are decrypted after uncompressing them. Supported are: %s.", strings.Join(encrypt.All, ", ")))
+	flags.String("encryption-key", "", "Encryption key to decrypt the backup file with, base64-encoded, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Required if the backup file is encrypted. Cannot be set with encryption-key-path.")
+	flags.String("encryption-key-path", "", "Path to the encryption key file to decrypt the backup file with. Cannot be set with encryption-key.")
+	flags.StringSlice("key-share", []string{}, "Share of the encryption key to decrypt the backup file with, as keys split made it, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Accepts multiple shares; the key is reconstructed in memory from at least the threshold of them. Cannot be set with encryption-key or encryption-key-path.")
+	flags.String("encryption-key-password", "", "Password of the encryption key, for keys protected by one, e.g. a PKCS#12 file for smime-aes256-cbc or an OpenPGP private key, or a reference to it, e.g. env:VAR, file:/path or exec:command args.")
+
+	// signature verification
+	flags.StringSlice("signature-public-key", []string{}, "minisign public key to verify the signature of the backup file with, or a reference to it, e.g. env:VAR, file:/path or exec:command args. Accepts multiple keys. If the backup file is signed, restore fails unless the signature is by one of them.")
+	flags.Bool("require-signature", false, "Fail unless the backup file has a valid signature by one of the signature public keys, checked before anything is restored.")
 
 	// specific database to which to restore
 	flags.String("database", "", "Mapping of from:to database names to which to restore, comma-separated, e.g. foo:bar,buz:qux. Replaces the `USE <database>` clauses in a backup file. If blank, uses the file as is.")
@@ -169,5 +189,11 @@ func restoreCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command,
 	// max-allowed-packet size
 	flags.Int("max-allowed-packet", 0, "Maximum size of the buffer for client/server communication, similar to mysql's max_allowed_packet. 0 means to use the default size.")
 
+	// checksum verification
+	flags.Bool("skip-checksum", false, "Do not verify the backup file against its checksums before restoring it.")
+
+	// bandwidth limit
+	flags.String("download-rate-limit", "", "The most bytes per second to download the backup file. "+rateLimitUsage)
+
 	return cmd, nil
 }
@@ -3,11 +3,13 @@ package cmd
 import (
 	"io"
 	"net/url"
+	"os"
 	"testing"
 
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/core"
 	"github.com/databacker/mysql-backup/pkg/database"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/storage/file"
 	"github.com/stretchr/testify/mock"
 )
@@ -17,6 +19,14 @@ func TestRestoreCmd(t *testing.T) {
 
 	fileTarget := "file:///foo/bar"
 	fileTargetURL, _ := url.Parse(fileTarget)
+	publicKey, err := os.ReadFile("testdata/minisign.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys, err := signature.ParsePublicKeys(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tests := []struct {
 		name                   string
@@ -33,7 +43,20 @@ func TestRestoreCmd(t *testing.T) {
 		{"missing server and target options", []string{""}, "", true, core.RestoreOptions{}},
 		{"invalid target URL", []string{"--server", "abc", "--target", "def"}, "", true, core.RestoreOptions{}},
 		{"valid URL missing dump filename", []string{"--server", "abc", "--target", "file:///foo/bar"}, "", true, core.RestoreOptions{}},
-		{"valid file URL", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--verbose", "2"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, Compressor: &compression.GzipCompressor{}}},
+		{"valid file URL", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--verbose", "2"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}}},
+		{"explicit compression", []string{"--server", "abc", "--target", fileTarget, "filename.tar.zst", "--compression", "zstd"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tar.zst", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, Compressor: &compression.ZstdCompressor{}}},
+		{"dump compression in config file", []string{"--config-file", "testdata/compression.yml", "--target", fileTarget, "filename.tgz"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz", DBConn: &database.Connection{Host: "abcd", Port: 3306, User: "user2", Pass: "xxxx2"}, DatabasesMap: map[string]string{}}},
+		{"encryption key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz.enc", "--encryption-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz.enc", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, EncryptionKey: []byte("12345678901234567890123456789012")}},
+		{"legacy encryption", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--encryption", "chacha20-poly1305", "--encryption-key", "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, Encryption: "chacha20-poly1305", EncryptionKey: []byte("12345678901234567890123456789012")}},
+		{"encryption key password", []string{"--server", "abc", "--target", fileTarget, "filename.tgz.enc", "--encryption-key", "MTIz", "--encryption-key-password", "secret"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz.enc", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, EncryptionKey: []byte("123"), EncryptionKeyPassword: "secret"}},
+		{"key shares", []string{"--server", "abc", "--target", fileTarget, "filename.tgz.enc", "--key-share", "file:testdata/key-share-1", "--key-share", "file:testdata/key-share-3"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz.enc", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, EncryptionKey: []byte("12345678901234567890123456789012")}},
+		{"too few key shares", []string{"--server", "abc", "--target", fileTarget, "filename.tgz.enc", "--key-share", "file:testdata/key-share-2"}, "", true, core.RestoreOptions{}},
+		{"key shares and encryption key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz.enc", "--key-share", "file:testdata/key-share-1", "--key-share", "file:testdata/key-share-2", "--encryption-key", "MTIz"}, "", true, core.RestoreOptions{}},
+		{"signature public key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--signature-public-key", "file:testdata/minisign.pub", "--require-signature"}, "", false, core.RestoreOptions{Target: file.New(*fileTargetURL), TargetFile: "filename.tgz", DBConn: &database.Connection{Host: "abc", Port: defaultPort}, DatabasesMap: map[string]string{}, SignatureKeys: publicKeys, RequireSignature: true}},
+		{"require signature without public key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--require-signature"}, "", true, core.RestoreOptions{}},
+		{"invalid signature public key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--signature-public-key", "file:testdata/password.txt"}, "", true, core.RestoreOptions{}},
+		{"encryption without key", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--encryption", "chacha20-poly1305"}, "", true, core.RestoreOptions{}},
+		{"encryption key and path", []string{"--server", "abc", "--target", fileTarget, "filename.tgz", "--encryption-key", "MTIz", "--encryption-key-path", "/foo"}, "", true, core.RestoreOptions{}},
 	}
 
 	for _, tt := range tests {
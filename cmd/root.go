@@ -21,6 +21,7 @@ import (
 	"github.com/databacker/mysql-backup/pkg/core"
 	"github.com/databacker/mysql-backup/pkg/database"
 	"github.com/databacker/mysql-backup/pkg/remote"
+	"github.com/databacker/mysql-backup/pkg/secret"
 	"github.com/databacker/mysql-backup/pkg/storage/credentials"
 )
 
@@ -30,17 +31,20 @@ type execs interface {
 	Dump(ctx context.Context, opts core.DumpOptions) (core.DumpResults, error)
 	Restore(ctx context.Context, opts core.RestoreOptions) error
 	Prune(ctx context.Context, opts core.PruneOptions) error
+	Sync(ctx context.Context, opts core.SyncOptions) error
+	Rekey(ctx context.Context, opts core.RekeyOptions) error
 	Timer(timerOpts core.TimerOptions, cmd func() error) error
 }
 
 type subCommand func(execs, *cmdConfiguration) (*cobra.Command, error)
 
-var subCommands = []subCommand{dumpCmd, restoreCmd, pruneCmd}
+var subCommands = []subCommand{dumpCmd, restoreCmd, pruneCmd, syncCmd, rekeyCmd, keysCmd}
 
 type cmdConfiguration struct {
 	dbconn        *database.Connection
 	creds         credentials.Creds
 	configuration *api.ConfigSpec
+	extensions    *config.Extensions
 	logger        *log.Logger
 }
 
@@ -102,7 +106,7 @@ func rootCmd(execs execs) (*cobra.Command, error) {
 					return fmt.Errorf("fatal error config file: %w", err)
 				}
 				defer func() { _ = f.Close() }()
-				actualConfig, err = config.ProcessConfig(f)
+				actualConfig, cmdConfig.extensions, err = config.ProcessConfigWithExtensions(f)
 				if err != nil {
 					return fmt.Errorf("unable to read provided config: %w", err)
 				}
@@ -141,7 +145,11 @@ func rootCmd(execs execs) (*cobra.Command, error) {
 					if err != nil {
 						return fmt.Errorf("invalid telemetry URL: %w", err)
 					}
-					tlsConfig, err := remote.GetTLSConfig(u.Hostname(), *actualConfig.Telemetry.Certificates, *actualConfig.Telemetry.Credentials)
+					telemetryCredentials, err := secret.Resolve(*actualConfig.Telemetry.Credentials)
+					if err != nil {
+						return fmt.Errorf("unable to set up telemetry: %w", err)
+					}
+					tlsConfig, err := remote.GetTLSConfig(u.Hostname(), *actualConfig.Telemetry.Certificates, telemetryCredentials)
 					if err != nil {
 						return fmt.Errorf("unable to set up telemetry: %w", err)
 					}
@@ -204,12 +212,30 @@ func rootCmd(execs execs) (*cobra.Command, error) {
 					AccessKeyID:     v.GetString("aws-access-key-id"),
 					SecretAccessKey: v.GetString("aws-secret-access-key"),
 					Region:          v.GetString("aws-region"),
+
+					ServerSideEncryption: v.GetString("aws-sse"),
+					SSEKMSKeyID:          v.GetString("aws-sse-kms-key-id"),
+					SSECustomerKey:       v.GetString("aws-sse-customer-key"),
+					StorageClass:         v.GetString("aws-storage-class"),
+					Tags:                 v.GetStringMapString("aws-tags"),
+					Metadata:             v.GetStringMapString("aws-metadata"),
+					ACL:                  v.GetString("aws-acl"),
+					ChecksumAlgorithm:    v.GetString("aws-checksum-algorithm"),
+					ObjectLockMode:       v.GetString("aws-object-lock-mode"),
+					ObjectLockLegalHold:  v.GetBool("aws-object-lock-legal-hold"),
 				},
 				SMB: credentials.SMBCreds{
 					Username: v.GetString("smb-user"),
 					Password: v.GetString("smb-pass"),
 					Domain:   v.GetString("smb-domain"),
 				},
+				HTTP: credentials.HTTPCreds{
+					Method:      v.GetString("http-method"),
+					BearerToken: v.GetString("http-bearer-token"),
+					ClientCert:  v.GetString("http-client-cert"),
+					ClientKey:   v.GetString("http-client-key"),
+					CACert:      v.GetString("http-ca-cert"),
+				},
 			}
 			cmdConfig.logger = logger
 
@@ -248,7 +274,7 @@ func rootCmd(execs execs) (*cobra.Command, error) {
 	pflags.String("user", "", "username for database server")
 
 	// pass via CLI or env var
-	pflags.String("pass", "", "password for database server")
+	pflags.String("pass", "", "password for database server, or a reference to it, e.g. env:VAR, file:/path or exec:command args")
 
 	// pass-file via CLI or env var
 	pflags.String("pass-file", "", "path to file containing password for database server")
@@ -261,15 +287,32 @@ func rootCmd(execs execs) (*cobra.Command, error) {
 	// aws options
 	pflags.String("aws-endpoint-url", "", "Specify an alternative endpoint for s3 interoperable systems e.g. Digitalocean; ignored if not using s3.")
 	pflags.Bool("aws-path-style", false, "Use path-style addressing of buckets instead of default virtual-host-style; ignored if not using s3.")
-	pflags.String("aws-access-key-id", "", "Access Key for s3 and s3 interoperable systems; ignored if not using s3.")
-	pflags.String("aws-secret-access-key", "", "Secret Access Key for s3 and s3 interoperable systems; ignored if not using s3.")
+	pflags.String("aws-access-key-id", "", "Access Key for s3 and s3 interoperable systems, or a reference to it, e.g. env:VAR; ignored if not using s3.")
+	pflags.String("aws-secret-access-key", "", "Secret Access Key for s3 and s3 interoperable systems, or a reference to it, e.g. env:VAR; ignored if not using s3.")
 	pflags.String("aws-region", "", "Region for s3 and s3 interoperable systems; ignored if not using s3.")
+	pflags.String("aws-sse", "", "Server-side encryption for uploaded objects, one of AES256 (SSE-S3), aws:kms (SSE-KMS), aws:kms:dsse; ignored if not using s3.")
+	pflags.String("aws-sse-kms-key-id", "", "KMS key ID or ARN for SSE-KMS, implies --aws-sse=aws:kms; ignored if not using s3.")
+	pflags.String("aws-sse-customer-key", "", "Base64-encoded 256-bit key for SSE-C, needed for both backup and restore, or a reference to it, e.g. file:/path; ignored if not using s3.")
+	pflags.String("aws-storage-class", "", "Storage class for uploaded objects, e.g. STANDARD_IA, GLACIER_IR; ignored if not using s3.")
+	pflags.StringToString("aws-tags", nil, "Tags for uploaded objects, as key=value pairs; ignored if not using s3.")
+	pflags.StringToString("aws-metadata", nil, "User metadata for uploaded objects, as key=value pairs, in addition to the run ID, schemas and server UUID; ignored if not using s3.")
+	pflags.String("aws-acl", "", "Canned ACL for uploaded objects, e.g. private, bucket-owner-full-control; ignored if not using s3.")
+	pflags.String("aws-checksum-algorithm", "", "Checksum algorithm for uploads, one of CRC32, CRC32C, SHA1, SHA256, CRC64NVME; ignored if not using s3.")
+	pflags.String("aws-object-lock-mode", "", "Object Lock mode for uploaded objects, GOVERNANCE or COMPLIANCE, retained until the date given by the time-based retention policy; ignored if not using s3.")
+	pflags.Bool("aws-object-lock-legal-hold", false, "Place an Object Lock legal hold on uploaded objects; ignored if not using s3.")
 
 	// smb options
 	pflags.String("smb-user", "", "SMB username")
-	pflags.String("smb-pass", "", "SMB username")
+	pflags.String("smb-pass", "", "SMB password, or a reference to it, e.g. env:VAR")
 	pflags.String("smb-domain", "", "SMB domain")
 
+	// http options
+	pflags.String("http-method", "", "Method for uploading to http(s) targets, PUT (default) or POST for a multipart form; ignored if not using http(s).")
+	pflags.String("http-bearer-token", "", "Bearer token for http(s) targets, or a reference to it, e.g. env:VAR; for basic auth, put the user and password in the URL; ignored if not using http(s).")
+	pflags.String("http-client-cert", "", "Path to PEM-encoded client certificate for mTLS to http(s) targets; ignored if not using http(s).")
+	pflags.String("http-client-key", "", "Path to PEM-encoded client key for mTLS to http(s) targets; ignored if not using http(s).")
+	pflags.String("http-ca-cert", "", "Path to PEM-encoded CA certificate to trust for http(s) targets, in addition to the system CAs; ignored if not using http(s).")
+
 	// override the DB_ for these
 	if err := v.BindEnv("smb-user", "SMB_USER"); err != nil {
 		return nil, err
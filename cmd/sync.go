@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/databacker/mysql-backup/pkg/core"
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+func syncCmd(passedExecs execs, cmdConfig *cmdConfiguration) (*cobra.Command, error) {
+	if cmdConfig == nil {
+		return nil, fmt.Errorf("cmdConfig is nil")
+	}
+	var v *viper.Viper
+	var cmd = &cobra.Command{
+		Use:   "sync",
+		Short: "copy backups from one target to others",
+		Long: `Copy backups from one target to one or more others, so that each has every backup in the source.
+		Backups that are missing from a destination, or whose size or checksum file differs from the source, are copied.
+		Optionally, remove files from the destinations that are not in the source, and apply a retention policy,
+		in which case only backups within the retention policy are copied, and the destinations are pruned.
+
+		If no destinations are given, uses the dump targets from the config file.
+		`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd, v)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmdConfig.logger.Debug("starting sync")
+			ctx := context.Background()
+			// this is the tracer that we will use throughout the entire run
+			defer func() {
+				tp := getTracerProvider()
+				_ = tp.ForceFlush(ctx)
+				_ = tp.Shutdown(ctx)
+			}()
+			tracer := getTracer("sync")
+			ctx = util.ContextWithTracer(ctx, tracer)
+			_, startupSpan := tracer.Start(ctx, "startup")
+
+			fromURL := v.GetString("from")
+			if fromURL == "" {
+				return fmt.Errorf("no source specified")
+			}
+			from, err := parseTarget(fromURL, cmdConfig)
+			if err != nil {
+				return fmt.Errorf("error parsing source: %v", err)
+			}
+			to, err := parseTargets(v.GetStringSlice("to"), cmdConfig)
+			if err != nil {
+				return fmt.Errorf("error parsing targets: %v", err)
+			}
+			if len(to) == 0 {
+				return fmt.Errorf("no targets specified")
+			}
+			retention := v.GetString("retention")
+			deleteExtra := v.GetBool("delete")
+			uploadRateLimit, err := parseRateLimit(v, "upload-rate-limit")
+			if err != nil {
+				return err
+			}
+			downloadRateLimit, err := parseRateLimit(v, "download-rate-limit")
+			if err != nil {
+				return err
+			}
+
+			// timer options
+			timerOpts := parseTimerOptions(v, cmdConfig.configuration)
+
+			var executor execs
+			executor = &core.Executor{}
+			if passedExecs != nil {
+				executor = passedExecs
+			}
+			executor.SetLogger(cmdConfig.logger)
+			// done with the startup
+			startupSpan.End()
+
+			if err := executor.Timer(timerOpts, func() error {
+				uid := uuid.New()
+				return executor.Sync(ctx, core.SyncOptions{
+					From:              from,
+					To:                to,
+					Delete:            deleteExtra,
+					Retention:         retention,
+					Run:               uid,
+					UploadRateLimit:   uploadRateLimit,
+					DownloadRateLimit: downloadRateLimit,
+				})
+			}); err != nil {
+				return fmt.Errorf("error running sync: %w", err)
+			}
+			executor.GetLogger().Info("Sync complete")
+			return nil
+		},
+	}
+	v = viper.New()
+	v.SetEnvPrefix("db_sync")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	flags := cmd.Flags()
+	flags.String("from", "", "full URL of the directory to copy backups from. Can be a file URL, or a reference to a target in the configuration file, e.g. `config://targetname`.")
+	flags.StringSlice("to", []string{}, "full URL of a directory to copy backups to. Accepts multiple targets. Can be a file URL, or a reference to a target in the configuration file, e.g. `config://targetname`. If not provided, uses the dump targets from the configuration file.")
+
+	// delete
+	flags.Bool("delete", false, "remove files from the destinations that are not in the source, or are outside of the retention policy")
+
+	// retention
+	flags.String("retention", "", "Retention period for backups. Optional. If specified, only backups within the retention period are copied, and the destinations are pruned. Can be number of backups or time-based. For time-based, the format is: 1d, 1w, 1m, 1y for days, weeks, months, years, respectively. For number-based, the format is: 1c, 2c, 3c, etc. for the count of backups to keep.")
+
+	// bandwidth limits
+	flags.String("upload-rate-limit", "", "The most bytes per second to copy to all of the destinations together. "+rateLimitUsage)
+	flags.String("download-rate-limit", "", "The most bytes per second to copy from the source. "+rateLimitUsage)
+
+	// frequency
+	flags.Int("frequency", defaultFrequency, "how often to run syncs, in minutes")
+
+	// begin
+	flags.String("begin", defaultBegin, "What time to do the first sync. Must be in one of two formats: Absolute: HHMM, e.g. `2330` or `0415`; or Relative: +MM, i.e. how many minutes after starting the container, e.g. `+0` (immediate), `+10` (in 10 minutes), or `+90` in an hour and a half")
+
+	// cron
+	flags.String("cron", "", "Set the sync schedule using standard [crontab syntax](https://en.wikipedia.org/wiki/Cron), a single line.")
+
+	// once
+	flags.Bool("once", false, "Override all other settings and run the sync once immediately and exit. Useful if you use an external scheduler and don't want the container to do the scheduling internally.")
+
+	return cmd, nil
+}
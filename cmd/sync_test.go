@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/core"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/storage/file"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncCmd(t *testing.T) {
+	t.Parallel()
+	fromTarget := "file:///foo/bar"
+	fromTargetURL, _ := url.Parse(fromTarget)
+	toTarget := "file:///foo/baz"
+	toTargetURL, _ := url.Parse(toTarget)
+	otherTarget := "file:///foo/qux"
+	otherTargetURL, _ := url.Parse(otherTarget)
+
+	tests := []struct {
+		name                 string
+		args                 []string // "sync" will be prepended automatically
+		wantErr              bool
+		expectedSyncOptions  core.SyncOptions
+		expectedTimerOptions core.TimerOptions
+	}{
+		{"no source", []string{"--to", toTarget}, true, core.SyncOptions{}, core.TimerOptions{}},
+		{"no destination", []string{"--from", fromTarget}, true, core.SyncOptions{}, core.TimerOptions{}},
+		{"invalid source URL", []string{"--from", "def", "--to", toTarget}, true, core.SyncOptions{}, core.TimerOptions{}},
+		{"file URLs", []string{"--from", fromTarget, "--to", toTarget}, false, core.SyncOptions{From: file.New(*fromTargetURL), To: []storage.Storage{file.New(*toTargetURL)}}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}},
+		{"multiple destinations", []string{"--from", fromTarget, "--to", toTarget, "--to", otherTarget}, false, core.SyncOptions{From: file.New(*fromTargetURL), To: []storage.Storage{file.New(*toTargetURL), file.New(*otherTargetURL)}}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}},
+		{"delete and retention", []string{"--from", fromTarget, "--to", toTarget, "--delete", "--retention", "2d", "--once"}, false, core.SyncOptions{From: file.New(*fromTargetURL), To: []storage.Storage{file.New(*toTargetURL)}, Delete: true, Retention: "2d"}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin, Once: true}},
+		{"rate limits", []string{"--from", fromTarget, "--to", toTarget, "--upload-rate-limit", "1M", "--download-rate-limit", "22:00-06:00=0,100K"}, false, core.SyncOptions{From: file.New(*fromTargetURL), To: []storage.Storage{file.New(*toTargetURL)}, UploadRateLimit: ratelimit.Schedule{Default: 1 << 20}, DownloadRateLimit: ratelimit.Schedule{Default: 100 << 10, Windows: []ratelimit.Window{{Start: 22 * time.Hour, End: 6 * time.Hour}}}}, core.TimerOptions{Frequency: defaultFrequency, Begin: defaultBegin}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockExecs()
+			m.On("Sync", mock.MatchedBy(func(syncOpts core.SyncOptions) bool {
+				if equalIgnoreFields(syncOpts, tt.expectedSyncOptions, []string{"Run"}) {
+					return true
+				}
+				t.Errorf("syncOpts compare failed: %#v %#v", syncOpts, tt.expectedSyncOptions)
+				return false
+			})).Return(nil)
+			m.On("Timer", tt.expectedTimerOptions).Return(nil)
+			cmd, err := rootCmd(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd.SetOutput(io.Discard)
+			cmd.SetArgs(append([]string{"sync"}, tt.args...))
+			err = cmd.Execute()
+			switch {
+			case err == nil && tt.wantErr:
+				t.Fatal("missing error")
+			case err != nil && !tt.wantErr:
+				t.Fatal(err)
+			case err == nil:
+				m.AssertExpectations(t)
+			}
+		})
+	}
+}
@@ -6,9 +6,16 @@ import (
 	"github.com/dsnet/compress/bzip2"
 )
 
+const (
+	bzip2MinLevel = bzip2.BestSpeed
+	bzip2MaxLevel = bzip2.BestCompression
+)
+
 var _ Compressor = &Bzip2Compressor{}
 
 type Bzip2Compressor struct {
+	// Level compression level, from 1 to 9; 0 for the default
+	Level int
 }
 
 func (b *Bzip2Compressor) Uncompress(in io.Reader) (io.Reader, error) {
@@ -16,7 +23,10 @@ func (b *Bzip2Compressor) Uncompress(in io.Reader) (io.Reader, error) {
 }
 
 func (b *Bzip2Compressor) Compress(out io.Writer) (io.WriteCloser, error) {
-	return bzip2.NewWriter(out, nil)
+	return bzip2.NewWriter(out, &bzip2.WriterConfig{Level: b.Level})
+}
+func (b *Bzip2Compressor) Name() string {
+	return "bzip2"
 }
 func (b *Bzip2Compressor) Extension() string {
 	return "tbz2"
@@ -1,6 +1,8 @@
 package compression
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 )
@@ -8,18 +10,113 @@ import (
 type Compressor interface {
 	Uncompress(in io.Reader) (io.Reader, error)
 	Compress(out io.Writer) (io.WriteCloser, error)
+	// Name the name of the compression, as given to GetCompressor
+	Name() string
 	Extension() string
 }
 
-func GetCompressor(name string) (Compressor, error) {
+// Option an option for a compressor; each compressor ignores the options that do not apply to it
+type Option func(*options)
+
+type options struct {
+	level      int
+	threads    int
+	longWindow bool
+}
+
+// WithLevel the compression level, whose range depends on the compressor; 0 for the default of the compressor
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithThreads how many threads to compress with, for compressors that support it; 0 for one per CPU
+func WithThreads(threads int) Option {
+	return func(o *options) {
+		o.threads = threads
+	}
+}
+
+// WithLongWindow find matches further apart, using more memory, for compressors that support it
+func WithLongWindow() Option {
+	return func(o *options) {
+		o.longWindow = true
+	}
+}
+
+func GetCompressor(name string, opts ...Option) (Compressor, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.threads < 0 {
+		return nil, fmt.Errorf("invalid compression threads %d, must not be negative", o.threads)
+	}
 	switch name {
 	case "gzip":
-		return &GzipCompressor{}, nil
+		if err := checkLevel(name, o.level, gzipMinLevel, gzipMaxLevel); err != nil {
+			return nil, err
+		}
+		return &GzipCompressor{Level: o.level}, nil
+	case "pgzip":
+		if err := checkLevel(name, o.level, gzipMinLevel, gzipMaxLevel); err != nil {
+			return nil, err
+		}
+		return &PgzipCompressor{Level: o.level, Threads: o.threads}, nil
 	case "bzip2":
-		return &Bzip2Compressor{}, nil
+		if err := checkLevel(name, o.level, bzip2MinLevel, bzip2MaxLevel); err != nil {
+			return nil, err
+		}
+		return &Bzip2Compressor{Level: o.level}, nil
+	case "zstd":
+		if err := checkLevel(name, o.level, zstdMinLevel, zstdMaxLevel); err != nil {
+			return nil, err
+		}
+		return &ZstdCompressor{Level: o.level, Threads: o.threads, LongWindow: o.longWindow}, nil
+	case "xz":
+		if err := checkLevel(name, o.level, xzMinLevel, xzMaxLevel); err != nil {
+			return nil, err
+		}
+		return &XzCompressor{Level: o.level, Threads: o.threads}, nil
 	case "none":
 		return &NoCompressor{}, nil
 	default:
 		return nil, fmt.Errorf("unknown compression format: %s", name)
 	}
 }
+
+func checkLevel(name string, level, minLevel, maxLevel int) error {
+	if level != 0 && (level < minLevel || level > maxLevel) {
+		return fmt.Errorf("invalid %s compression level %d, must be between %d and %d", name, level, minLevel, maxLevel)
+	}
+	return nil
+}
+
+// magic the first bytes of the output of each compressor, by which to detect it
+var magic = []struct {
+	prefix     []byte
+	compressor Compressor
+}{
+	{[]byte{0x1f, 0x8b}, &GzipCompressor{}},
+	{[]byte("BZh"), &Bzip2Compressor{}},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, &ZstdCompressor{}},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, &XzCompressor{}},
+}
+
+// Detect the compressor of a compressed file from its first bytes. Anything that is not compressed
+// with a known compressor is assumed to be an uncompressed tar file. Returns the reader to uncompress,
+// which includes the bytes read to detect the compressor.
+func Detect(in io.Reader) (Compressor, io.Reader, error) {
+	br := bufio.NewReader(in)
+	header, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("failed to read compression header: %w", err)
+	}
+	for _, m := range magic {
+		if bytes.HasPrefix(header, m.prefix) {
+			return m.compressor, br, nil
+		}
+	}
+	return &NoCompressor{}, br, nil
+}
@@ -0,0 +1,122 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCompressor(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected Compressor
+		err      string
+	}{
+		{"gzip", nil, &GzipCompressor{}, ""},
+		{"gzip", []Option{WithLevel(9), WithThreads(4), WithLongWindow()}, &GzipCompressor{Level: 9}, ""},
+		{"gzip", []Option{WithLevel(10)}, nil, "invalid gzip compression level 10"},
+		{"pgzip", []Option{WithLevel(9), WithThreads(4), WithLongWindow()}, &PgzipCompressor{Level: 9, Threads: 4}, ""},
+		{"pgzip", []Option{WithLevel(10)}, nil, "invalid pgzip compression level 10"},
+		{"bzip2", []Option{WithLevel(1)}, &Bzip2Compressor{Level: 1}, ""},
+		{"bzip2", []Option{WithLevel(-1)}, nil, "invalid bzip2 compression level -1"},
+		{"zstd", nil, &ZstdCompressor{}, ""},
+		{"zstd", []Option{WithLevel(19), WithThreads(2), WithLongWindow()}, &ZstdCompressor{Level: 19, Threads: 2, LongWindow: true}, ""},
+		{"zstd", []Option{WithLevel(23)}, nil, "invalid zstd compression level 23"},
+		{"zstd", []Option{WithThreads(-1)}, nil, "invalid compression threads"},
+		{"xz", nil, &XzCompressor{}, ""},
+		{"xz", []Option{WithLevel(9), WithThreads(2)}, &XzCompressor{Level: 9, Threads: 2}, ""},
+		{"xz", []Option{WithLevel(10)}, nil, "invalid xz compression level 10"},
+		{"none", []Option{WithLevel(5)}, &NoCompressor{}, ""},
+		{"lz4", nil, nil, "unknown compression format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor, err := GetCompressor(tt.name, tt.opts...)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, compressor)
+		})
+	}
+}
+
+func TestCompressDetect(t *testing.T) {
+	data := []byte(strings.Repeat("INSERT INTO `t` VALUES (1,'abc');\n", 1000))
+	// larger than a block, so that it is compressed in parallel
+	large := []byte(strings.Repeat("INSERT INTO `t` VALUES (2,'def');\n", 100000))
+	tests := []struct {
+		name       string
+		compressor Compressor
+		data       []byte
+		detected   Compressor
+	}{
+		{"gzip", &GzipCompressor{}, data, &GzipCompressor{}},
+		{"gzip level", &GzipCompressor{Level: 1}, data, &GzipCompressor{}},
+		{"pgzip", &PgzipCompressor{Threads: 4}, large, &GzipCompressor{}},
+		{"bzip2 level", &Bzip2Compressor{Level: 9}, data, &Bzip2Compressor{}},
+		{"zstd", &ZstdCompressor{}, data, &ZstdCompressor{}},
+		{"zstd options", &ZstdCompressor{Level: 22, Threads: 1, LongWindow: true}, data, &ZstdCompressor{}},
+		{"xz", &XzCompressor{Threads: 1}, data, &XzCompressor{}},
+		{"xz parallel", &XzCompressor{Level: 1, Threads: 4}, large, &XzCompressor{}},
+		{"xz empty", &XzCompressor{Threads: 4}, nil, &XzCompressor{}},
+		{"none", &NoCompressor{}, data, &NoCompressor{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := tt.compressor.Compress(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(tt.data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			detected, r, err := Detect(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, tt.detected, detected)
+			ur, err := detected.Uncompress(r)
+			require.NoError(t, err)
+			out, err := io.ReadAll(ur)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.data), len(out))
+			assert.True(t, bytes.Equal(tt.data, out))
+		})
+	}
+}
+
+func TestXzParallelCloseTwice(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := (&XzCompressor{Threads: 4}).Compress(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte("def"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestXzThreadsMemoryLimit(t *testing.T) {
+	const dictCap = 64 << 20
+	w, err := (&XzCompressor{Level: 9}).Compress(io.Discard)
+	require.NoError(t, err)
+	threads := 1
+	if p, ok := w.(*xzParallelWriter); ok {
+		threads = cap(p.blocks)
+	}
+	assert.LessOrEqual(t, threads*xzThreadMemory(3*dictCap, dictCap), xzMemoryLimit)
+	require.NoError(t, w.Close())
+
+	// a count that is given is used, whatever the memory
+	w, err = (&XzCompressor{Level: 9, Threads: 4}).Compress(io.Discard)
+	require.NoError(t, err)
+	require.IsType(t, &xzParallelWriter{}, w)
+	assert.Equal(t, 4, cap(w.(*xzParallelWriter).blocks))
+	require.NoError(t, w.Close())
+}
@@ -5,9 +5,16 @@ import (
 	"io"
 )
 
+const (
+	gzipMinLevel = gzip.BestSpeed
+	gzipMaxLevel = gzip.BestCompression
+)
+
 var _ Compressor = &GzipCompressor{}
 
 type GzipCompressor struct {
+	// Level compression level, from 1 to 9; 0 for the default
+	Level int
 }
 
 func (g *GzipCompressor) Uncompress(in io.Reader) (io.Reader, error) {
@@ -15,7 +22,13 @@ func (g *GzipCompressor) Uncompress(in io.Reader) (io.Reader, error) {
 }
 
 func (g *GzipCompressor) Compress(out io.Writer) (io.WriteCloser, error) {
-	return gzip.NewWriter(out), nil
+	if g.Level == 0 {
+		return gzip.NewWriter(out), nil
+	}
+	return gzip.NewWriterLevel(out, g.Level)
+}
+func (g *GzipCompressor) Name() string {
+	return "gzip"
 }
 func (g *GzipCompressor) Extension() string {
 	return "tgz"
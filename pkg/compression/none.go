@@ -16,6 +16,9 @@ func (n *NoCompressor) Uncompress(in io.Reader) (io.Reader, error) {
 func (n *NoCompressor) Compress(out io.Writer) (io.WriteCloser, error) {
 	return &nopWriteCloser{out}, nil
 }
+func (n *NoCompressor) Name() string {
+	return "none"
+}
 func (n *NoCompressor) Extension() string {
 	return "tar"
 }
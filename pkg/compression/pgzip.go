@@ -0,0 +1,50 @@
+package compression
+
+import (
+	"io"
+
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	// pgzipBlockSize the size of each block that is compressed on its own thread
+	pgzipBlockSize = 1 << 20
+)
+
+var _ Compressor = &PgzipCompressor{}
+
+// PgzipCompressor gzip, compressing blocks in parallel. The output is standard gzip, which gunzip
+// and GzipCompressor can uncompress.
+type PgzipCompressor struct {
+	// Level compression level, from 1 to 9; 0 for the default
+	Level int
+	// Threads how many blocks to compress in parallel; 0 for one per CPU
+	Threads int
+}
+
+func (p *PgzipCompressor) Uncompress(in io.Reader) (io.Reader, error) {
+	return pgzip.NewReader(in)
+}
+
+func (p *PgzipCompressor) Compress(out io.Writer) (io.WriteCloser, error) {
+	level := p.Level
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+	w, err := pgzip.NewWriterLevel(out, level)
+	if err != nil {
+		return nil, err
+	}
+	if p.Threads != 0 {
+		if err := w.SetConcurrency(pgzipBlockSize, p.Threads); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+func (p *PgzipCompressor) Name() string {
+	return "pgzip"
+}
+func (p *PgzipCompressor) Extension() string {
+	return "tgz"
+}
@@ -0,0 +1,191 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	xzMinLevel = 1
+	xzMaxLevel = 9
+	// xzMinBlockSize the smallest block that is compressed on its own thread
+	xzMinBlockSize = 1 << 20
+	// xzMemoryLimit the most memory that compressing with one thread per CPU may take, as xz -T0 limits it
+	xzMemoryLimit = 1 << 30
+)
+
+// xzDictCaps the dictionary size for each level, the same as the presets of the xz command
+var xzDictCaps = [...]int{
+	1: 1 << 20,
+	2: 2 << 20,
+	3: 4 << 20,
+	4: 4 << 20,
+	5: 8 << 20,
+	6: 8 << 20,
+	7: 16 << 20,
+	8: 32 << 20,
+	9: 64 << 20,
+}
+
+var _ Compressor = &XzCompressor{}
+
+// XzCompressor xz, for the smallest backups, e.g. for long-term archival, at the cost of time.
+// With more than one thread, the input is split into blocks that are compressed in parallel,
+// each as its own xz stream. The streams are concatenated, which xz and unxz handle as one file.
+type XzCompressor struct {
+	// Level compression level, from 1 to 9, which sets the dictionary size as for the xz command;
+	// 0 for the default
+	Level int
+	// Threads how many blocks to compress in parallel; 0 for one per CPU, as many as fit in xzMemoryLimit.
+	// Each takes about two blocks and two dictionaries, see xzThreadMemory, e.g. 512 MiB at level 9.
+	Threads int
+}
+
+func (x *XzCompressor) Uncompress(in io.Reader) (io.Reader, error) {
+	return xz.NewReader(in)
+}
+
+func (x *XzCompressor) Compress(out io.Writer) (io.WriteCloser, error) {
+	config := xz.WriterConfig{}
+	if x.Level != 0 {
+		config.DictCap = xzDictCaps[x.Level]
+	}
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+	// blocks of three times the dictionary, as the xz command does, so that splitting costs little compression
+	blockSize := max(3*config.DictCap, xzMinBlockSize)
+	threads := x.Threads
+	if threads == 0 {
+		threads = max(1, min(runtime.NumCPU(), xzMemoryLimit/xzThreadMemory(blockSize, config.DictCap)))
+	}
+	if threads == 1 {
+		return config.NewWriter(out)
+	}
+	w := &xzParallelWriter{
+		config:    config,
+		blockSize: blockSize,
+		blocks:    make(chan chan xzBlock, threads),
+		done:      make(chan struct{}),
+	}
+	go w.write(out)
+	return w, nil
+}
+
+// xzThreadMemory about how much memory compressing a block on its own thread takes: the block, its compressed
+// output, and the dictionary and match finder of its encoder
+func xzThreadMemory(blockSize, dictCap int) int {
+	return 2*blockSize + 2*dictCap
+}
+
+func (x *XzCompressor) Name() string {
+	return "xz"
+}
+func (x *XzCompressor) Extension() string {
+	return "tar.xz"
+}
+
+// xzBlock a compressed block, or the error compressing it
+type xzBlock struct {
+	data []byte
+	err  error
+}
+
+// xzParallelWriter compress blocks in parallel, writing each in order, as its own xz stream
+type xzParallelWriter struct {
+	config    xz.WriterConfig
+	blockSize int
+	buf       []byte
+	// started whether any block was started, so that empty input still is a valid xz file
+	started bool
+	// closed whether Close was called, so that closing again does not close blocks again
+	closed bool
+	// blocks the blocks being compressed, in order; its capacity limits how many are compressed at once
+	blocks chan chan xzBlock
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (w *xzParallelWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := w.error(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		chunk := min(len(p), w.blockSize-len(w.buf))
+		w.buf = append(w.buf, p[:chunk]...)
+		p = p[chunk:]
+		if len(w.buf) == w.blockSize {
+			w.compress()
+		}
+	}
+	return n, nil
+}
+
+func (w *xzParallelWriter) Close() error {
+	if w.closed {
+		return w.error()
+	}
+	w.closed = true
+	if len(w.buf) > 0 || !w.started {
+		w.compress()
+	}
+	close(w.blocks)
+	<-w.done
+	return w.error()
+}
+
+// compress start compressing the buffered block, blocking while as many blocks as threads are in progress
+func (w *xzParallelWriter) compress() {
+	block := make(chan xzBlock, 1)
+	w.blocks <- block
+	w.started = true
+	go func(data []byte) {
+		var buf bytes.Buffer
+		xw, err := w.config.NewWriter(&buf)
+		if err == nil {
+			_, err = xw.Write(data)
+		}
+		if err == nil {
+			err = xw.Close()
+		}
+		block <- xzBlock{data: buf.Bytes(), err: err}
+	}(w.buf)
+	w.buf = make([]byte, 0, w.blockSize)
+}
+
+// write write the compressed blocks to out in order, until there are no more
+func (w *xzParallelWriter) write(out io.Writer) {
+	defer close(w.done)
+	for block := range w.blocks {
+		b := <-block
+		// after an error, keep receiving the blocks, so that Write and Close do not block
+		if w.error() != nil {
+			continue
+		}
+		err := b.err
+		if err == nil {
+			_, err = out.Write(b.data)
+		}
+		if err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *xzParallelWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
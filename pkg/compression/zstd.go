@@ -0,0 +1,55 @@
+package compression
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	zstdMinLevel = 1
+	zstdMaxLevel = 22
+	// zstdLongWindowSize the window for long distance matching, the same as `zstd --long`
+	zstdLongWindowSize = 1 << 27
+)
+
+var _ Compressor = &ZstdCompressor{}
+
+type ZstdCompressor struct {
+	// Level compression level, from 1 to 22 as for the zstd command, mapped to the nearest of the levels
+	// of the encoder: fastest, default, better and best; 0 for the default
+	Level int
+	// Threads how many threads to compress with; 0 for one per CPU
+	Threads int
+	// LongWindow use a 128 MiB window, to find matches further apart
+	LongWindow bool
+}
+
+func (z *ZstdCompressor) Uncompress(in io.Reader) (io.Reader, error) {
+	// the default maximum window is large enough for files compressed with a long window
+	d, err := zstd.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+func (z *ZstdCompressor) Compress(out io.Writer) (io.WriteCloser, error) {
+	var opts []zstd.EOption
+	if z.Level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(z.Level)))
+	}
+	if z.Threads != 0 {
+		opts = append(opts, zstd.WithEncoderConcurrency(z.Threads))
+	}
+	if z.LongWindow {
+		opts = append(opts, zstd.WithWindowSize(zstdLongWindowSize))
+	}
+	return zstd.NewWriter(out, opts...)
+}
+func (z *ZstdCompressor) Name() string {
+	return "zstd"
+}
+func (z *ZstdCompressor) Extension() string {
+	return "tar.zst"
+}
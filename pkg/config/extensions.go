@@ -0,0 +1,32 @@
+package config
+
+// Extensions configuration that is not part of the databacker api, read from the spec of the same
+// local configuration file, e.g.
+//
+//	spec:
+//	  dump:
+//	    targetPolicy:
+//	      required: [s3-primary]
+//	      minSuccess: 2
+type Extensions struct {
+	Dump *DumpExtensions `yaml:"dump,omitempty"`
+}
+
+// DumpExtensions additional configuration for dump
+type DumpExtensions struct {
+	TargetPolicy *TargetPolicy `yaml:"targetPolicy,omitempty"`
+	// CompressionLevel level for the compression, whose range depends on the compression; 0 for its default
+	CompressionLevel *int `yaml:"compressionLevel,omitempty"`
+	// CompressionThreads how many threads to compress with, for compressions that support it; 0 for one per CPU
+	CompressionThreads *int `yaml:"compressionThreads,omitempty"`
+	// CompressionLongWindow find matches further apart, for compressions that support it
+	CompressionLongWindow *bool `yaml:"compressionLongWindow,omitempty"`
+}
+
+// TargetPolicy what counts as a successful backup when there are several targets
+type TargetPolicy struct {
+	// Required names of targets that must receive the backup, must reference one of the named targets in the config
+	Required []string `yaml:"required,omitempty"`
+	// MinSuccess minimum number of targets that must receive the backup
+	MinSuccess int `yaml:"minSuccess,omitempty"`
+}
@@ -10,6 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 
 	"github.com/databacker/api/go/api"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -18,28 +20,38 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/databacker/mysql-backup/pkg/remote"
+	"github.com/databacker/mysql-backup/pkg/secret"
 )
 
 // ProcessConfig reads the configuration from a stream and returns the parsed configuration.
 // If the configuration is of type remote, it will retrieve the remote configuration.
 // Continues to process remotes until it gets a final valid ConfigSpec or fails.
 func ProcessConfig(r io.Reader) (actualConfig *api.ConfigSpec, err error) {
+	actualConfig, _, err = ProcessConfigWithExtensions(r)
+	return actualConfig, err
+}
+
+// ProcessConfigWithExtensions reads the configuration from a stream, like ProcessConfig, and returns
+// the parsed configuration, along with any Extensions in the same spec.
+func ProcessConfigWithExtensions(r io.Reader) (actualConfig *api.ConfigSpec, extensions *Extensions, err error) {
 	var (
 		conf        api.Config
 		credentials []string
+		// fromRemote whether the config was retrieved from a remote, and so must not reference local secrets
+		fromRemote bool
 	)
 	decoder := yaml.NewDecoder(r)
 	if err := decoder.Decode(&conf); err != nil {
-		return nil, fmt.Errorf("fatal error reading config file: %w", err)
+		return nil, nil, fmt.Errorf("fatal error reading config file: %w", err)
 	}
 
 	// check that the version is something we recognize
 	if conf.Version != api.ConfigDatabackIoV1 {
-		return nil, fmt.Errorf("unknown config version: %s", conf.Version)
+		return nil, nil, fmt.Errorf("unknown config version: %s", conf.Version)
 	}
 	specBytes, err := yaml.Marshal(conf.Spec)
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling spec part of configuration: %w", err)
+		return nil, nil, fmt.Errorf("error marshalling spec part of configuration: %w", err)
 	}
 	// if the config type is remote, retrieve our remote configuration
 	// repeat until we end up with a configuration that is of type local
@@ -59,19 +71,40 @@ func ProcessConfig(r io.Reader) (actualConfig *api.ConfigSpec, err error) {
 			// We fix this by converting the spec part of the config into json,
 			// as yaml is a valid subset of json, and then unmarshalling that.
 			if err := yaml.Unmarshal(specBytes, &spec); err != nil {
-				return nil, fmt.Errorf("parsed yaml had kind local, but spec invalid")
+				return nil, nil, fmt.Errorf("parsed yaml had kind local, but spec invalid")
+			}
+			var ext Extensions
+			if err := yaml.Unmarshal(specBytes, &ext); err != nil {
+				return nil, nil, fmt.Errorf("parsed yaml had kind local, but extensions invalid: %w", err)
 			}
-			actualConfig = &spec
+			if fromRemote {
+				if err := checkRemoteReferences(&spec); err != nil {
+					return nil, nil, fmt.Errorf("invalid remote config: %w", err)
+				}
+			}
+			actualConfig, extensions = &spec, &ext
 		case api.Remote:
 			var spec api.RemoteSpec
 			if err := yaml.Unmarshal(specBytes, &spec); err != nil {
-				return nil, fmt.Errorf("parsed yaml had kind remote, but spec invalid")
+				return nil, nil, fmt.Errorf("parsed yaml had kind remote, but spec invalid")
+			}
+			if spec.Credentials != nil {
+				if fromRemote {
+					if err := secret.CheckLocal(*spec.Credentials); err != nil {
+						return nil, nil, fmt.Errorf("invalid credentials for remote config: %w", err)
+					}
+				}
+				resolved, err := secret.Resolve(*spec.Credentials)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid credentials for remote config: %w", err)
+				}
+				spec.Credentials = &resolved
 			}
 			remoteConfig, err := getRemoteConfig(spec)
 			if err != nil {
-				return nil, fmt.Errorf("error parsing remote config: %w", err)
+				return nil, nil, fmt.Errorf("error parsing remote config: %w", err)
 			}
-			conf = remoteConfig
+			conf, fromRemote = remoteConfig, true
 			// save encryption key for later
 			if spec.Credentials != nil {
 				credentials = append(credentials, *spec.Credentials)
@@ -79,21 +112,58 @@ func ProcessConfig(r io.Reader) (actualConfig *api.ConfigSpec, err error) {
 		case api.Encrypted:
 			var spec api.EncryptedSpec
 			if err := yaml.Unmarshal(specBytes, &spec); err != nil {
-				return nil, fmt.Errorf("parsed yaml had kind encrypted, but spec invalid")
+				return nil, nil, fmt.Errorf("parsed yaml had kind encrypted, but spec invalid")
 			}
 			// now try to decrypt it
 			conf, err = decryptConfig(spec, credentials)
 			if err != nil {
-				return nil, fmt.Errorf("error decrypting config: %w", err)
+				return nil, nil, fmt.Errorf("error decrypting config: %w", err)
 			}
 		default:
-			return nil, fmt.Errorf("unknown config type: %s", conf.Kind)
+			return nil, nil, fmt.Errorf("unknown config type: %s", conf.Kind)
 		}
 		if actualConfig != nil {
 			break
 		}
 	}
-	return actualConfig, nil
+	return actualConfig, extensions, nil
+}
+
+// checkRemoteReferences reject references to secrets in config that was retrieved from a remote, since whoever
+// controls the remote could use them to read local files and environment variables, or run local commands.
+// Secrets in the spec of targets are checked for every string, as the fields that are secrets depend on the type.
+func checkRemoteReferences(spec *api.ConfigSpec) error {
+	values := map[string]*string{}
+	if spec.Database != nil && spec.Database.Credentials != nil {
+		values["database.credentials.password"] = spec.Database.Credentials.Password
+	}
+	if spec.Dump != nil && spec.Dump.Encryption != nil {
+		values["dump.encryption.key"] = spec.Dump.Encryption.Key
+	}
+	if spec.Telemetry != nil {
+		values["telemetry.credentials"] = spec.Telemetry.Credentials
+	}
+	if spec.Targets != nil {
+		for name, target := range *spec.Targets {
+			if target.Spec == nil {
+				continue
+			}
+			for field, value := range *target.Spec {
+				if s, ok := value.(string); ok {
+					values[fmt.Sprintf("targets.%s.spec.%s", name, field)] = &s
+				}
+			}
+		}
+	}
+	for _, field := range slices.Sorted(maps.Keys(values)) {
+		if values[field] == nil {
+			continue
+		}
+		if err := secret.CheckLocal(*values[field]); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	return nil
 }
 
 // getRemoteConfig given a RemoteSpec for a config, retrieve the config from the remote
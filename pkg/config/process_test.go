@@ -242,3 +242,37 @@ func TestDecryptConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckRemoteReferences(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		spec api.ConfigSpec
+		err  string
+	}{
+		{"none", api.ConfigSpec{}, ""},
+		{"plain secrets", api.ConfigSpec{
+			Database:  &api.Database{Credentials: &api.DBCredentials{Password: str("secret")}},
+			Telemetry: &api.Telemetry{Credentials: str("abc")},
+			Targets:   &map[string]api.Target{"local": {Type: api.TargetTypeFile, URL: "file:///backups"}},
+		}, ""},
+		{"literal", api.ConfigSpec{Database: &api.Database{Credentials: &api.DBCredentials{Password: str("literal:exec:abc")}}}, ""},
+		{"database password", api.ConfigSpec{Database: &api.Database{Credentials: &api.DBCredentials{Password: str("file:/etc/shadow")}}}, "database.credentials.password"},
+		{"encryption key", api.ConfigSpec{Dump: &api.Dump{Encryption: &api.Encryption{Key: str("env:KEY")}}}, "dump.encryption.key"},
+		{"telemetry credentials", api.ConfigSpec{Telemetry: &api.Telemetry{Credentials: str("exec:id")}}, "telemetry.credentials"},
+		{"target spec", api.ConfigSpec{Targets: &map[string]api.Target{
+			"s3": {Type: api.TargetTypeS3, URL: "s3://bucket", Spec: &map[string]interface{}{"region": "us-east-1", "secretAccessKey": "exec:cat /root/.aws/credentials"}},
+		}}, "targets.s3.spec.secretAccessKey"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRemoteReferences(&tt.spec)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"lukechampine.com/blake3"
+
+	"github.com/databacker/mysql-backup/pkg/storage"
+)
+
+// checksum algorithms for backup files
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumBLAKE3 = "blake3"
+	// ChecksumNone do not compute any checksum
+	ChecksumNone = "none"
+)
+
+// checksumAlgorithms all of the supported checksum algorithms, in the order in which they are checked
+var checksumAlgorithms = []string{ChecksumSHA256, ChecksumBLAKE3}
+
+// ErrChecksumMismatch the backup file does not match its stored checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumExtension extension of the sidecar file holding a checksum of a backup file, e.g. ".sha256"
+func checksumExtension(algorithm string) string {
+	return "." + algorithm
+}
+
+// isChecksumFile whether the file is a checksum sidecar file, rather than a backup
+func isChecksumFile(name string) bool {
+	return slices.ContainsFunc(checksumAlgorithms, func(algorithm string) bool {
+		return strings.HasSuffix(name, checksumExtension(algorithm))
+	})
+}
+
+// checksumFiles the names of all of the possible checksum sidecar files for a backup file
+func checksumFiles(name string) []string {
+	var names []string
+	for _, algorithm := range checksumAlgorithms {
+		names = append(names, name+checksumExtension(algorithm))
+	}
+	return names
+}
+
+// parseChecksumAlgorithms validate the requested checksum algorithms. If none are requested, defaults to SHA-256;
+// ChecksumNone disables checksums.
+func parseChecksumAlgorithms(algorithms []string) ([]string, error) {
+	if len(algorithms) == 0 {
+		return []string{ChecksumSHA256}, nil
+	}
+	if slices.Contains(algorithms, ChecksumNone) {
+		if len(algorithms) > 1 {
+			return nil, fmt.Errorf("checksum %s cannot be combined with other checksums", ChecksumNone)
+		}
+		return nil, nil
+	}
+	var parsed []string
+	for _, algorithm := range algorithms {
+		algorithm = strings.ToLower(algorithm)
+		if !slices.Contains(checksumAlgorithms, algorithm) {
+			return nil, fmt.Errorf("unknown checksum algorithm %s, must be one of %s", algorithm, strings.Join(checksumAlgorithms, ", "))
+		}
+		if !slices.Contains(parsed, algorithm) {
+			parsed = append(parsed, algorithm)
+		}
+	}
+	return parsed, nil
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case ChecksumBLAKE3:
+		return blake3.New(32, nil)
+	default:
+		return sha256.New()
+	}
+}
+
+// checksummer computes checksums of everything written to it, so that they can be calculated
+// while the backup file is written
+type checksummer struct {
+	hashes map[string]hash.Hash
+}
+
+func newChecksummer(algorithms []string) *checksummer {
+	c := &checksummer{hashes: map[string]hash.Hash{}}
+	for _, algorithm := range algorithms {
+		c.hashes[algorithm] = newHash(algorithm)
+	}
+	return c
+}
+
+func (c *checksummer) Write(p []byte) (int, error) {
+	for _, h := range c.hashes {
+		// hash.Hash never returns an error
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// sums the hex-encoded checksums, by algorithm
+func (c *checksummer) sums() map[string]string {
+	sums := map[string]string{}
+	for algorithm, h := range c.hashes {
+		sums[algorithm] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return sums
+}
+
+// fileChecksums compute the checksums of a local file
+func fileChecksums(filename string, algorithms []string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", filename, err)
+	}
+	defer func() { _ = f.Close() }()
+	c := newChecksummer(algorithms)
+	if _, err := io.Copy(c, f); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+	return c.sums(), nil
+}
+
+// writeChecksumFiles write a sidecar file for each checksum, in the format of sha256sum, i.e. "<hex>  <filename>",
+// so that it can be checked with the standard tools. Returns the local sidecar files, by extension.
+func writeChecksumFiles(dir, filename string, sums map[string]string) (map[string]string, error) {
+	files := map[string]string{}
+	for algorithm, sum := range sums {
+		ext := checksumExtension(algorithm)
+		local := path.Join(dir, path.Base(filename)+ext)
+		if err := os.WriteFile(local, fmt.Appendf(nil, "%s  %s\n", sum, path.Base(filename)), 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write checksum file %s: %v", local, err)
+		}
+		files[ext] = local
+	}
+	return files, nil
+}
+
+// verifyChecksums check a backup file pulled from the target against its checksums, from the object metadata if
+// the target stores them there, else from the sidecar files. Backups without any checksum, e.g. from older versions,
+// are not verified.
+func verifyChecksums(ctx context.Context, logger *logrus.Entry, target storage.Storage, targetFile, localFile string) error {
+	expected := map[string]string{}
+	if store, ok := target.(storage.ChecksumStore); ok {
+		sums, err := store.Checksums(ctx, targetFile, logger)
+		if err != nil {
+			return fmt.Errorf("failed to get checksums of %s: %v", targetFile, err)
+		}
+		for algorithm, sum := range sums {
+			if slices.Contains(checksumAlgorithms, algorithm) {
+				expected[algorithm] = strings.ToLower(sum)
+			}
+		}
+	}
+	if len(expected) == 0 {
+		// list the directory, so that a missing checksum file is not confused with failing to pull it
+		dir := path.Dir(targetFile)
+		if dir == "." {
+			dir = ""
+		}
+		infos, err := target.ReadDir(ctx, dir, logger)
+		if err != nil {
+			return fmt.Errorf("failed to list checksum files: %v", err)
+		}
+		existing := map[string]bool{}
+		for _, info := range infos {
+			existing[path.Base(info.Name())] = true
+		}
+		for _, algorithm := range checksumAlgorithms {
+			sidecar := targetFile + checksumExtension(algorithm)
+			if !existing[path.Base(sidecar)] {
+				continue
+			}
+			sum, err := readChecksum(ctx, logger, target, sidecar, "")
+			if err != nil {
+				return err
+			}
+			expected[algorithm] = sum
+		}
+	}
+	if len(expected) == 0 {
+		logger.Warnf("no checksum found for %s, not verifying", targetFile)
+		return nil
+	}
+	actual, err := fileChecksums(localFile, slices.Collect(maps.Keys(expected)))
+	if err != nil {
+		return err
+	}
+	for _, algorithm := range checksumAlgorithms {
+		sum, ok := expected[algorithm]
+		if !ok {
+			continue
+		}
+		if actual[algorithm] != sum {
+			return fmt.Errorf("%w: %s of %s is %s, expected %s", ErrChecksumMismatch, algorithm, targetFile, actual[algorithm], sum)
+		}
+		logger.Debugf("verified %s checksum of %s", algorithm, targetFile)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/storage/credentials"
+	"github.com/databacker/mysql-backup/pkg/util"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	abcSHA256 = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	abcBLAKE3 = "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"
+)
+
+func TestParseChecksumAlgorithms(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithms []string
+		expected   []string
+		err        string
+	}{
+		{"default", nil, []string{ChecksumSHA256}, ""},
+		{"none", []string{"none"}, nil, ""},
+		{"both", []string{"SHA256", "blake3", "sha256"}, []string{ChecksumSHA256, ChecksumBLAKE3}, ""},
+		{"unknown", []string{"md5"}, nil, "unknown checksum algorithm md5"},
+		{"none with others", []string{"none", "sha256"}, nil, "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithms, err := parseChecksumAlgorithms(tt.algorithms)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, algorithms)
+		})
+	}
+}
+
+func TestChecksummer(t *testing.T) {
+	c := newChecksummer([]string{ChecksumSHA256, ChecksumBLAKE3})
+	_, _ = io.WriteString(c, "a")
+	_, _ = io.WriteString(c, "bc")
+	assert.Equal(t, map[string]string{ChecksumSHA256: abcSHA256, ChecksumBLAKE3: abcBLAKE3}, c.sums())
+}
+
+// checksumStorage wraps a storage, reporting fixed checksums for every file, as if from object metadata
+type checksumStorage struct {
+	storage.Storage
+	sums map[string]string
+}
+
+func (c checksumStorage) Checksums(ctx context.Context, target string, logger *log.Entry) (map[string]string, error) {
+	return c.sums, nil
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	const filename = "db_backup_2021-01-01T00:00:00Z.gz"
+	tests := []struct {
+		name     string
+		file     string
+		files    map[string]string
+		metadata map[string]string
+		err      error
+	}{
+		{"sha256", filename, map[string]string{filename + ".sha256": abcSHA256 + "  " + filename}, nil, nil},
+		{"blake3", filename, map[string]string{filename + ".blake3": abcBLAKE3 + "  " + filename}, nil, nil},
+		{"sha256 mismatch", filename, map[string]string{filename + ".sha256": abcBLAKE3 + "  " + filename}, nil, ErrChecksumMismatch},
+		{"blake3 mismatch", filename, map[string]string{filename + ".sha256": abcSHA256 + "  " + filename, filename + ".blake3": abcSHA256 + "  " + filename}, nil, ErrChecksumMismatch},
+		{"no checksum", filename, nil, nil, nil},
+		{"in directory", "2021/" + filename, map[string]string{"2021/" + filename + ".sha256": abcSHA256 + "  " + filename}, nil, nil},
+		{"metadata", filename, nil, map[string]string{ChecksumSHA256: abcSHA256}, nil},
+		{"metadata mismatch", filename, nil, map[string]string{ChecksumSHA256: abcBLAKE3}, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New()
+			logger.Out = io.Discard
+			dir := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "2021"), 0o755))
+			writeFiles(t, dir, tt.files)
+			var target storage.Storage = fileStore(t, dir)
+			if tt.metadata != nil {
+				target = checksumStorage{Storage: target, sums: tt.metadata}
+			}
+			local := filepath.Join(t.TempDir(), "restore")
+			require.NoError(t, os.WriteFile(local, []byte("abc"), 0o644))
+
+			err := verifyChecksums(context.Background(), log.NewEntry(logger), target, tt.file, local)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestUploadChecksumFiles(t *testing.T) {
+	const filename = "db_backup_2021-01-01T00:00:00Z.gz"
+	logger := log.New()
+	logger.Out = io.Discard
+	tmpdir := t.TempDir()
+	source := filepath.Join(tmpdir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("abc"), 0o644))
+	sums, err := fileChecksums(source, []string{ChecksumSHA256})
+	require.NoError(t, err)
+	sidecars, err := writeChecksumFiles(tmpdir, filename, sums)
+	require.NoError(t, err)
+
+	fileDir, metadataDir := t.TempDir(), t.TempDir()
+	targets := []storage.Storage{fileStore(t, fileDir), checksumStorage{Storage: fileStore(t, metadataDir)}}
+	_, err = uploadTargets(context.Background(), log.NewEntry(logger), targets, filename, source, sidecars, DumpOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{filename: "abc", filename + ".sha256": abcSHA256 + "  " + filename + "\n"}, readFiles(t, fileDir))
+	// a target that stores checksums itself gets no checksum files
+	assert.Equal(t, map[string]string{filename: "abc"}, readFiles(t, metadataDir))
+}
+
+func TestChecksumsS3Metadata(t *testing.T) {
+	const filename = "db_backup_2021-01-01T00:00:00Z.gz"
+	logger := log.New()
+	logger.Out = io.Discard
+	entry := log.NewEntry(logger)
+
+	s3backend := s3mem.New()
+	require.NoError(t, s3backend.CreateBucket("mytestbucket"))
+	s3server := httptest.NewServer(gofakes3.New(s3backend).Server())
+	defer s3server.Close()
+	store, err := storage.ParseURL("s3://mytestbucket/backups", credentials.Creds{AWS: credentials.AWSCreds{
+		Endpoint:        s3server.URL,
+		AccessKeyID:     "abcdefg",
+		SecretAccessKey: "1234567",
+		Region:          "us-east-1",
+		PathStyle:       true,
+	}})
+	require.NoError(t, err)
+	require.Implements(t, (*storage.ChecksumStore)(nil), store)
+
+	source := filepath.Join(t.TempDir(), "source")
+	require.NoError(t, os.WriteFile(source, []byte("abc"), 0o644))
+	ctx := util.ContextWithObjectMetadata(context.Background(), map[string]string{util.MetadataChecksumPrefix + ChecksumSHA256: abcSHA256})
+	_, err = uploadTargets(ctx, entry, []storage.Storage{store}, filename, source, map[string]string{".sha256": source}, DumpOptions{})
+	require.NoError(t, err)
+	files, err := listFiles(ctx, entry, store)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "no checksum file")
+
+	require.NoError(t, verifyChecksums(ctx, entry, store, filename, source))
+	corrupt := filepath.Join(t.TempDir(), "corrupt")
+	require.NoError(t, os.WriteFile(corrupt, []byte("abd"), 0o644))
+	assert.ErrorIs(t, verifyChecksums(ctx, entry, store, filename, corrupt), ErrChecksumMismatch)
+}
@@ -1,5 +1,30 @@
 package core
 
+import "github.com/databacker/api/go/api"
+
 const (
 	DefaultFilenamePattern = "db_backup_{{ .now }}.{{ .compression }}"
 )
+
+// spans that are not (yet) defined in the api
+const (
+	syncSpan       = "sync"
+	syncTargetSpan = "sync target"
+	rekeySpan      = "rekey"
+)
+
+// span attributes that are not (yet) defined in the api
+const (
+	// lockedAttr files that were candidates for pruning, but skipped because the target has them locked
+	lockedAttr = "locked"
+	// copiedAttr files that were copied to a target during a sync
+	copiedAttr = "copied"
+	// attemptsAttr how many attempts an upload took
+	attemptsAttr = "attempts"
+	// rekeyedAttr files whose data key was rewrapped with the new master key
+	rekeyedAttr = "rekeyed"
+)
+
+// BackupStatusPartial value for the backup.status span attribute when the backup was uploaded to some
+// targets, but not all. Not (yet) defined in the api.
+const BackupStatusPartial api.BackupStatus = "partial"
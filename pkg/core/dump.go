@@ -3,10 +3,14 @@ package core
 import (
 	"context"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -16,7 +20,12 @@ import (
 
 	"github.com/databacker/api/go/api"
 	"github.com/databacker/mysql-backup/pkg/archive"
+	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/database"
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
 	"github.com/databacker/mysql-backup/pkg/util"
 )
 
@@ -47,6 +56,14 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 	logger := e.Logger.WithField("run", opts.Run.String())
 	logger.Level = e.Logger.Level
 
+	if err := opts.TargetPolicy.validate(targets); err != nil {
+		return results, fmt.Errorf("invalid target policy: %v", err)
+	}
+	checksums, err := parseChecksumAlgorithms(opts.Checksums)
+	if err != nil {
+		return results, err
+	}
+
 	now := time.Now()
 	results.Time = now
 
@@ -60,8 +77,16 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 
 	// sourceFilename: file that the uploader looks for when performing the upload
 	// targetFilename: the remote file that is actually uploaded
-	sourceFilename := fmt.Sprintf("db_backup_%s.%s", timepart, compressor.Extension())
-	targetFilename, err := ProcessFilenamePattern(filenamePattern, now, timepart, compressor.Extension())
+	// encrypted files are not a compressed archive, so do not claim to be just one
+	extension := compressor.Extension()
+	switch {
+	case encryptor != nil:
+		extension += "." + encrypt.FileExtension(encryptor.Name())
+	case opts.Envelope != nil:
+		extension += "." + encrypt.Extension
+	}
+	sourceFilename := fmt.Sprintf("db_backup_%s.%s", timepart, extension)
+	targetFilename, err := ProcessFilenamePattern(filenamePattern, now, timepart, extension)
 	if err != nil {
 		return results, fmt.Errorf("failed to process filename pattern: %v", err)
 	}
@@ -132,6 +157,7 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 		PostDumpDelay:       opts.PostDumpDelay,
 		Parallelism:         parallelism,
 		IgnoreTables:        opts.IgnoreTables,
+		RateLimit:           ratelimit.New(opts.DBReadRateLimit),
 	}, dw); err != nil {
 		dbDumpSpan.SetStatus(codes.Error, err.Error())
 		dbDumpSpan.End()
@@ -152,21 +178,23 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 		return results, fmt.Errorf("failed to open output file '%s': %v", outFile, err)
 	}
 	defer func() { _ = f.Close() }()
-	compressedWriter, err := compressor.Compress(f)
-	if err != nil {
-		tarSpan.SetStatus(codes.Error, err.Error())
-		tarSpan.End()
-		return results, fmt.Errorf("failed to create compressor: %v", err)
-	}
-	archiveWriter := compressedWriter
-	if encryptor != nil {
-		archiveWriter, err = encryptor.Encrypt(compressedWriter)
-		if err != nil {
+	// with envelope encryption, every backup has a data key of its own
+	var wrappedKeys []encrypt.WrappedKey
+	if opts.Envelope != nil {
+		if encryptor, wrappedKeys, err = opts.Envelope.NewDataKey(); err != nil {
 			tarSpan.SetStatus(codes.Error, err.Error())
 			tarSpan.End()
-			return results, fmt.Errorf("failed to create encryptor: %v", err)
+			return results, fmt.Errorf("failed to create data key: %v", err)
 		}
 	}
+	// calculate the checksums of the final file as it is written
+	sum := newChecksummer(checksums)
+	archiveWriter, err := newArchiveWriter(io.MultiWriter(f, sum), compressor, encryptor, wrappedKeys)
+	if err != nil {
+		tarSpan.SetStatus(codes.Error, err.Error())
+		tarSpan.End()
+		return results, err
+	}
 	if err := archive.Tar(workdir, archiveWriter); err != nil {
 		tarSpan.SetStatus(codes.Error, err.Error())
 		tarSpan.End()
@@ -177,17 +205,12 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 		tarSpan.End()
 		return results, fmt.Errorf("failed to close archive writer: %v", err)
 	}
-	if archiveWriter != compressedWriter {
-		if err := compressedWriter.Close(); err != nil {
-			tarSpan.SetStatus(codes.Error, err.Error())
-			tarSpan.End()
-			return results, fmt.Errorf("failed to close compressor: %v", err)
-		}
-	}
-	if info, err := os.Stat(outFile); err == nil {
-		results.Bytes = info.Size()
+	outInfo, err := os.Stat(outFile)
+	if err == nil {
+		results.Bytes = outInfo.Size()
 		tarSpan.SetAttributes(attribute.Int64(string(api.BackupAttrBytes), results.Bytes))
 	}
+	results.Checksums = sum.sums()
 	tarSpan.SetStatus(codes.Ok, "completed")
 	tarSpan.End()
 
@@ -195,41 +218,190 @@ func (e *Executor) Dump(ctx context.Context, opts DumpOptions) (DumpResults, err
 	if err := postBackup(ctx, timepart, path.Join(tmpdir, sourceFilename), tmpdir, opts.PostBackupScripts, logger.Level == log.DebugLevel); err != nil {
 		return results, fmt.Errorf("error running post-backup scripts: %v", err)
 	}
+	// post-backup scripts may change the file, in which case the checksums must be calculated again
+	if info, err := os.Stat(outFile); len(checksums) > 0 && (err != nil || outInfo == nil || info.Size() != outInfo.Size() || !info.ModTime().Equal(outInfo.ModTime())) {
+		if results.Checksums, err = fileChecksums(outFile, checksums); err != nil {
+			return results, fmt.Errorf("failed to calculate checksums: %v", err)
+		}
+	}
+	sidecars, err := writeChecksumFiles(tmpdir, targetFilename, results.Checksums)
+	if err != nil {
+		return results, err
+	}
+	// sign the final file, after any post-backup scripts
+	if opts.Signer != nil {
+		if sidecars[signature.Extension], err = signFile(opts.Signer, tmpdir, targetFilename, outFile); err != nil {
+			return results, fmt.Errorf("failed to sign backup: %v", err)
+		}
+	}
+
+	// metadata about the backup, for targets that can store it alongside the file
+	metadata := map[string]string{
+		util.MetadataRunID:     opts.Run.String(),
+		util.MetadataSchemas:   strings.Join(dbnames, ","),
+		util.MetadataTimestamp: now.UTC().Format(time.RFC3339),
+	}
+	if opts.ServerUUID != "" {
+		metadata[util.MetadataServerUUID] = opts.ServerUUID
+	}
+	for algorithm, sum := range results.Checksums {
+		metadata[util.MetadataChecksumPrefix+algorithm] = sum
+	}
+	ctx = util.ContextWithObjectMetadata(ctx, metadata)
+	// only a time-based retention policy gives a date until which the backup must be kept
+	if retainHours, err := convertToHours(opts.Retention); err == nil && retainHours > 0 {
+		ctx = util.ContextWithRetainUntil(ctx, now.Add(time.Duration(retainHours)*time.Hour))
+	}
 
 	// upload to each destination
-	uploadCtx, uploadSpan := tracer.Start(ctx, string(api.BackupSpanUpload))
-	for _, t := range targets {
-		targetCtx, targetSpan := tracer.Start(uploadCtx, string(api.BackupSpanUpload))
-		targetSpan.SetAttributes(
-			attribute.String(string(api.BackupAttrTargetType), t.Protocol()),
-			attribute.String(string(api.BackupAttrTargetURL), t.URL()),
-		)
-		uploadResult := UploadResult{Target: t.URL(), Start: time.Now()}
-		targetCleanFilename := t.Clean(targetFilename)
-		logger.Debugf("uploading via protocol %s from %s to %s", t.Protocol(), sourceFilename, targetCleanFilename)
-		copied, err := t.Push(targetCtx, targetCleanFilename, filepath.Join(tmpdir, sourceFilename), logger)
-		if err != nil {
-			targetSpan.SetStatus(codes.Error, err.Error())
-			targetSpan.End()
-			uploadSpan.SetStatus(codes.Error, err.Error())
-			uploadSpan.End()
-			return results, fmt.Errorf("failed to push file: %v", err)
-		}
-		logger.Debugf("completed copying %d bytes", copied)
-		targetSpan.SetStatus(codes.Ok, "completed")
-		targetSpan.End()
-		uploadResult.Filename = targetCleanFilename
-		uploadResult.End = time.Now()
-		results.Uploads = append(results.Uploads, uploadResult)
+	uploads, err := uploadTargets(ctx, logger, targets, targetFilename, filepath.Join(tmpdir, sourceFilename), sidecars, opts)
+	results.Uploads = append(results.Uploads, uploads...)
+	if err != nil {
+		return results, err
 	}
-	uploadSpan.SetStatus(codes.Ok, "completed")
-	uploadSpan.End()
 
 	logger.Infof("finished dump %s", time.Now().Format(time.RFC3339))
 
 	return results, nil
 }
 
+// uploadTargets upload the file, with its checksum and signature sidecar files by extension, to all of the targets,
+// up to opts.UploadConcurrency at once, or all at once if none is provided. The first failure of a target that must
+// succeed cancels the other uploads. With a target policy, those are its required targets; without one, all of
+// them, unless opts.ContinueOnUploadFailure is set.
+func uploadTargets(ctx context.Context, logger *log.Entry, targets []storage.Storage, targetFilename, source string, sidecars map[string]string, opts DumpOptions) ([]UploadResult, error) {
+	concurrency := opts.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = len(targets)
+	}
+	// one limiter for all of the targets, so that the limit is on the total
+	ctx = ratelimit.ContextWithUpload(ctx, ratelimit.New(opts.UploadRateLimit))
+	uploadCtx, uploadSpan := util.GetTracerFromContext(ctx).Start(ctx, string(api.BackupSpanUpload))
+	defer uploadSpan.End()
+	policy := opts.TargetPolicy
+	required := func(target string) bool {
+		if policy.IsSet() {
+			return policy.required(target)
+		}
+		return !opts.ContinueOnUploadFailure
+	}
+	// cancelled when an upload that must succeed fails, so that the others stop
+	uploadCtx, cancel := context.WithCancel(uploadCtx)
+	defer cancel()
+	var (
+		uploads     = make([]UploadResult, len(targets))
+		requiredErr error
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, t := range targets {
+		sem <- struct{}{} // acquire a slot
+		if err := uploadCtx.Err(); err != nil {
+			<-sem
+			uploads[i] = UploadResult{Target: t.URL(), Error: fmt.Errorf("upload not started: %w", err)}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t storage.Storage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			uploads[i] = uploadTarget(uploadCtx, logger, t, targetFilename, source, sidecars, opts.UploadRetry)
+			if uploads[i].Error == nil {
+				return
+			}
+			if !required(uploads[i].Target) {
+				logger.Errorf("failed to upload to %s, continuing with other targets: %v", uploads[i].Target, uploads[i].Error)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// only the first failure counts; the others may be failing because of the cancellation
+			if requiredErr == nil {
+				requiredErr = fmt.Errorf("%s: %w", uploads[i].Target, uploads[i].Error)
+				logger.Errorf("failed to upload to %s, stopping other uploads: %v", uploads[i].Target, uploads[i].Error)
+				cancel()
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	if requiredErr != nil {
+		uploadSpan.SetStatus(codes.Error, requiredErr.Error())
+		return uploads, fmt.Errorf("failed to push file: %v", requiredErr)
+	}
+
+	var failed []string
+	for _, u := range uploads {
+		if u.Error != nil {
+			failed = append(failed, u.Target)
+		}
+	}
+	succeeded := len(targets) - len(failed)
+	switch {
+	case len(failed) == 0:
+		uploadSpan.SetStatus(codes.Ok, "completed")
+		return uploads, nil
+	case policy.IsSet() && succeeded >= policy.MinSuccess:
+		// every required target succeeded, or we would have returned already
+		logger.Warnf("failed to upload to %d of %d targets, within the target policy: %v", len(failed), len(targets), uploadErrors(uploads))
+		uploadSpan.SetStatus(codes.Ok, fmt.Sprintf("%d of %d uploads failed, within target policy", len(failed), len(targets)))
+		return uploads, nil
+	case policy.IsSet():
+		uploadSpan.SetStatus(codes.Error, fmt.Sprintf("%d of %d uploads succeeded, target policy requires %d", succeeded, len(targets), policy.MinSuccess))
+		return uploads, fmt.Errorf("failed to push file to enough targets, %d of %d succeeded, target policy requires %d: %v", succeeded, len(targets), policy.MinSuccess, uploadErrors(uploads))
+	case len(failed) == len(targets):
+		uploadSpan.SetStatus(codes.Error, "all uploads failed")
+		return uploads, fmt.Errorf("failed to push file to all targets: %v", uploadErrors(uploads))
+	default:
+		uploadSpan.SetStatus(codes.Error, fmt.Sprintf("%d of %d uploads failed", len(failed), len(targets)))
+		return uploads, fmt.Errorf("%w: failed to push file to %d of %d targets: %v", ErrPartialUpload, len(failed), len(targets), uploadErrors(uploads))
+	}
+}
+
+// uploadTarget upload the file to a single target, retrying as needed, followed by the checksum sidecar files,
+// unless the target stores the checksums itself, and the signature file, if any
+func uploadTarget(ctx context.Context, logger *log.Entry, t storage.Storage, targetFilename, source string, sidecars map[string]string, retry RetryOptions) UploadResult {
+	targetCtx, targetSpan := util.GetTracerFromContext(ctx).Start(ctx, string(api.BackupSpanUpload))
+	defer targetSpan.End()
+	targetSpan.SetAttributes(
+		attribute.String(string(api.BackupAttrTargetType), t.Protocol()),
+		attribute.String(string(api.BackupAttrTargetURL), t.URL()),
+	)
+	uploadResult := UploadResult{Target: t.URL(), Start: time.Now()}
+	targetCleanFilename := t.Clean(targetFilename)
+	uploadResult.Attempts, uploadResult.Error = retry.retry(targetCtx, func(attempt int) error {
+		logger.Debugf("uploading via protocol %s from %s to %s, attempt %d", t.Protocol(), source, targetCleanFilename, attempt)
+		copied, err := t.Push(targetCtx, targetCleanFilename, source, logger)
+		if err != nil {
+			logger.Warnf("attempt %d to upload to %s failed: %v", attempt, t.URL(), err)
+			return err
+		}
+		logger.Debugf("completed copying %d bytes", copied)
+		uploadResult.Bytes = copied
+		_, checksumStore := t.(storage.ChecksumStore)
+		for _, ext := range slices.Sorted(maps.Keys(sidecars)) {
+			if checksumStore && isChecksumFile(ext) {
+				continue
+			}
+			if _, err := t.Push(targetCtx, t.Clean(targetFilename+ext), sidecars[ext], logger); err != nil {
+				logger.Warnf("attempt %d to upload %s file to %s failed: %v", attempt, ext, t.URL(), err)
+				return fmt.Errorf("failed to push %s file: %w", ext, err)
+			}
+		}
+		return nil
+	})
+	uploadResult.End = time.Now()
+	targetSpan.SetAttributes(attribute.Int(attemptsAttr, uploadResult.Attempts))
+	if uploadResult.Error != nil {
+		targetSpan.SetStatus(codes.Error, uploadResult.Error.Error())
+		return uploadResult
+	}
+	uploadResult.Filename = targetCleanFilename
+	targetSpan.SetAttributes(attribute.Int64(string(api.BackupAttrBytes), uploadResult.Bytes))
+	targetSpan.SetStatus(codes.Ok, "completed")
+	return uploadResult
+}
+
 // run pre-backup scripts, if they exist
 func preBackup(ctx context.Context, timestamp, dumpfile, dumpdir, preBackupDir string, debug bool) error {
 	// construct any additional environment
@@ -283,6 +455,59 @@ func ProcessFilenamePattern(pattern string, now time.Time, timestamp, ext string
 	return buf.String(), nil
 }
 
+// newArchiveWriter a writer for the archive that compresses it, then encrypts it if there is an encryptor, after
+// writing the header that says how to undo both, including the wrapped data key of envelope encryption, if any,
+// unless the encryption has no header.
+// Closing it closes the compressor, then the encryptor.
+func newArchiveWriter(out io.Writer, compressor compression.Compressor, encryptor encrypt.Encryptor, wrappedKeys []encrypt.WrappedKey) (io.WriteCloser, error) {
+	var encryptedWriter io.WriteCloser
+	if encryptor != nil {
+		header := encrypt.Header{Algorithm: encryptor.Name(), Compression: compressor.Name(), KeyID: encryptor.KeyID(), Keys: wrappedKeys}
+		// the data key is identified by the master keys that wrap it
+		if len(wrappedKeys) > 0 {
+			header.KeyID = ""
+		}
+		if encrypt.HasHeader(header.Algorithm) {
+			if err := encrypt.WriteHeader(out, header); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if encryptedWriter, err = encryptor.Encrypt(out); err != nil {
+			return nil, fmt.Errorf("failed to create encryptor: %v", err)
+		}
+		out = encryptedWriter
+	}
+	compressedWriter, err := compressor.Compress(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %v", err)
+	}
+	if encryptedWriter == nil {
+		return compressedWriter, nil
+	}
+	return &archiveWriter{compressed: compressedWriter, encrypted: encryptedWriter}, nil
+}
+
+// archiveWriter compress, then encrypt, closing both in that order
+type archiveWriter struct {
+	compressed io.WriteCloser
+	encrypted  io.WriteCloser
+}
+
+func (w *archiveWriter) Write(p []byte) (int, error) {
+	return w.compressed.Write(p)
+}
+
+func (w *archiveWriter) Close() error {
+	if err := w.compressed.Close(); err != nil {
+		return fmt.Errorf("failed to close compressor: %v", err)
+	}
+	if err := w.encrypted.Close(); err != nil {
+		return fmt.Errorf("failed to close encryptor: %v", err)
+	}
+	return nil
+}
+
 // filterExcludedDatabases removes databases in the exclude list from dbnames.
 func filterExcludedDatabases(dbnames, exclude []string) []string {
 	if len(exclude) == 0 {
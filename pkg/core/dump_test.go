@@ -1,7 +1,19 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFilterExcludedDatabases(t *testing.T) {
@@ -69,3 +81,98 @@ func TestFilterExcludedDatabases(t *testing.T) {
 		})
 	}
 }
+
+// uploadStorage wraps a storage, failing or blocking uploads until the context is cancelled, and counting
+// how many uploads run at once
+type uploadStorage struct {
+	storage.Storage
+	fail    bool
+	block   bool
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (u uploadStorage) Push(ctx context.Context, target, source string, logger *log.Entry) (int64, error) {
+	n := u.running.Add(1)
+	defer u.running.Add(-1)
+	for peak := u.peak.Load(); n > peak && !u.peak.CompareAndSwap(peak, n); peak = u.peak.Load() {
+	}
+	switch {
+	case u.fail:
+		return 0, errors.New("upload failed")
+	case u.block:
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	time.Sleep(10 * time.Millisecond)
+	return u.Storage.Push(ctx, target, source, logger)
+}
+
+func TestUploadTargets(t *testing.T) {
+	const filename = "db_backup_2021-01-01T00:00:00Z.gz"
+	tests := []struct {
+		name        string
+		targets     []string // "ok", "fail" or "block"
+		concurrency int
+		continueOn  bool
+		required    []int // indexes of targets required by the target policy
+		minSuccess  int
+		uploaded    []bool
+		errors      []string
+		err         string
+	}{
+		{"all at once", []string{"ok", "ok", "ok", "ok"}, 0, false, nil, 0, []bool{true, true, true, true}, []string{"", "", "", ""}, ""},
+		{"limited", []string{"ok", "ok", "ok", "ok"}, 2, false, nil, 0, []bool{true, true, true, true}, []string{"", "", "", ""}, ""},
+		{"failure cancels others", []string{"block", "fail"}, 0, false, nil, 0, []bool{false, false}, []string{"context canceled", "upload failed"}, "upload failed"},
+		{"failure stops remaining", []string{"fail", "ok"}, 1, false, nil, 0, []bool{false, false}, []string{"upload failed", "upload not started"}, "upload failed"},
+		{"continue on failure", []string{"fail", "ok"}, 0, true, nil, 0, []bool{false, true}, []string{"upload failed", ""}, "partial upload"},
+		{"all fail", []string{"fail", "fail"}, 0, true, nil, 0, []bool{false, false}, []string{"upload failed", "upload failed"}, "failed to push file to all targets"},
+		{"policy met", []string{"ok", "fail", "ok"}, 0, false, []int{0}, 2, []bool{true, false, true}, []string{"", "upload failed", ""}, ""},
+		{"policy minimum not met", []string{"ok", "fail", "fail"}, 0, false, []int{0}, 2, []bool{true, false, false}, []string{"", "upload failed", "upload failed"}, "1 of 3 succeeded, target policy requires 2"},
+		{"policy required failed", []string{"block", "fail", "ok"}, 0, false, []int{1}, 1, []bool{false, false, true}, []string{"context canceled", "upload failed", ""}, "upload failed"},
+		{"policy optional failure does not cancel", []string{"ok", "fail"}, 1, false, nil, 1, []bool{true, false}, []string{"", "upload failed"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New()
+			logger.Out = io.Discard
+			source := filepath.Join(t.TempDir(), "source")
+			require.NoError(t, os.WriteFile(source, []byte("backup"), 0o644))
+
+			var running, peak atomic.Int32
+			var targets []storage.Storage
+			var dirs []string
+			for _, kind := range tt.targets {
+				dir := t.TempDir()
+				dirs = append(dirs, dir)
+				targets = append(targets, uploadStorage{Storage: fileStore(t, dir), fail: kind == "fail", block: kind == "block", running: &running, peak: &peak})
+			}
+			policy := TargetPolicy{MinSuccess: tt.minSuccess}
+			for _, i := range tt.required {
+				policy.Required = append(policy.Required, targets[i].URL())
+			}
+			require.NoError(t, policy.validate(targets))
+			uploads, err := uploadTargets(context.Background(), log.NewEntry(logger), targets, filename, source, nil, DumpOptions{UploadConcurrency: tt.concurrency, ContinueOnUploadFailure: tt.continueOn, TargetPolicy: policy})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, uploads, len(targets))
+			for i, upload := range uploads {
+				assert.Equal(t, targets[i].URL(), upload.Target)
+				if tt.errors[i] == "" {
+					assert.NoError(t, upload.Error)
+				} else {
+					assert.ErrorContains(t, upload.Error, tt.errors[i])
+				}
+				_, statErr := os.Stat(filepath.Join(dirs[i], filename))
+				assert.Equal(t, tt.uploaded[i], statErr == nil, "target %d uploaded", i)
+			}
+			if tt.concurrency > 0 {
+				assert.LessOrEqual(t, int(peak.Load()), tt.concurrency)
+			}
+		})
+	}
+}
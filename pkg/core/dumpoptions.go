@@ -6,17 +6,23 @@ import (
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/database"
 	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/storage"
 	"github.com/google/uuid"
 )
 
 type DumpOptions struct {
-	Targets             []storage.Storage
-	Safechars           bool
-	DBNames             []string
-	DBConn              *database.Connection
-	Compressor          compression.Compressor
-	Encryptor           encrypt.Encryptor
+	Targets    []storage.Storage
+	Safechars  bool
+	DBNames    []string
+	DBConn     *database.Connection
+	Compressor compression.Compressor
+	Encryptor  encrypt.Encryptor
+	// Envelope if set, encrypt with envelope encryption, with a new data key for each backup, instead of with Encryptor
+	Envelope *encrypt.Envelope
+	// Signer if set, sign each backup, and upload the signature file next to it
+	Signer              *signature.SecretKey
 	Exclude             []string
 	PreBackupScripts    string
 	PostBackupScripts   string
@@ -36,4 +42,21 @@ type DumpOptions struct {
 	// ServerUUID is the MySQL server's @@global.server_uuid, used to build the
 	// protected_target.identity span attribute. May be empty if unavailable.
 	ServerUUID string
+	// Checksums the checksum algorithms to calculate for the backup file, stored with it on each target;
+	// defaults to sha256, ChecksumNone for none
+	Checksums []string
+	// UploadRetry how to retry failed uploads, for each target
+	UploadRetry RetryOptions
+	// UploadConcurrency how many targets to upload to at once; 0 means all of them
+	UploadConcurrency int
+	// ContinueOnUploadFailure continue uploading to the other targets when one fails, rather than stopping
+	ContinueOnUploadFailure bool
+	// TargetPolicy what counts as a successful backup when there are several targets; overrides ContinueOnUploadFailure
+	TargetPolicy TargetPolicy
+	// UploadRateLimit the most bytes per second to upload, across all of the targets
+	UploadRateLimit ratelimit.Schedule
+	// DBReadRateLimit the most bytes per second to read from the database, across all of the databases
+	DBReadRateLimit ratelimit.Schedule
+	// Retention the retention policy, if any, used to set the retain-until date on targets that support locking
+	Retention string
 }
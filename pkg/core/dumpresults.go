@@ -1,6 +1,13 @@
 package core
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPartialUpload the backup was uploaded to some targets, but not all
+var ErrPartialUpload = errors.New("partial upload")
 
 // DumpResults lists results of the dump.
 type DumpResults struct {
@@ -11,6 +18,8 @@ type DumpResults struct {
 	DumpStart time.Time
 	DumpEnd   time.Time
 	Bytes     int64
+	// Checksums the hex-encoded checksums of the backup file, by algorithm
+	Checksums map[string]string
 	Uploads   []UploadResult
 }
 
@@ -20,4 +29,32 @@ type UploadResult struct {
 	Filename string
 	Start    time.Time
 	End      time.Time
+	// Bytes how many bytes were uploaded
+	Bytes int64
+	// Attempts how many attempts were made, including the first
+	Attempts int
+	// Error why the upload failed, after all attempts; nil if it succeeded
+	Error error
+}
+
+// FailedTargets the URLs of the targets that did not receive the backup
+func (r DumpResults) FailedTargets() []string {
+	var failed []string
+	for _, u := range r.Uploads {
+		if u.Error != nil {
+			failed = append(failed, u.Target)
+		}
+	}
+	return failed
+}
+
+// uploadErrors the errors of all failed uploads, joined, or nil if none failed
+func uploadErrors(uploads []UploadResult) error {
+	var errs []error
+	for _, u := range uploads {
+		if u.Error != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.Target, u.Error))
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"path"
 	"regexp"
 	"slices"
@@ -19,7 +20,7 @@ import (
 )
 
 // filenameRE is a regular expression to match a backup filename
-var filenameRE = regexp.MustCompile(`^db_backup_(\d{4})-(\d{2})-(\d{2})T(\d{2})[:-](\d{2})[:-](\d{2})(Z|[\-\+]\d{2}[:-]\d{2})\.\w+$`)
+var filenameRE = regexp.MustCompile(`^db_backup_(\d{4})-(\d{2})-(\d{2})T(\d{2})[:-](\d{2})[:-](\d{2})(Z|[\-\+]\d{2}[:-]\d{2})\.(tar\.zst|tar\.xz|\w+)(\.enc|\.gpg)?$`)
 
 // Prune prune older backups
 func (e *Executor) Prune(ctx context.Context, opts PruneOptions) error {
@@ -56,10 +57,6 @@ func (e *Executor) Prune(ctx context.Context, opts PruneOptions) error {
 
 // pruneTarget prunes an individual target
 func pruneTarget(ctx context.Context, logger *logrus.Entry, target storage.Storage, now time.Time, retainHours, retainCount int) error {
-	var (
-		pruned                           int
-		candidates, ignored, invalidDate []string
-	)
 	ctx, span := util.GetTracerFromContext(ctx).Start(ctx, fmt.Sprintf("%s %s", string(api.BackupSpanPruneTarget), target.URL()))
 	defer span.End()
 
@@ -70,6 +67,37 @@ func pruneTarget(ctx context.Context, logger *logrus.Entry, target storage.Stora
 		return fmt.Errorf("failed to read directory: %v", err)
 	}
 
+	candidates, ignored, invalidDate, err := pruneCandidates(logger, files, now, retainHours, retainCount)
+	if err != nil {
+		span.SetStatus(codes.Error, "invalid retention time")
+		return err
+	}
+
+	// we have the list, remove them all
+	span.SetAttributes(attribute.StringSlice(string(api.BackupAttrCandidates), candidates), attribute.StringSlice(string(api.BackupAttrIgnored), ignored), attribute.StringSlice(string(api.BackupAttrInvalidDate), invalidDate))
+	pruned, locked, err := removeUnlocked(ctx, logger, target, candidates)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	// remove the checksum and signature files of the backups that were removed
+	if err := storage.RemoveAll(ctx, target, sidecarFilesOf(files, pruned), logger); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to remove checksum and signature files: %v", err)
+	}
+	logger.Debugf("pruning %d files from target %s", len(pruned), target.URL())
+	if len(locked) > 0 {
+		span.SetAttributes(attribute.StringSlice(lockedAttr, locked))
+		span.SetStatus(codes.Ok, fmt.Sprintf("pruned %d files, skipped %d locked files", len(pruned), len(locked)))
+		return nil
+	}
+	span.SetStatus(codes.Ok, fmt.Sprintf("pruned %d files", len(pruned)))
+	return nil
+}
+
+// pruneCandidates select the backup files that are outside of the retention policy. Files that do not match
+// the backup filename pattern are returned as ignored, and those whose date cannot be parsed as invalidDate.
+func pruneCandidates(logger *logrus.Entry, files []fs.FileInfo, now time.Time, retainHours, retainCount int) (candidates, ignored, invalidDate []string, err error) {
 	// create a slice with the filenames and their calculated times - these are *not* the timestamp times, but the times calculated from the filenames
 	var filesWithTimes []fileWithTime
 
@@ -134,21 +162,49 @@ func pruneTarget(ctx context.Context, logger *logrus.Entry, target storage.Stora
 			}
 		}
 	default:
-		span.SetStatus(codes.Error, "invalid retention time")
-		return fmt.Errorf("invalid retention time %d count %d hours", retainCount, retainHours)
+		return nil, nil, nil, fmt.Errorf("invalid retention time %d count %d hours", retainCount, retainHours)
 	}
+	return candidates, ignored, invalidDate, nil
+}
 
-	// we have the list, remove them all
-	span.SetAttributes(attribute.StringSlice(string(api.BackupAttrCandidates), candidates), attribute.StringSlice(string(api.BackupAttrIgnored), ignored), attribute.StringSlice(string(api.BackupAttrInvalidDate), invalidDate))
-	for _, filename := range candidates {
-		if err := target.Remove(ctx, filename, logger); err != nil {
-			return fmt.Errorf("failed to remove file %s: %v", filename, err)
+// removeUnlocked remove the files from the target, skipping any that the target has locked
+func removeUnlocked(ctx context.Context, logger *logrus.Entry, target storage.Storage, files []string) (removed, locked []string, err error) {
+	locker, canLock := target.(storage.Locker)
+	for _, filename := range files {
+		if canLock {
+			isLocked, reason, err := locker.Locked(ctx, filename, logger)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to check lock on file %s: %v", filename, err)
+			}
+			if isLocked {
+				logger.Infof("skipping locked file %s: %s", filename, reason)
+				locked = append(locked, filename)
+				continue
+			}
 		}
-		pruned++
+		removed = append(removed, filename)
 	}
-	logger.Debugf("pruning %d files from target %s", pruned, target.URL())
-	span.SetStatus(codes.Ok, fmt.Sprintf("pruned %d files", pruned))
-	return nil
+	if err := storage.RemoveAll(ctx, target, removed, logger); err != nil {
+		return nil, locked, fmt.Errorf("failed to remove files: %v", err)
+	}
+	return removed, locked, nil
+}
+
+// sidecarFilesOf the checksum and signature files, among files, of the given backups
+func sidecarFilesOf(files []fs.FileInfo, backups []string) []string {
+	existing := map[string]bool{}
+	for _, f := range files {
+		existing[f.Name()] = true
+	}
+	var sidecars []string
+	for _, backup := range backups {
+		for _, sidecar := range sidecarFiles(backup) {
+			if existing[sidecar] {
+				sidecars = append(sidecars, sidecar)
+			}
+		}
+	}
+	return sidecars
 }
 
 // convertToHours takes a string with format "<integer><unit>" and converts it to hours.
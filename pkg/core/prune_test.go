@@ -18,6 +18,7 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertToHours(t *testing.T) {
@@ -208,3 +209,144 @@ func TestPrune(t *testing.T) {
 		})
 	}
 }
+
+// lockedStorage wraps a storage, reporting the given files as locked
+type lockedStorage struct {
+	storage.Storage
+	locked []string
+}
+
+func (l lockedStorage) Locked(ctx context.Context, target string, logger *log.Entry) (bool, string, error) {
+	if slices.Contains(l.locked, target) {
+		return true, "locked for testing", nil
+	}
+	return false, "", nil
+}
+
+func TestPruneLocked(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)
+	filenames := []string{
+		"db_backup_2021-01-01T00:00:00Z.gz",
+		"db_backup_2020-12-31T00:00:00Z.gz",
+		"db_backup_2020-12-30T00:00:00Z.gz",
+		"db_backup_2020-12-29T00:00:00Z.gz",
+	}
+	ctx := context.Background()
+	logger := log.New()
+	logger.Out = io.Discard
+
+	workDir := t.TempDir()
+	for _, filename := range filenames {
+		if err := os.WriteFile(path.Join(workDir, filename), nil, 0o644); err != nil {
+			t.Fatalf("failed to create file %s: %v", filename, err)
+		}
+	}
+	store, err := storage.ParseURL(fmt.Sprintf("file://%s", workDir), credentials.Creds{})
+	if err != nil {
+		t.Fatalf("failed to parse file url: %v", err)
+	}
+	target := lockedStorage{Storage: store, locked: []string{filenames[2]}}
+
+	executor := Executor{Logger: logger}
+	if err := executor.Prune(ctx, PruneOptions{Targets: []storage.Storage{target}, Retention: "1d", Now: now}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, err := store.ReadDir(ctx, "", log.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	var afterFiles []string
+	for _, file := range files {
+		afterFiles = append(afterFiles, path.Base(file.Name()))
+	}
+	assert.ElementsMatch(t, []string{filenames[0], filenames[2]}, afterFiles)
+}
+
+func TestPruneS3ManyFiles(t *testing.T) {
+	// more than a single page of listing, and a single batch of deletes
+	const count = 1005
+	now := time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)
+	ctx := context.Background()
+	logger := log.New()
+	logger.Out = io.Discard
+
+	bucketName := "mytestbucket"
+	s3backend := s3mem.New()
+	if err := s3backend.CreateBucket(bucketName); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	s3server := httptest.NewServer(gofakes3.New(s3backend).Server())
+	defer s3server.Close()
+	store, err := storage.ParseURL(fmt.Sprintf("s3://%s/backups", bucketName), credentials.Creds{AWS: credentials.AWSCreds{
+		Endpoint:        s3server.URL,
+		AccessKeyID:     "abcdefg",
+		SecretAccessKey: "1234567",
+		Region:          "us-east-1",
+		PathStyle:       true,
+	}})
+	if err != nil {
+		t.Fatalf("failed to parse s3 url: %v", err)
+	}
+
+	srcFile := path.Join(t.TempDir(), "src")
+	if err := os.WriteFile(srcFile, nil, 0o644); err != nil {
+		t.Fatalf("failed to create file %s: %v", srcFile, err)
+	}
+	var newest string
+	for i := 0; i < count; i++ {
+		filename := fmt.Sprintf("db_backup_%sZ.gz", now.Add(-time.Duration(i)*time.Hour).Format("2006-01-02T15:04:05"))
+		if i == 0 {
+			newest = filename
+		}
+		if _, err := store.Push(ctx, filename, srcFile, log.NewEntry(logger)); err != nil {
+			t.Fatalf("failed to create file %s: %v", filename, err)
+		}
+	}
+	// a file in a subdirectory should be listed as a directory, and not pruned
+	if _, err := store.Push(ctx, "old/db_backup_2000-01-01T00:00:00Z.gz", srcFile, log.NewEntry(logger)); err != nil {
+		t.Fatalf("failed to create file in subdirectory: %v", err)
+	}
+
+	executor := Executor{Logger: logger}
+	if err := executor.Prune(ctx, PruneOptions{Targets: []storage.Storage{store}, Retention: "1c", Now: now}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, err := store.ReadDir(ctx, "", log.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	var afterFiles []string
+	for _, file := range files {
+		afterFiles = append(afterFiles, file.Name())
+	}
+	assert.ElementsMatch(t, []string{newest, "old"}, afterFiles)
+}
+
+func TestPruneChecksumFiles(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)
+	const (
+		newest     = "db_backup_2021-01-01T00:00:00Z.gz"
+		oldest     = "db_backup_2020-12-29T00:00:00Z.gz"
+		oldestZstd = "db_backup_2020-12-28T00:00:00Z.tar.zst"
+		oldestXz   = "db_backup_2020-12-27T00:00:00Z.tar.xz"
+		oldestEnc  = "db_backup_2020-12-26T00:00:00Z.tgz.enc"
+		oldestGpg  = "db_backup_2020-12-25T00:00:00Z.tgz.gpg"
+	)
+	ctx := context.Background()
+	logger := log.New()
+	logger.Out = io.Discard
+
+	workDir := t.TempDir()
+	writeFiles(t, workDir, map[string]string{
+		newest: "a", newest + ".sha256": "1111  " + newest,
+		oldest: "b", oldest + ".sha256": "2222  " + oldest, oldest + ".blake3": "3333  " + oldest,
+		oldestZstd: "c", oldestZstd + ".sha256": "4444  " + oldestZstd,
+		oldestXz: "d", oldestXz + ".sha256": "5555  " + oldestXz,
+		oldestEnc: "e", oldestEnc + ".sha256": "6666  " + oldestEnc,
+		oldestGpg: "f", oldestGpg + ".sha256": "7777  " + oldestGpg,
+	})
+	executor := Executor{Logger: logger}
+	err := executor.Prune(ctx, PruneOptions{Targets: []storage.Storage{fileStore(t, workDir)}, Retention: "1d", Now: now})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{newest: "a", newest + ".sha256": "1111  " + newest}, readFiles(t, workDir))
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+// Rekey rewrap the data key of every backup on the target that is wrapped with the old master key with the new
+// one, replacing the header of each file, without encrypting the archive again. Backups that are not encrypted
+// with envelope encryption, or whose data key is not wrapped with the old key, are left as they are.
+func (e *Executor) Rekey(ctx context.Context, opts RekeyOptions) error {
+	tracer := util.GetTracerFromContext(ctx)
+	ctx, span := tracer.Start(ctx, rekeySpan)
+	defer span.End()
+	logger := e.Logger.WithField("run", opts.Run.String())
+	logger.Level = e.Logger.Level
+
+	if opts.Target == nil {
+		return errors.New("no target")
+	}
+	if len(opts.OldKey) != encrypt.MasterKeySize || len(opts.NewKey) != encrypt.MasterKeySize {
+		return fmt.Errorf("old and new master keys must be %d bytes", encrypt.MasterKeySize)
+	}
+	logger.Infof("beginning rekey of %s from master key ID %s to %s", opts.Target.URL(), encrypt.MasterKeyID(opts.OldKey), encrypt.MasterKeyID(opts.NewKey))
+
+	files, err := listFiles(ctx, logger, opts.Target)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to read directory of target %s: %v", opts.Target.URL(), err)
+	}
+	tmpdir, err := os.MkdirTemp("", "databacker_rekey")
+	if err != nil {
+		return fmt.Errorf("failed to make temporary working directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpdir) }()
+
+	var rekeyed []string
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if isSidecarFile(name) || !filenameRE.MatchString(filepath.Base(name)) {
+			continue
+		}
+		done, err := rekeyFile(ctx, logger, opts.Target, files, name, tmpdir, opts)
+		if err != nil {
+			span.SetAttributes(attribute.StringSlice(rekeyedAttr, rekeyed))
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("failed to rekey %s: %w", name, err)
+		}
+		if done {
+			rekeyed = append(rekeyed, name)
+		}
+	}
+	span.SetAttributes(attribute.StringSlice(rekeyedAttr, rekeyed))
+	span.SetStatus(codes.Ok, fmt.Sprintf("rekeyed %d files", len(rekeyed)))
+	logger.Infof("rekeyed %d files", len(rekeyed))
+	return nil
+}
+
+// rekeyFile rewrap the data key of a single backup, returning whether it was wrapped with the old key. The file is
+// verified against its checksums and signature before it is changed, and its checksums and signature are replaced
+// with those of the new file.
+func rekeyFile(ctx context.Context, logger *logrus.Entry, target storage.Storage, files map[string]os.FileInfo, name, tmpdir string, opts RekeyOptions) (bool, error) {
+	local := filepath.Join(tmpdir, filepath.Base(name))
+	defer func() { _ = os.Remove(local) }()
+	if _, err := target.Pull(ctx, name, local, logger); err != nil {
+		return false, fmt.Errorf("failed to pull: %v", err)
+	}
+	if err := verifyChecksums(ctx, logger, target, name, local); err != nil {
+		return false, err
+	}
+
+	in, err := os.Open(local)
+	if err != nil {
+		return false, fmt.Errorf("failed to open: %v", err)
+	}
+	defer func() { _ = in.Close() }()
+	header, rest, err := encrypt.ReadHeader(in)
+	if err != nil {
+		return false, err
+	}
+	oldID := encrypt.MasterKeyID(opts.OldKey)
+	if header == nil || !slices.ContainsFunc(header.Keys, func(w encrypt.WrappedKey) bool { return w.KeyID == oldID }) {
+		logger.Debugf("skipping %s, which has no data key wrapped with master key ID %s", name, oldID)
+		return false, nil
+	}
+	// only a backup that the signing key signed is signed again, so that rekeying cannot vouch for any other file
+	_, signed := files[name+signature.Extension]
+	if signed {
+		if opts.Signer == nil {
+			return false, fmt.Errorf("backup is signed, the signing key is needed to sign it again")
+		}
+		if err := verifySignature(ctx, logger, target, name, local, []signature.PublicKey{opts.Signer.Public()}, true); err != nil {
+			return false, err
+		}
+	}
+	if header.Keys, err = encrypt.Rewrap(header.Keys, opts.OldKey, opts.NewKey); err != nil {
+		return false, err
+	}
+
+	rekeyed := local + ".rekeyed"
+	defer func() { _ = os.Remove(rekeyed) }()
+	out, err := os.Create(rekeyed)
+	if err != nil {
+		return false, fmt.Errorf("failed to create rekeyed file: %v", err)
+	}
+	if err := encrypt.WriteHeader(out, *header); err != nil {
+		_ = out.Close()
+		return false, err
+	}
+	if _, err := io.Copy(out, rest); err != nil {
+		_ = out.Close()
+		return false, fmt.Errorf("failed to write rekeyed file: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return false, fmt.Errorf("failed to write rekeyed file: %v", err)
+	}
+
+	// replace the checksums that the file had, since the header is part of what they cover
+	algorithms, err := storedChecksumAlgorithms(ctx, logger, target, files, name)
+	if err != nil {
+		return false, err
+	}
+	sums, err := fileChecksums(rekeyed, algorithms)
+	if err != nil {
+		return false, err
+	}
+	// keep the metadata of the backup, e.g. its run and schemas, and the time until which it is retained, as the
+	// rekeyed file replaces the object as a whole
+	originalCtx := ctx
+	if store, ok := target.(storage.MetadataStore); ok {
+		metadata, retainUntil, err := store.Metadata(ctx, name, logger)
+		if err != nil {
+			return false, fmt.Errorf("failed to get metadata: %v", err)
+		}
+		originalCtx = util.ContextWithObjectMetadata(originalCtx, metadata)
+		if !retainUntil.IsZero() {
+			originalCtx = util.ContextWithRetainUntil(originalCtx, retainUntil)
+		}
+		ctx = originalCtx
+	}
+	if _, ok := target.(storage.ChecksumStore); ok {
+		metadata := map[string]string{}
+		for algorithm, sum := range sums {
+			metadata[util.MetadataChecksumPrefix+algorithm] = sum
+		}
+		ctx = util.ContextWithObjectMetadata(ctx, metadata)
+	}
+	sidecars := map[string]string{}
+	if _, ok := target.(storage.ChecksumStore); !ok {
+		if sidecars, err = writeChecksumFiles(tmpdir, name, sums); err != nil {
+			return false, err
+		}
+	}
+	if signed {
+		if sidecars[signature.Extension], err = signFile(opts.Signer, tmpdir, name, rekeyed); err != nil {
+			return false, fmt.Errorf("failed to sign: %v", err)
+		}
+	}
+	defer func() {
+		for _, local := range sidecars {
+			_ = os.Remove(local)
+		}
+	}()
+
+	// keep the checksum and signature files that are replaced, to restore them if any push fails
+	originals := map[string]string{}
+	defer func() {
+		for _, local := range originals {
+			_ = os.Remove(local)
+		}
+	}()
+	for ext := range sidecars {
+		if _, ok := files[name+ext]; !ok {
+			continue
+		}
+		original := filepath.Join(tmpdir, filepath.Base(name)+ext+".orig")
+		if _, err := target.Pull(ctx, name+ext, original, logger); err != nil {
+			return false, fmt.Errorf("failed to pull %s file: %v", ext, err)
+		}
+		originals[ext] = original
+	}
+
+	// push the checksum and signature files before the file itself, so that the new file is never in place
+	// without them, and roll back to the original file and its checksum and signature files on failure
+	var pushed []string
+	for _, ext := range slices.Sorted(maps.Keys(sidecars)) {
+		pushed = append(pushed, ext)
+		if _, err := target.Push(ctx, name+ext, sidecars[ext], logger); err != nil {
+			rekeyRollback(originalCtx, logger, target, name, "", originals, pushed)
+			return false, fmt.Errorf("failed to push %s file: %v", ext, err)
+		}
+	}
+	if _, err := target.Push(ctx, name, rekeyed, logger); err != nil {
+		rekeyRollback(originalCtx, logger, target, name, local, originals, pushed)
+		return false, fmt.Errorf("failed to push: %v", err)
+	}
+	return true, nil
+}
+
+// rekeyRollback restore the original file, if given, and the checksum and signature files of it that were
+// replaced, removing those that it did not have. Failures are only logged, as the rekey has failed already.
+func rekeyRollback(ctx context.Context, logger *logrus.Entry, target storage.Storage, name, local string, originals map[string]string, pushed []string) {
+	if local != "" {
+		if _, err := target.Push(ctx, name, local, logger); err != nil {
+			logger.Errorf("failed to restore %s: %v", name, err)
+		}
+	}
+	for _, ext := range pushed {
+		original, ok := originals[ext]
+		if !ok {
+			if err := target.Remove(ctx, name+ext, logger); err != nil {
+				logger.Errorf("failed to remove %s: %v", name+ext, err)
+			}
+			continue
+		}
+		if _, err := target.Push(ctx, name+ext, original, logger); err != nil {
+			logger.Errorf("failed to restore %s: %v", name+ext, err)
+		}
+	}
+}
+
+// storedChecksumAlgorithms the algorithms of the checksums that the target has for a file, from the object
+// metadata if the target stores them there, else from the sidecar files
+func storedChecksumAlgorithms(ctx context.Context, logger *logrus.Entry, target storage.Storage, files map[string]os.FileInfo, name string) ([]string, error) {
+	var algorithms []string
+	if store, ok := target.(storage.ChecksumStore); ok {
+		sums, err := store.Checksums(ctx, name, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checksums of %s: %v", name, err)
+		}
+		for _, algorithm := range checksumAlgorithms {
+			if _, ok := sums[algorithm]; ok {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		return algorithms, nil
+	}
+	for _, algorithm := range checksumAlgorithms {
+		if _, ok := files[name+checksumExtension(algorithm)]; ok {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms, nil
+}
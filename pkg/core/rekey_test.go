@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/storage/credentials"
+	"github.com/databacker/mysql-backup/pkg/util"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRekey(t *testing.T) {
+	const (
+		envelopeFile = "db_backup_2021-01-01T00:00:00Z.tgz.enc"
+		otherFile    = "db_backup_2021-01-02T00:00:00Z.tgz.enc"
+		plainFile    = "db_backup_2021-01-03T00:00:00Z.tgz"
+	)
+	oldKey, newKey, otherKey := masterKey(t), masterKey(t), masterKey(t)
+	payload := []byte("encrypted archive")
+
+	// envelopeBackup an encrypted backup whose data key is wrapped with the master keys, returning it and its data key
+	envelopeBackup := func(t *testing.T, masterKeys ...[]byte) ([]byte, []byte) {
+		dataKey := masterKey(t)
+		var keys []encrypt.WrappedKey
+		for _, k := range masterKeys {
+			w, err := encrypt.WrapKey(k, dataKey)
+			require.NoError(t, err)
+			keys = append(keys, w)
+		}
+		var buf bytes.Buffer
+		require.NoError(t, encrypt.WriteHeader(&buf, encrypt.Header{Algorithm: "chacha20-poly1305", Compression: "gzip", Keys: keys}))
+		buf.Write(payload)
+		return buf.Bytes(), dataKey
+	}
+	sha256File := func(name string, data []byte) string {
+		return fmt.Sprintf("%x  %s\n", sha256.Sum256(data), name)
+	}
+
+	t.Run("rewrap", func(t *testing.T) {
+		envelope, dataKey := envelopeBackup(t, oldKey, otherKey)
+		other, _ := envelopeBackup(t, otherKey)
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			envelopeFile:             string(envelope),
+			envelopeFile + ".sha256": sha256File(envelopeFile, envelope),
+			otherFile:                string(other),
+			plainFile:                "not encrypted",
+		})
+
+		require.NoError(t, rekey(fileStore(t, dir), oldKey, newKey))
+
+		files := readFiles(t, dir)
+		header, rest, err := encrypt.ReadHeader(bytes.NewReader([]byte(files[envelopeFile])))
+		require.NoError(t, err)
+		require.NotNil(t, header)
+		for _, k := range [][]byte{newKey, otherKey} {
+			unwrapped, err := encrypt.UnwrapKey(header.Keys, k)
+			require.NoError(t, err)
+			assert.Equal(t, dataKey, unwrapped)
+		}
+		_, err = encrypt.UnwrapKey(header.Keys, oldKey)
+		assert.Error(t, err)
+		data, err := io.ReadAll(rest)
+		require.NoError(t, err)
+		assert.Equal(t, payload, data, "payload must not change")
+		assert.Equal(t, sha256File(envelopeFile, []byte(files[envelopeFile])), files[envelopeFile+".sha256"])
+		assert.Equal(t, string(other), files[otherFile], "file without the old key must not change")
+		assert.Equal(t, "not encrypted", files[plainFile])
+		assert.NotContains(t, files, otherFile+".sha256")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		envelope, _ := envelopeBackup(t, oldKey)
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			envelopeFile:             string(envelope),
+			envelopeFile + ".sha256": sha256File(envelopeFile, []byte("something else")),
+		})
+		err := rekey(fileStore(t, dir), oldKey, newKey)
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+		assert.Equal(t, string(envelope), readFiles(t, dir)[envelopeFile])
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		signer, err := signature.GenerateKey()
+		require.NoError(t, err)
+		otherSigner, err := signature.GenerateKey()
+		require.NoError(t, err)
+		signedBackup := func(t *testing.T, dir string) {
+			envelope, _ := envelopeBackup(t, oldKey)
+			local := filepath.Join(t.TempDir(), envelopeFile)
+			require.NoError(t, os.WriteFile(local, envelope, 0o644))
+			sig, err := signFile(signer, t.TempDir(), envelopeFile, local)
+			require.NoError(t, err)
+			data, err := os.ReadFile(sig)
+			require.NoError(t, err)
+			writeFiles(t, dir, map[string]string{envelopeFile: string(envelope), envelopeFile + signature.Extension: string(data)})
+		}
+
+		dir := t.TempDir()
+		signedBackup(t, dir)
+		require.NoError(t, rekeyWith(fileStore(t, dir), RekeyOptions{OldKey: oldKey, NewKey: newKey, Signer: signer}))
+		local := filepath.Join(t.TempDir(), envelopeFile)
+		require.NoError(t, os.WriteFile(local, []byte(readFiles(t, dir)[envelopeFile]), 0o644))
+		require.NoError(t, verifySignature(context.Background(), log.NewEntry(log.New()), fileStore(t, dir), envelopeFile, local, []signature.PublicKey{signer.Public()}, true))
+
+		dir = t.TempDir()
+		signedBackup(t, dir)
+		err = rekeyWith(fileStore(t, dir), RekeyOptions{OldKey: oldKey, NewKey: newKey})
+		require.ErrorContains(t, err, "the signing key is needed")
+		err = rekeyWith(fileStore(t, dir), RekeyOptions{OldKey: oldKey, NewKey: newKey, Signer: otherSigner})
+		require.ErrorIs(t, err, signature.ErrInvalid)
+	})
+
+	t.Run("s3 metadata", func(t *testing.T) {
+		envelope, _ := envelopeBackup(t, oldKey)
+		s3backend := s3mem.New()
+		require.NoError(t, s3backend.CreateBucket("mytestbucket"))
+		s3server := httptest.NewServer(gofakes3.New(s3backend).Server())
+		defer s3server.Close()
+		store, err := storage.ParseURL("s3://mytestbucket/backups", credentials.Creds{AWS: credentials.AWSCreds{
+			Endpoint:        s3server.URL,
+			AccessKeyID:     "abcdefg",
+			SecretAccessKey: "1234567",
+			Region:          "us-east-1",
+			PathStyle:       true,
+		}})
+		require.NoError(t, err)
+		local := filepath.Join(t.TempDir(), envelopeFile)
+		require.NoError(t, os.WriteFile(local, envelope, 0o644))
+		ctx := util.ContextWithObjectMetadata(context.Background(), map[string]string{
+			util.MetadataRunID:                           "run",
+			util.MetadataSchemas:                         "db1,db2",
+			util.MetadataChecksumPrefix + ChecksumSHA256: fmt.Sprintf("%x", sha256.Sum256(envelope)),
+		})
+		_, err = store.Push(ctx, envelopeFile, local, log.NewEntry(log.New()))
+		require.NoError(t, err)
+
+		require.NoError(t, rekey(store, oldKey, newKey))
+
+		rekeyed := filepath.Join(t.TempDir(), envelopeFile)
+		_, err = store.Pull(ctx, envelopeFile, rekeyed, log.NewEntry(log.New()))
+		require.NoError(t, err)
+		data, err := os.ReadFile(rekeyed)
+		require.NoError(t, err)
+		metadata, _, err := store.(storage.MetadataStore).Metadata(ctx, envelopeFile, log.NewEntry(log.New()))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			util.MetadataRunID:                           "run",
+			util.MetadataSchemas:                         "db1,db2",
+			util.MetadataChecksumPrefix + ChecksumSHA256: fmt.Sprintf("%x", sha256.Sum256(data)),
+		}, metadata)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		envelope, _ := envelopeBackup(t, oldKey)
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			envelopeFile:             string(envelope),
+			envelopeFile + ".sha256": sha256File(envelopeFile, envelope),
+		})
+		retainUntil := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+		target := &rekeyStorage{Storage: fileStore(t, dir), retainUntil: retainUntil, pushed: map[string]time.Time{}}
+		require.NoError(t, rekey(target, oldKey, newKey))
+		assert.Equal(t, map[string]time.Time{envelopeFile: retainUntil, envelopeFile + ".sha256": retainUntil}, target.pushed)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		envelope, _ := envelopeBackup(t, oldKey)
+		dir := t.TempDir()
+		original := map[string]string{
+			envelopeFile:             string(envelope),
+			envelopeFile + ".sha256": sha256File(envelopeFile, envelope),
+		}
+		writeFiles(t, dir, original)
+		target := &rekeyStorage{Storage: fileStore(t, dir), fail: envelopeFile, pushed: map[string]time.Time{}}
+		err := rekey(target, oldKey, newKey)
+		require.ErrorContains(t, err, "push failed")
+		assert.Equal(t, original, readFiles(t, dir))
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		envelope, _ := envelopeBackup(t, oldKey)
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{envelopeFile: string(envelope)})
+		err := rekey(fileStore(t, dir), oldKey, oldKey)
+		require.ErrorContains(t, err, "the old and new master keys are the same")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		err := rekey(fileStore(t, t.TempDir()), oldKey, []byte("short"))
+		require.ErrorContains(t, err, "must be 32 bytes")
+	})
+}
+
+// rekeyStorage a target that keeps metadata and a retention time for each file, records the retention time that
+// each push carries, and fails the first push of a particular file
+type rekeyStorage struct {
+	storage.Storage
+	retainUntil time.Time
+	fail        string
+	pushed      map[string]time.Time
+}
+
+func (r *rekeyStorage) Metadata(ctx context.Context, target string, logger *log.Entry) (map[string]string, time.Time, error) {
+	return nil, r.retainUntil, nil
+}
+
+func (r *rekeyStorage) Push(ctx context.Context, target, source string, logger *log.Entry) (int64, error) {
+	if target == r.fail {
+		r.fail = ""
+		return 0, errors.New("push failed")
+	}
+	r.pushed[target] = util.RetainUntilFromContext(ctx)
+	return r.Storage.Push(ctx, target, source, logger)
+}
+
+func rekey(target storage.Storage, oldKey, newKey []byte) error {
+	return rekeyWith(target, RekeyOptions{OldKey: oldKey, NewKey: newKey})
+}
+
+func rekeyWith(target storage.Storage, opts RekeyOptions) error {
+	logger := log.New()
+	logger.Out = io.Discard
+	executor := Executor{Logger: logger}
+	opts.Target = target
+	return executor.Rekey(context.Background(), opts)
+}
+
+func masterKey(t *testing.T) []byte {
+	key := make([]byte, encrypt.MasterKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
@@ -0,0 +1,18 @@
+package core
+
+import (
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/google/uuid"
+)
+
+type RekeyOptions struct {
+	Target storage.Storage
+	// OldKey the master key whose wrapped data keys are replaced
+	OldKey []byte
+	// NewKey the master key to wrap the data keys with instead
+	NewKey []byte
+	// Signer the key that signed the backups, to sign them again once they are changed; required for signed backups
+	Signer *signature.SecretKey
+	Run    uuid.UUID
+}
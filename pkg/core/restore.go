@@ -9,8 +9,12 @@ import (
 
 	"github.com/databacker/api/go/api"
 	"github.com/databacker/mysql-backup/pkg/archive"
+	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/database"
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
 	"github.com/databacker/mysql-backup/pkg/util"
+	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
@@ -26,6 +30,7 @@ func (e *Executor) Restore(ctx context.Context, opts RestoreOptions) error {
 	tracer := util.GetTracerFromContext(ctx)
 	ctx, span := tracer.Start(ctx, string(api.BackupSpanRestore))
 	defer span.End()
+	ctx = ratelimit.ContextWithDownload(ctx, ratelimit.New(opts.DownloadRateLimit))
 	logger := e.Logger.WithField("run", opts.Run.String())
 	logger.Level = e.Logger.Level
 
@@ -45,6 +50,22 @@ func (e *Executor) Restore(ctx context.Context, opts RestoreOptions) error {
 	pullSpan.SetAttributes(
 		attribute.Int64(string(api.BackupAttrCopied), copied),
 	)
+	// verify the file before doing anything else with it
+	if !opts.SkipChecksum {
+		if err := verifyChecksums(ctx, logger, opts.Target, opts.TargetFile, tmpRestoreFile); err != nil {
+			_ = os.Remove(tmpRestoreFile)
+			pullSpan.SetStatus(codes.Error, err.Error())
+			pullSpan.End()
+			return fmt.Errorf("failed to verify target file %s: %w", opts.TargetFile, err)
+		}
+	}
+	// and that it is the backup that was signed, before anything in it is applied to the database
+	if err := verifySignature(ctx, logger, opts.Target, opts.TargetFile, tmpRestoreFile, opts.SignatureKeys, opts.RequireSignature); err != nil {
+		_ = os.Remove(tmpRestoreFile)
+		pullSpan.SetStatus(codes.Error, err.Error())
+		pullSpan.End()
+		return fmt.Errorf("failed to verify target file %s: %w", opts.TargetFile, err)
+	}
 	pullSpan.SetStatus(codes.Ok, "completed")
 	pullSpan.End()
 	logger.Debugf("completed copying %d bytes", copied)
@@ -71,16 +92,10 @@ func (e *Executor) Restore(ctx context.Context, opts RestoreOptions) error {
 
 	// create my tar reader to put the files in the directory
 	_, tarSpan := tracer.Start(ctx, string(api.BackupSpanInputTar))
-	cr, err := opts.Compressor.Uncompress(f)
-	if err != nil {
-		tarSpan.SetStatus(codes.Error, fmt.Sprintf("unable to create an uncompressor: %v", err))
+	if err := extract(logger, f, tmpdir, opts); err != nil {
+		tarSpan.SetStatus(codes.Error, err.Error())
 		tarSpan.End()
-		return fmt.Errorf("unable to create an uncompressor: %v", err)
-	}
-	if err := archive.Untar(cr, tmpdir); err != nil {
-		tarSpan.SetStatus(codes.Error, fmt.Sprintf("error extracting the file: %v", err))
-		tarSpan.End()
-		return fmt.Errorf("error extracting the file: %v", err)
+		return err
 	}
 	tarSpan.SetStatus(codes.Ok, "completed")
 	tarSpan.End()
@@ -126,6 +141,117 @@ func (e *Executor) Restore(ctx context.Context, opts RestoreOptions) error {
 	return nil
 }
 
+// extract decrypt, uncompress and untar the backup file into dir. Encrypted files start with a header that says
+// how they were encrypted and compressed, and are compressed inside of the encryption, or, with openpgp, are
+// OpenPGP messages. Other files without a header are either not encrypted, or, if opts.Encryption is set, in the
+// legacy layout, encrypted inside of the compression.
+func extract(logger *log.Entry, f io.Reader, dir string, opts RestoreOptions) error {
+	header, in, err := encrypt.ReadHeader(f)
+	if err != nil {
+		return fmt.Errorf("unable to read the backup file: %v", err)
+	}
+	// openpgp backups have no header, but are OpenPGP messages, which are told apart from the legacy layout unless
+	// another algorithm was given
+	if header == nil && (opts.Encryption == "" || opts.Encryption == string(encrypt.AlgoOpenPGP)) {
+		var isOpenPGP bool
+		if isOpenPGP, in, err = encrypt.DetectOpenPGP(in); err != nil {
+			return fmt.Errorf("unable to read the backup file: %v", err)
+		}
+		if isOpenPGP {
+			header = &encrypt.Header{Algorithm: string(encrypt.AlgoOpenPGP)}
+		}
+	}
+	var (
+		compressor = opts.Compressor
+		encryptor  encrypt.Encryptor
+		legacy     bool
+	)
+	switch {
+	case header != nil:
+		if opts.Encryption != "" && opts.Encryption != header.Algorithm {
+			return fmt.Errorf("backup file %s is encrypted with %s, not %s", opts.TargetFile, header.Algorithm, opts.Encryption)
+		}
+		if opts.EncryptionKey == nil {
+			return fmt.Errorf("backup file %s is encrypted with %s, but no encryption key was given", opts.TargetFile, header.Algorithm)
+		}
+		key := opts.EncryptionKey
+		// with envelope encryption, the given key is a master key, which unwraps the data key of the file
+		if len(header.Keys) > 0 {
+			if key, err = encrypt.UnwrapKey(header.Keys, opts.EncryptionKey); err != nil {
+				return fmt.Errorf("backup file %s: %v", opts.TargetFile, err)
+			}
+		}
+		if encryptor, err = encrypt.GetEncryptor(header.Algorithm, key, encrypt.WithKeyPassword(opts.EncryptionKeyPassword)); err != nil {
+			return fmt.Errorf("unable to create a decryptor: %v", err)
+		}
+		if header.KeyID != "" && encryptor.KeyID() != "" && header.KeyID != encryptor.KeyID() {
+			return fmt.Errorf("backup file %s is encrypted with the key with ID %s, not the given key with ID %s", opts.TargetFile, header.KeyID, encryptor.KeyID())
+		}
+		logger.Debugf("backup file %s is encrypted with %s, key ID %s, compressed with %s", opts.TargetFile, header.Algorithm, header.KeyID, header.Compression)
+		// the compression that the file records is the one that it has, whatever was given
+		if header.Compression != "" {
+			if compressor, err = compression.GetCompressor(header.Compression); err != nil {
+				return fmt.Errorf("unable to create an uncompressor: %v", err)
+			}
+		}
+		decrypted := decryptReader(encryptor, in)
+		defer func() { _ = decrypted.Close() }()
+		in = decrypted
+	case opts.Encryption != "":
+		if encryptor, err = encrypt.GetEncryptor(opts.Encryption, opts.EncryptionKey, encrypt.WithKeyPassword(opts.EncryptionKeyPassword)); err != nil {
+			return fmt.Errorf("unable to create a decryptor: %v", err)
+		}
+		logger.Debugf("backup file %s has no encryption header, decrypting it as the legacy layout", opts.TargetFile)
+		legacy = true
+	}
+	// a backup that was replaced with one that is not encrypted must not be restored as if it were genuine
+	if encryptor == nil && opts.EncryptionKey != nil {
+		return fmt.Errorf("backup file %s is not encrypted, but an encryption key was given", opts.TargetFile)
+	}
+
+	if compressor == nil {
+		if compressor, in, err = compression.Detect(in); err != nil {
+			return fmt.Errorf("unable to detect the compression: %v", err)
+		}
+		logger.Debugf("detected compression of %s as %s", opts.TargetFile, compressor.Name())
+	}
+	cr, err := compressor.Uncompress(in)
+	if err != nil {
+		return fmt.Errorf("unable to create an uncompressor: %v", err)
+	}
+	if legacy {
+		decrypted := decryptReader(encryptor, cr)
+		defer func() { _ = decrypted.Close() }()
+		cr = decrypted
+	}
+	if err := archive.Untar(cr, dir); err != nil {
+		return fmt.Errorf("error extracting the file: %v", err)
+	}
+	// the archive may end before the encrypted data does, which must be read to the end to be authenticated
+	if encryptor != nil {
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return fmt.Errorf("error decrypting the file: %v", err)
+		}
+	}
+	return nil
+}
+
+// decryptReader a reader of the decryption of in, which is decrypted as it is read. Close it to stop decrypting.
+func decryptReader(encryptor encrypt.Encryptor, in io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := encryptor.Decrypt(pw)
+		if err == nil {
+			_, err = io.Copy(w, in)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
 // run pre-restore scripts, if they exist
 func preRestore(ctx context.Context, target string) error {
 	// construct any additional environment
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/archive"
+	"github.com/databacker/mysql-backup/pkg/compression"
+	"github.com/databacker/mysql-backup/pkg/encrypt"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestExtract(t *testing.T) {
+	logger := log.New()
+	logger.Out = io.Discard
+	files := map[string]string{
+		"db1_2021-01-01T00:00:00Z.sql": strings.Repeat("INSERT INTO `t` VALUES (1,'abc');\n", 10000),
+		"db2_2021-01-01T00:00:00Z.sql": "CREATE TABLE `u` (`id` int);\n",
+	}
+	srcDir := t.TempDir()
+	writeFiles(t, srcDir, files)
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	otherKey := make([]byte, 32)
+	_, err = rand.Read(otherKey)
+	require.NoError(t, err)
+	encryptor, err := encrypt.GetEncryptor("chacha20-poly1305", key)
+	require.NoError(t, err)
+	gzip := &compression.GzipCompressor{}
+	zstd := &compression.ZstdCompressor{}
+
+	// archive the way that dump does
+	newLayout := func(t *testing.T, compressor compression.Compressor, encryptor encrypt.Encryptor) []byte {
+		var buf bytes.Buffer
+		w, err := newArchiveWriter(&buf, compressor, encryptor, nil)
+		require.NoError(t, err)
+		require.NoError(t, archive.Tar(srcDir, w))
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	// archive the way that dump did, encrypting before compressing, with no header
+	legacyLayout := func(t *testing.T) []byte {
+		var buf bytes.Buffer
+		cw, err := gzip.Compress(&buf)
+		require.NoError(t, err)
+		ew, err := encryptor.Encrypt(cw)
+		require.NoError(t, err)
+		require.NoError(t, archive.Tar(srcDir, ew))
+		require.NoError(t, ew.Close())
+		require.NoError(t, cw.Close())
+		return buf.Bytes()
+	}
+	encrypted := newLayout(t, zstd, encryptor)
+	certPEM, pfx := smimeKeys(t)
+	smimeEncryptor, err := encrypt.GetEncryptor("smime-aes256-cbc", certPEM)
+	require.NoError(t, err)
+	smimeEncrypted := newLayout(t, gzip, smimeEncryptor)
+	truncated := encrypted[:len(encrypted)-10]
+	// envelope encryption, with the data key wrapped with both keys
+	envelope, err := encrypt.NewEnvelope("aes256-cbc", [][]byte{key, otherKey})
+	require.NoError(t, err)
+	dataEncryptor, wrappedKeys, err := envelope.NewDataKey()
+	require.NoError(t, err)
+	var envelopeBuf bytes.Buffer
+	ew, err := newArchiveWriter(&envelopeBuf, gzip, dataEncryptor, wrappedKeys)
+	require.NoError(t, err)
+	require.NoError(t, archive.Tar(srcDir, ew))
+	require.NoError(t, ew.Close())
+	envelopeEncrypted := envelopeBuf.Bytes()
+	// openpgp, which has no header, so that gpg reads it
+	pgpKey, err := encrypt.GenerateKey("openpgp", "backup host")
+	require.NoError(t, err)
+	pgpEncryptor, err := encrypt.GetEncryptor("openpgp", pgpKey.Public)
+	require.NoError(t, err)
+	pgpEncrypted := newLayout(t, gzip, pgpEncryptor)
+	pgpSigner, err := encrypt.GenerateKey("openpgp", "backup host")
+	require.NoError(t, err)
+	require.False(t, bytes.HasPrefix(pgpEncrypted, []byte(encrypt.HeaderMagic)), "openpgp backup has a header")
+
+	tests := []struct {
+		name string
+		data []byte
+		opts RestoreOptions
+		err  string
+	}{
+		{"not encrypted", newLayout(t, gzip, nil), RestoreOptions{}, ""},
+		{"not encrypted with key", newLayout(t, gzip, nil), RestoreOptions{EncryptionKey: key}, "is not encrypted, but an encryption key was given"},
+		{"encrypted", encrypted, RestoreOptions{EncryptionKey: key}, ""},
+		{"encrypted with algorithm", encrypted, RestoreOptions{Encryption: "chacha20-poly1305", EncryptionKey: key}, ""},
+		{"encrypted with other compression given", encrypted, RestoreOptions{EncryptionKey: key, Compressor: gzip}, ""},
+		{"encrypted without key", encrypted, RestoreOptions{}, "but no encryption key was given"},
+		{"encrypted with other algorithm", encrypted, RestoreOptions{Encryption: "aes-256-cbc", EncryptionKey: key}, "is encrypted with chacha20-poly1305, not aes-256-cbc"},
+		{"encrypted with other key", encrypted, RestoreOptions{EncryptionKey: otherKey}, "is encrypted with the key with ID"},
+		{"encrypted and truncated", truncated, RestoreOptions{EncryptionKey: key}, "decrypt"},
+		{"smime with PKCS#12", smimeEncrypted, RestoreOptions{EncryptionKey: pfx, EncryptionKeyPassword: "secret"}, ""},
+		{"smime with wrong password", smimeEncrypted, RestoreOptions{EncryptionKey: pfx, EncryptionKeyPassword: "wrong"}, "PKCS#12"},
+		{"smime with certificate only", smimeEncrypted, RestoreOptions{EncryptionKey: certPEM}, "needs the private key"},
+		{"envelope with first master key", envelopeEncrypted, RestoreOptions{EncryptionKey: key}, ""},
+		{"envelope with second master key", envelopeEncrypted, RestoreOptions{EncryptionKey: otherKey}, ""},
+		{"envelope with other master key", envelopeEncrypted, RestoreOptions{EncryptionKey: masterKey(t)}, "not the given key with ID"},
+		{"openpgp", pgpEncrypted, RestoreOptions{EncryptionKey: pgpKey.Private}, ""},
+		{"openpgp with algorithm", pgpEncrypted, RestoreOptions{Encryption: "openpgp", EncryptionKey: pgpKey.Private}, ""},
+		{"openpgp unsigned with signer key", pgpEncrypted, RestoreOptions{EncryptionKey: append(append([]byte{}, pgpKey.Private...), pgpSigner.Public...)}, "not signed"},
+		{"openpgp without key", pgpEncrypted, RestoreOptions{}, "is encrypted with openpgp, but no encryption key was given"},
+		{"legacy", legacyLayout(t), RestoreOptions{Encryption: "chacha20-poly1305", EncryptionKey: key}, ""},
+		{"legacy without algorithm", legacyLayout(t), RestoreOptions{EncryptionKey: key}, "is not encrypted, but an encryption key was given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := extract(logger.WithField("test", tt.name), bytes.NewReader(tt.data), dir, tt.opts)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, files, readFiles(t, dir))
+		})
+	}
+}
+
+// smimeKeys a self-signed certificate in PEM, and it with its key in PKCS#12 with the password "secret"
+func smimeKeys(t *testing.T) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "restore test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pfx, err := pkcs12.Modern.Encode(key, cert, nil, "secret")
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pfx
+}
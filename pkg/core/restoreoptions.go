@@ -3,6 +3,8 @@ package core
 import (
 	"github.com/databacker/mysql-backup/pkg/compression"
 	"github.com/databacker/mysql-backup/pkg/database"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/signature"
 	"github.com/databacker/mysql-backup/pkg/storage"
 	"github.com/google/uuid"
 )
@@ -12,6 +14,24 @@ type RestoreOptions struct {
 	TargetFile   string
 	DBConn       *database.Connection
 	DatabasesMap map[string]string
-	Compressor   compression.Compressor
-	Run          uuid.UUID
+	// Compressor how the backup file is compressed, unless its header says; if nil, detected from the file
+	Compressor compression.Compressor
+	Run        uuid.UUID
+	// SkipChecksum do not verify the backup file against its checksums before restoring it
+	SkipChecksum bool
+	// DownloadRateLimit the most bytes per second to download the backup file from the target
+	DownloadRateLimit ratelimit.Schedule
+	// Encryption the encryption algorithm of the backup file. Encrypted files record it in their header, so it is
+	// needed only for files in the legacy layout, which were encrypted before being compressed. If set for a file
+	// with a header, it must match.
+	Encryption string
+	// EncryptionKey the key to decrypt the backup file with, as given to encrypt.GetEncryptor, or with envelope
+	// encryption, a master key; nil if not encrypted
+	EncryptionKey []byte
+	// EncryptionKeyPassword the password of the encryption key, for keys protected by one, e.g. PKCS#12 for S/MIME
+	EncryptionKeyPassword string
+	// SignatureKeys the public keys to verify the signature of the backup file with, if it has one
+	SignatureKeys []signature.PublicKey
+	// RequireSignature fail unless the backup file has a signature by one of SignatureKeys
+	RequireSignature bool
 }
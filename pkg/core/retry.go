@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = 1 * time.Second
+	defaultRetryMaxBackoff = 1 * time.Minute
+)
+
+// RetryOptions how to retry a failed operation
+type RetryOptions struct {
+	// Retries how many times to retry after the first attempt fails; 0 means no retries
+	Retries int
+	// Backoff how long to wait before the first retry, doubling for each retry after that. Defaults to 1s.
+	Backoff time.Duration
+	// MaxBackoff the longest to wait between retries. Defaults to 1m.
+	MaxBackoff time.Duration
+}
+
+// delay how long to wait before the given retry, counting from 1, with exponential backoff and jitter.
+// The jitter is between half and all of the backoff, so that targets that fail together do not retry together.
+func (r RetryOptions) delay(retry int) time.Duration {
+	backoff, maxBackoff := r.Backoff, r.MaxBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	d := backoff
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retry run fn until it succeeds, the retries are exhausted, or the context is cancelled.
+// Returns the number of attempts and the last error.
+func (r RetryOptions) retry(ctx context.Context, fn func(attempt int) error) (int, error) {
+	var (
+		attempt int
+		err     error
+	)
+	for attempt = 1; ; attempt++ {
+		if err = fn(attempt); err == nil || attempt > r.Retries {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(r.delay(attempt)):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	r := RetryOptions{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		{50, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := r.delay(tt.retry)
+			if d < tt.min || d > tt.max {
+				t.Errorf("retry %d: delay %v not between %v and %v", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failure := errors.New("failed")
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		attempts int
+		err      error
+	}{
+		{"success first time", 3, 0, 1, nil},
+		{"success after retries", 3, 2, 3, nil},
+		{"no retries", 0, 1, 1, failure},
+		{"retries exhausted", 2, 5, 3, failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RetryOptions{Retries: tt.retries, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+			attempts, err := r.retry(context.Background(), func(attempt int) error {
+				if attempt <= tt.failures {
+					return failure
+				}
+				return nil
+			})
+			assert.Equal(t, tt.attempts, attempts)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
+)
+
+// isSidecarFile whether the file is a checksum or signature file that belongs to a backup, rather than a backup
+func isSidecarFile(name string) bool {
+	return isChecksumFile(name) || strings.HasSuffix(name, signature.Extension)
+}
+
+// sidecarFiles the names of all of the possible checksum and signature files of a backup file
+func sidecarFiles(name string) []string {
+	return append(checksumFiles(name), name+signature.Extension)
+}
+
+// signFile sign a local backup file, writing the signature file to dir. Returns the local signature file.
+func signFile(key *signature.SecretKey, dir, filename, localFile string) (string, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", localFile, err)
+	}
+	defer func() { _ = f.Close() }()
+	sig, err := key.Sign(f, path.Base(filename))
+	if err != nil {
+		return "", err
+	}
+	local := path.Join(dir, path.Base(filename)+signature.Extension)
+	if err := os.WriteFile(local, sig, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write signature file %s: %v", local, err)
+	}
+	return local, nil
+}
+
+// verifySignature check a backup file pulled from the target against its signature file, with the public keys.
+// If the backup has no signature file, or there are no keys to verify it with, it fails if a signature is
+// required, else the backup is not verified.
+func verifySignature(ctx context.Context, logger *logrus.Entry, target storage.Storage, targetFile, localFile string, keys []signature.PublicKey, required bool) error {
+	sigFile := targetFile + signature.Extension
+	dir := path.Dir(targetFile)
+	if dir == "." {
+		dir = ""
+	}
+	infos, err := target.ReadDir(ctx, dir, logger)
+	if err != nil {
+		return fmt.Errorf("failed to list signature files: %v", err)
+	}
+	signed := false
+	for _, info := range infos {
+		if path.Base(info.Name()) == path.Base(sigFile) {
+			signed = true
+			break
+		}
+	}
+	switch {
+	case !signed && required:
+		return fmt.Errorf("%w: %s is not signed, and a signature is required", signature.ErrInvalid, targetFile)
+	case !signed:
+		if len(keys) > 0 {
+			logger.Warnf("no signature found for %s, not verifying", targetFile)
+		}
+		return nil
+	case len(keys) == 0 && required:
+		return fmt.Errorf("a signature is required, but no public keys were given to verify it with")
+	case len(keys) == 0:
+		logger.Warnf("no public keys given to verify the signature of %s, not verifying", targetFile)
+		return nil
+	}
+
+	local, err := os.CreateTemp("", "signature")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	_ = local.Close()
+	defer func() { _ = os.Remove(local.Name()) }()
+	if _, err := target.Pull(ctx, sigFile, local.Name(), logger); err != nil {
+		return fmt.Errorf("failed to pull signature file %s: %v", sigFile, err)
+	}
+	sig, err := os.ReadFile(local.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read signature file %s: %v", sigFile, err)
+	}
+	f, err := os.Open(localFile)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", localFile, err)
+	}
+	defer func() { _ = f.Close() }()
+	verified, err := signature.Verify(f, sig, keys)
+	if err != nil {
+		return fmt.Errorf("signature of %s: %w", targetFile, err)
+	}
+	// a valid signature of another backup, e.g. an older one, does not vouch for this one, so the signature must
+	// name the file in its trusted comment, as those that dump makes do
+	switch name := verified.Filename(); name {
+	case path.Base(targetFile):
+	case "":
+		return fmt.Errorf("%w: signature of %s has no trusted comment that names the file", signature.ErrInvalid, targetFile)
+	default:
+		return fmt.Errorf("%w: signature of %s is for the file %s", signature.ErrInvalid, targetFile, name)
+	}
+	logger.Debugf("verified signature of %s by the key with ID %s", targetFile, verified.Key.ID())
+	return nil
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/databacker/mysql-backup/pkg/signature"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatures(t *testing.T) {
+	const (
+		filename = "db_backup_2021-01-01T00:00:00Z.tgz"
+		other    = "db_backup_2021-01-02T00:00:00Z.tgz"
+	)
+	logger := log.New()
+	logger.Out = io.Discard
+	entry := log.NewEntry(logger)
+	key, err := signature.GenerateKey()
+	require.NoError(t, err)
+	otherKey, err := signature.GenerateKey()
+	require.NoError(t, err)
+	keys := []signature.PublicKey{key.Public()}
+
+	tmpdir := t.TempDir()
+	source := filepath.Join(tmpdir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("abc"), 0o644))
+	sidecars, err := writeChecksumFiles(tmpdir, filename, map[string]string{ChecksumSHA256: abcSHA256})
+	require.NoError(t, err)
+	sidecars[signature.Extension], err = signFile(key, tmpdir, filename, source)
+	require.NoError(t, err)
+	sig, err := os.ReadFile(sidecars[signature.Extension])
+	require.NoError(t, err)
+	// the signature without its trusted comment, which is valid, but does not say which file it is for
+	untrusted := strings.Join(strings.SplitN(string(sig), "\n", 3)[:2], "\n") + "\n"
+
+	t.Run("upload", func(t *testing.T) {
+		fileDir, metadataDir := t.TempDir(), t.TempDir()
+		targets := []storage.Storage{fileStore(t, fileDir), checksumStorage{Storage: fileStore(t, metadataDir)}}
+		_, err := uploadTargets(context.Background(), entry, targets, filename, source, sidecars, DumpOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{filename, filename + ".minisig", filename + ".sha256"}, slices.Sorted(maps.Keys(readFiles(t, fileDir))))
+		// a target that stores checksums itself still gets the signature file
+		assert.Equal(t, []string{filename, filename + ".minisig"}, slices.Sorted(maps.Keys(readFiles(t, metadataDir))))
+	})
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		local    string
+		keys     []signature.PublicKey
+		required bool
+		err      string
+	}{
+		{"valid", map[string]string{filename + ".minisig": string(sig)}, "abc", keys, true, ""},
+		{"valid with other keys", map[string]string{filename + ".minisig": string(sig)}, "abc", []signature.PublicKey{otherKey.Public(), key.Public()}, true, ""},
+		{"tampered", map[string]string{filename + ".minisig": string(sig)}, "abd", keys, false, "does not match the signature"},
+		{"other key", map[string]string{filename + ".minisig": string(sig)}, "abc", []signature.PublicKey{otherKey.Public()}, false, "not one of the given keys"},
+		{"signature of other file", map[string]string{other + ".minisig": string(sig)}, "abc", keys, false, "is for the file " + filename},
+		{"no trusted comment", map[string]string{filename + ".minisig": untrusted}, "abc", keys, false, "has no trusted comment"},
+		{"unsigned", nil, "abc", keys, false, ""},
+		{"unsigned and required", nil, "abc", keys, true, "is not signed"},
+		{"no keys", map[string]string{filename + ".minisig": string(sig)}, "abc", nil, false, ""},
+		{"no keys and required", map[string]string{filename + ".minisig": string(sig)}, "abc", nil, true, "no public keys"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			// the backup itself, under the name whose signature is checked
+			name := filename
+			if _, ok := tt.files[other+".minisig"]; ok {
+				name = other
+			}
+			local := filepath.Join(t.TempDir(), "local")
+			require.NoError(t, os.WriteFile(local, []byte(tt.local), 0o644))
+			err := verifySignature(context.Background(), entry, fileStore(t, dir), name, local, tt.keys, tt.required)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
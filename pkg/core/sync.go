@@ -0,0 +1,467 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+// Sync copy backups from one target to others, so that each destination has every backup in the source
+func (e *Executor) Sync(ctx context.Context, opts SyncOptions) error {
+	tracer := util.GetTracerFromContext(ctx)
+	ctx, span := tracer.Start(ctx, syncSpan)
+	defer span.End()
+	ctx = ratelimit.ContextWithDownload(ctx, ratelimit.New(opts.DownloadRateLimit))
+	ctx = ratelimit.ContextWithUpload(ctx, ratelimit.New(opts.UploadRateLimit))
+	logger := e.Logger.WithField("run", opts.Run.String())
+	logger.Level = e.Logger.Level
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if opts.From == nil {
+		return errors.New("no source")
+	}
+	if len(opts.To) == 0 {
+		return errors.New("no targets")
+	}
+	var retainHours, retainCount int
+	if opts.Retention != "" {
+		var err1, err2 error
+		retainHours, err1 = convertToHours(opts.Retention)
+		retainCount, err2 = convertToCount(opts.Retention)
+		if (err1 != nil && err2 != nil) || (retainHours <= 0 && retainCount <= 0) {
+			return fmt.Errorf("invalid retention string: %s", opts.Retention)
+		}
+	}
+	logger.Infof("beginning sync from %s", opts.From.URL())
+
+	source, err := newSyncSource(ctx, logger, opts.From)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer source.close()
+
+	// the backups to copy, excluding any outside of the retention policy
+	wanted := source.backups()
+	if opts.Retention != "" {
+		var infos []fs.FileInfo
+		for _, name := range wanted {
+			infos = append(infos, source.files[name])
+		}
+		expired, _, _, err := pruneCandidates(logger, infos, now, retainHours, retainCount)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		wanted = slices.DeleteFunc(wanted, func(name string) bool { return slices.Contains(expired, name) })
+	}
+
+	// list every target first, so that each backup is pulled only once, and removed locally once
+	// all of the targets have it
+	existing := make([]map[string]fs.FileInfo, len(opts.To))
+	for i, target := range opts.To {
+		if existing[i], err = listFiles(ctx, logger, target); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("failed to read directory of target %s: %v", target.URL(), err)
+		}
+	}
+	copied := make([][]string, len(opts.To))
+	// the files that belong in each target, with their checksum and signature files
+	var keep []string
+	for _, name := range wanted {
+		fileCtx := ctx
+		// only a time-based retention policy gives a date until which the backup must be kept, as for dump
+		if retainHours > 0 {
+			fileCtx = util.ContextWithRetainUntil(ctx, backupTime(source.files[name]).Add(time.Duration(retainHours)*time.Hour))
+		}
+		sidecars, err := source.sidecars(ctx, name)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		keep = append(keep, name)
+		keep = append(keep, sidecars...)
+		for i, target := range opts.To {
+			needed, reason, err := syncNeeded(ctx, logger, source, target, existing[i], name)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return err
+			}
+			_, checksumStore := target.(storage.ChecksumStore)
+			if needed {
+				logger.Debugf("copying %s to %s: %s", name, target.URL(), reason)
+				pushCtx := fileCtx
+				if checksumStore {
+					if pushCtx, err = source.checksumMetadata(fileCtx, name); err != nil {
+						span.SetStatus(codes.Error, err.Error())
+						return err
+					}
+				}
+				if err := source.copyTo(pushCtx, target, name); err != nil {
+					span.SetStatus(codes.Error, err.Error())
+					return err
+				}
+				copied[i] = append(copied[i], name)
+			}
+			for _, sidecar := range sidecars {
+				// a target that stores checksums itself gets no checksum files, as for dump
+				if checksumStore && isChecksumFile(sidecar) {
+					continue
+				}
+				if _, ok := existing[i][sidecar]; needed || !ok {
+					if err := source.copyTo(fileCtx, target, sidecar); err != nil {
+						span.SetStatus(codes.Error, err.Error())
+						return err
+					}
+				}
+			}
+		}
+		source.release(name)
+	}
+	for i, target := range opts.To {
+		if err := finishSyncTarget(ctx, logger, target, existing[i], keep, copied[i], opts.Delete); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("failed to sync to target %s: %v", target.URL(), err)
+		}
+		if opts.Retention != "" {
+			if err := pruneTarget(ctx, logger, target, now, retainHours, retainCount); err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return fmt.Errorf("failed to prune target %s: %v", target.URL(), err)
+			}
+		}
+	}
+	span.SetStatus(codes.Ok, "completed")
+	logger.Info("finished sync")
+	return nil
+}
+
+// finishSyncTarget record what was copied to a single target, and remove any files that do not belong there, if requested
+func finishSyncTarget(ctx context.Context, logger *logrus.Entry, target storage.Storage, existing map[string]fs.FileInfo, keep, copied []string, deleteExtra bool) error {
+	ctx, span := util.GetTracerFromContext(ctx).Start(ctx, fmt.Sprintf("%s %s", syncTargetSpan, target.URL()))
+	defer span.End()
+
+	span.SetAttributes(attribute.StringSlice(copiedAttr, copied))
+	logger.Infof("copied %d files to %s", len(copied), target.URL())
+
+	if !deleteExtra {
+		span.SetStatus(codes.Ok, fmt.Sprintf("copied %d files", len(copied)))
+		return nil
+	}
+	var extra []string
+	for name := range existing {
+		if !slices.Contains(keep, name) {
+			extra = append(extra, name)
+		}
+	}
+	slices.Sort(extra)
+	removed, locked, err := removeUnlocked(ctx, logger, target, extra)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if len(locked) > 0 {
+		span.SetAttributes(attribute.StringSlice(lockedAttr, locked))
+	}
+	logger.Infof("removed %d files from %s", len(removed), target.URL())
+	span.SetStatus(codes.Ok, fmt.Sprintf("copied %d files, removed %d files", len(copied), len(removed)))
+	return nil
+}
+
+// listFiles list the files, but not directories, in a target, by name
+func listFiles(ctx context.Context, logger *logrus.Entry, store storage.Storage) (map[string]fs.FileInfo, error) {
+	infos, err := store.ReadDir(ctx, "", logger)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]fs.FileInfo{}
+	for _, info := range infos {
+		if !info.IsDir() {
+			files[info.Name()] = info
+		}
+	}
+	return files, nil
+}
+
+// syncNeeded determine whether a backup needs to be copied to the target: it is missing, the size differs,
+// or both have checksum files whose checksums differ.
+func syncNeeded(ctx context.Context, logger *logrus.Entry, source *syncSource, target storage.Storage, existing map[string]fs.FileInfo, name string) (bool, string, error) {
+	info, ok := existing[name]
+	if !ok {
+		return true, "missing", nil
+	}
+	if info.Size() != source.files[name].Size() {
+		return true, fmt.Sprintf("size %d does not match source size %d", info.Size(), source.files[name].Size()), nil
+	}
+	sum := name + checksumExtension(ChecksumSHA256)
+	if _, ok := existing[sum]; !ok {
+		return false, "", nil
+	}
+	if _, ok := source.files[sum]; !ok {
+		return false, "", nil
+	}
+	sourceSum, err := source.checksum(ctx, sum)
+	if err != nil {
+		return false, "", err
+	}
+	targetSum, err := readChecksum(ctx, logger, target, sum, source.tmpdir)
+	if err != nil {
+		return false, "", err
+	}
+	if sourceSum != targetSum {
+		return true, "checksum does not match source", nil
+	}
+	return false, "", nil
+}
+
+// backupTime the time of a backup, from its name, or else when it was last modified
+func backupTime(info fs.FileInfo) time.Time {
+	matches := filenameRE.FindStringSubmatch(path.Base(info.Name()))
+	if matches != nil {
+		if t, err := time.Parse(time.RFC3339, fmt.Sprintf("%s-%s-%sT%s:%s:%sZ", matches[1], matches[2], matches[3], matches[4], matches[5], matches[6])); err == nil {
+			return t
+		}
+	}
+	return info.ModTime()
+}
+
+// syncSource the source of a sync, which pulls each file at most once, no matter how many targets need it
+type syncSource struct {
+	store  storage.Storage
+	logger *logrus.Entry
+	files  map[string]fs.FileInfo
+	tmpdir string
+	pulled map[string]string
+	// sums the checksums from checksum files, by file
+	sums map[string]string
+	// backupSums the checksums of each backup, by algorithm, from the object metadata or the checksum files
+	backupSums map[string]map[string]string
+}
+
+func newSyncSource(ctx context.Context, logger *logrus.Entry, store storage.Storage) (*syncSource, error) {
+	files, err := listFiles(ctx, logger, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source directory: %v", err)
+	}
+	tmpdir, err := os.MkdirTemp("", "databacker_sync")
+	if err != nil {
+		return nil, fmt.Errorf("failed to make temporary working directory: %v", err)
+	}
+	s := &syncSource{
+		store:      store,
+		logger:     logger,
+		files:      files,
+		tmpdir:     tmpdir,
+		pulled:     map[string]string{},
+		sums:       map[string]string{},
+		backupSums: map[string]map[string]string{},
+	}
+	return s, nil
+}
+
+// backups the names of all of the files, other than checksum and signature files, in order
+func (s *syncSource) backups() []string {
+	var names []string
+	for name := range s.files {
+		if !isSidecarFile(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// copyTo copy a single file from the source to the target, verifying a backup against its checksums when it is pulled
+func (s *syncSource) copyTo(ctx context.Context, target storage.Storage, name string) error {
+	local, ok := s.pulled[name]
+	if !ok {
+		f, err := os.CreateTemp(s.tmpdir, "pull")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file: %v", err)
+		}
+		_ = f.Close()
+		local = f.Name()
+		if _, err := s.store.Pull(ctx, name, local, s.logger); err != nil {
+			return fmt.Errorf("failed to pull %s: %v", name, err)
+		}
+		s.pulled[name] = local
+		if !isSidecarFile(name) {
+			if err := s.verify(ctx, name, local); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := target.Push(ctx, name, local, s.logger); err != nil {
+		return fmt.Errorf("failed to push %s: %v", name, err)
+	}
+	return nil
+}
+
+// backupChecksums the checksums of a backup, by algorithm, from the object metadata if the source stores them
+// there, else from its checksum files; none for backups without any, e.g. from older versions
+func (s *syncSource) backupChecksums(ctx context.Context, name string) (map[string]string, error) {
+	if sums, ok := s.backupSums[name]; ok {
+		return sums, nil
+	}
+	sums := map[string]string{}
+	if store, ok := s.store.(storage.ChecksumStore); ok {
+		metadata, err := store.Checksums(ctx, name, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checksums of %s: %v", name, err)
+		}
+		for algorithm, sum := range metadata {
+			if slices.Contains(checksumAlgorithms, algorithm) {
+				sums[algorithm] = strings.ToLower(sum)
+			}
+		}
+	}
+	for _, algorithm := range checksumAlgorithms {
+		sidecar := name + checksumExtension(algorithm)
+		if _, ok := s.files[sidecar]; !ok || sums[algorithm] != "" {
+			continue
+		}
+		sum, err := s.checksum(ctx, sidecar)
+		if err != nil {
+			return nil, err
+		}
+		sums[algorithm] = sum
+	}
+	s.backupSums[name] = sums
+	return sums, nil
+}
+
+// verify check a pulled backup against its checksums, so that a corrupted backup is not copied to the targets
+func (s *syncSource) verify(ctx context.Context, name, local string) error {
+	expected, err := s.backupChecksums(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(expected) == 0 {
+		s.logger.Warnf("no checksum found for %s, not verifying", name)
+		return nil
+	}
+	actual, err := fileChecksums(local, slices.Collect(maps.Keys(expected)))
+	if err != nil {
+		return err
+	}
+	for _, algorithm := range slices.Sorted(maps.Keys(expected)) {
+		if actual[algorithm] != expected[algorithm] {
+			return fmt.Errorf("%w: %s of %s in source is %s, expected %s", ErrChecksumMismatch, algorithm, name, actual[algorithm], expected[algorithm])
+		}
+	}
+	return nil
+}
+
+// sidecars the checksum and signature files that belong with a backup: those in the source, and a checksum file
+// for each checksum that the source keeps only in its object metadata, which is written locally to be copied
+func (s *syncSource) sidecars(ctx context.Context, name string) ([]string, error) {
+	sums, err := s.backupChecksums(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var sidecars []string
+	for _, sidecar := range sidecarFiles(name) {
+		if _, ok := s.files[sidecar]; ok {
+			sidecars = append(sidecars, sidecar)
+			continue
+		}
+		algorithm := strings.TrimPrefix(path.Ext(sidecar), ".")
+		sum, ok := sums[algorithm]
+		if !ok || !isChecksumFile(sidecar) {
+			continue
+		}
+		if _, ok := s.pulled[sidecar]; !ok {
+			dir, err := os.MkdirTemp(s.tmpdir, "checksum")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+			}
+			local, err := writeChecksumFiles(dir, name, map[string]string{algorithm: sum})
+			if err != nil {
+				return nil, err
+			}
+			s.pulled[sidecar] = local[checksumExtension(algorithm)]
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+	return sidecars, nil
+}
+
+// checksumMetadata the context with the checksums of a backup as object metadata, for targets that store them there
+func (s *syncSource) checksumMetadata(ctx context.Context, name string) (context.Context, error) {
+	sums, err := s.backupChecksums(ctx, name)
+	if err != nil {
+		return ctx, err
+	}
+	metadata := map[string]string{}
+	for algorithm, sum := range sums {
+		metadata[util.MetadataChecksumPrefix+algorithm] = sum
+	}
+	return util.ContextWithObjectMetadata(ctx, metadata), nil
+}
+
+// release remove the local copy of a file and its checksum and signature files, once all of the targets have them
+func (s *syncSource) release(name string) {
+	for _, n := range append([]string{name}, sidecarFiles(name)...) {
+		if local, ok := s.pulled[n]; ok {
+			_ = os.Remove(local)
+			delete(s.pulled, n)
+		}
+	}
+	delete(s.backupSums, name)
+}
+
+// checksum the checksum from a checksum file in the source
+func (s *syncSource) checksum(ctx context.Context, name string) (string, error) {
+	if sum, ok := s.sums[name]; ok {
+		return sum, nil
+	}
+	sum, err := readChecksum(ctx, s.logger, s.store, name, s.tmpdir)
+	if err != nil {
+		return "", err
+	}
+	s.sums[name] = sum
+	return sum, nil
+}
+
+func (s *syncSource) close() {
+	_ = os.RemoveAll(s.tmpdir)
+}
+
+// readChecksum read the checksum from a checksum file in the format of sha256sum, i.e. "<hex>  <filename>"
+func readChecksum(ctx context.Context, logger *logrus.Entry, store storage.Storage, name, tmpdir string) (string, error) {
+	f, err := os.CreateTemp(tmpdir, "checksum")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := store.Pull(ctx, name, f.Name(), logger); err != nil {
+		return "", fmt.Errorf("failed to pull checksum file %s from %s: %v", name, store.URL(), err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksum file %s: %v", name, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file %s in %s", name, store.URL())
+	}
+	return strings.ToLower(fields[0]), nil
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/databacker/mysql-backup/pkg/storage/credentials"
+	"github.com/databacker/mysql-backup/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)
+	const (
+		newest = "db_backup_2021-01-01T00:00:00Z.gz"
+		middle = "db_backup_2020-12-31T00:00:00Z.gz"
+		oldest = "db_backup_2020-12-29T00:00:00Z.gz"
+		extra  = "db_backup_2020-12-30T00:00:00Z.gz"
+		// sha256 of "a"
+		sumA = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+	)
+	tests := []struct {
+		name      string
+		source    map[string]string
+		dest      map[string]string
+		delete    bool
+		retention string
+		expected  map[string]string
+		err       string
+	}{
+		{"empty destination", map[string]string{newest: "a", middle: "bb"}, nil, false, "", map[string]string{newest: "a", middle: "bb"}, ""},
+		{"missing only", map[string]string{newest: "a", middle: "bb"}, map[string]string{middle: "bb", extra: "x"}, false, "", map[string]string{newest: "a", middle: "bb", extra: "x"}, ""},
+		{"size differs", map[string]string{newest: "a", middle: "bb"}, map[string]string{middle: "b"}, false, "", map[string]string{newest: "a", middle: "bb"}, ""},
+		{"checksum differs", map[string]string{newest: "a", newest + ".sha256": sumA + "  " + newest}, map[string]string{newest: "b", newest + ".sha256": "2222  " + newest}, false, "", map[string]string{newest: "a", newest + ".sha256": sumA + "  " + newest}, ""},
+		{"checksum matches", map[string]string{newest: "a", newest + ".sha256": sumA + "  " + newest}, map[string]string{newest: "b", newest + ".sha256": sumA + "  " + newest}, false, "", map[string]string{newest: "b", newest + ".sha256": sumA + "  " + newest}, ""},
+		{"checksum file missing", map[string]string{newest: "a", newest + ".sha256": sumA + "  " + newest}, map[string]string{newest: "a"}, false, "", map[string]string{newest: "a", newest + ".sha256": sumA + "  " + newest}, ""},
+		{"checksum wrong in source", map[string]string{newest: "a", newest + ".sha256": "1111  " + newest}, nil, false, "", nil, "checksum mismatch: sha256 of " + newest + " in source is " + sumA + ", expected 1111"},
+		{"delete extra", map[string]string{newest: "a", middle: "bb"}, map[string]string{middle: "bb", extra: "x", "other": "y"}, true, "", map[string]string{newest: "a", middle: "bb"}, ""},
+		{"retention", map[string]string{newest: "a", middle: "bb", oldest: "ccc"}, map[string]string{extra: "x"}, false, "2d", map[string]string{newest: "a", middle: "bb"}, ""},
+		{"retention count", map[string]string{newest: "a", middle: "bb", oldest: "ccc"}, nil, false, "1c", map[string]string{newest: "a"}, ""},
+		{"invalid retention", map[string]string{newest: "a"}, nil, false, "100x", nil, "invalid retention string: 100x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logger := log.New()
+			logger.Out = io.Discard
+
+			sourceDir, destDir, otherDir := t.TempDir(), t.TempDir(), t.TempDir()
+			writeFiles(t, sourceDir, tt.source)
+			writeFiles(t, destDir, tt.dest)
+			writeFiles(t, otherDir, tt.dest)
+			source := fileStore(t, sourceDir)
+			dest := fileStore(t, destDir)
+			other := fileStore(t, otherDir)
+
+			executor := Executor{Logger: logger}
+			err := executor.Sync(ctx, SyncOptions{From: source, To: []storage.Storage{dest, other}, Delete: tt.delete, Retention: tt.retention, Now: now})
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, readFiles(t, destDir))
+			assert.Equal(t, tt.expected, readFiles(t, otherDir))
+		})
+	}
+}
+
+// pushRecorder a target that stores checksums itself and records what each push carries in its context
+type pushRecorder struct {
+	checksumStorage
+	metadata    map[string]map[string]string
+	retainUntil map[string]time.Time
+}
+
+func (p *pushRecorder) Push(ctx context.Context, target, source string, logger *log.Entry) (int64, error) {
+	p.metadata[target] = util.ObjectMetadataFromContext(ctx)
+	p.retainUntil[target] = util.RetainUntilFromContext(ctx)
+	return p.checksumStorage.Push(ctx, target, source, logger)
+}
+
+func TestSyncChecksumMetadata(t *testing.T) {
+	const (
+		filename = "db_backup_2021-01-01T00:00:00Z.gz"
+		sumA     = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+	)
+	ctx := context.Background()
+	logger := log.New()
+	logger.Out = io.Discard
+
+	sourceDir, destDir, recordDir := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{filename: "a"})
+	source := checksumStorage{Storage: fileStore(t, sourceDir), sums: map[string]string{"sha256": sumA}}
+	dest := fileStore(t, destDir)
+	recorder := &pushRecorder{
+		checksumStorage: checksumStorage{Storage: fileStore(t, recordDir)},
+		metadata:        map[string]map[string]string{},
+		retainUntil:     map[string]time.Time{},
+	}
+
+	executor := Executor{Logger: logger}
+	err := executor.Sync(ctx, SyncOptions{From: source, To: []storage.Storage{dest, recorder}, Retention: "2d", Now: time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	// the checksums that the source keeps in metadata arrive as checksum files on a target that does not
+	assert.Equal(t, map[string]string{filename: "a", filename + ".sha256": sumA + "  " + filename + "\n"}, readFiles(t, destDir))
+	// and as metadata on one that does, with the backup kept for the retention from when it was made
+	assert.Equal(t, map[string]string{filename: "a"}, readFiles(t, recordDir))
+	assert.Equal(t, map[string]string{util.MetadataChecksumPrefix + "sha256": sumA}, recorder.metadata[filename])
+	assert.Equal(t, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), recorder.retainUntil[filename])
+
+	// a corrupted backup is not copied
+	source.sums = map[string]string{"sha256": "1111"}
+	emptyDir := t.TempDir()
+	err = executor.Sync(ctx, SyncOptions{From: source, To: []storage.Storage{fileStore(t, emptyDir)}})
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Empty(t, readFiles(t, emptyDir))
+}
+
+func fileStore(t *testing.T, dir string) storage.Storage {
+	store, err := storage.ParseURL(fmt.Sprintf("file://%s", dir), credentials.Creds{})
+	require.NoError(t, err)
+	return store
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
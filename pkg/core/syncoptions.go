@@ -0,0 +1,23 @@
+package core
+
+import (
+	"time"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/storage"
+	"github.com/google/uuid"
+)
+
+type SyncOptions struct {
+	From storage.Storage
+	To   []storage.Storage
+	// Delete remove files from the destinations that are not in the source
+	Delete bool
+	// Retention only copy backups within the retention policy, and prune the destinations with it
+	Retention string
+	Now       time.Time
+	Run       uuid.UUID
+	// UploadRateLimit, DownloadRateLimit the most bytes per second to copy to the destinations and from the source
+	UploadRateLimit   ratelimit.Schedule
+	DownloadRateLimit ratelimit.Schedule
+}
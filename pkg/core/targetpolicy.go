@@ -0,0 +1,40 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/databacker/mysql-backup/pkg/storage"
+)
+
+// TargetPolicy what counts as a successful backup when there are several targets
+type TargetPolicy struct {
+	// Required URLs of the targets that must receive the backup; if the upload to any of them fails, the backup fails
+	Required []string
+	// MinSuccess the minimum number of targets that must receive the backup
+	MinSuccess int
+}
+
+// IsSet whether there is a policy at all; without one, every upload must succeed,
+// unless DumpOptions.ContinueOnUploadFailure is set.
+func (p TargetPolicy) IsSet() bool {
+	return len(p.Required) > 0 || p.MinSuccess > 0
+}
+
+// validate check that the policy can be met by the targets
+func (p TargetPolicy) validate(targets []storage.Storage) error {
+	for _, r := range p.Required {
+		if !slices.ContainsFunc(targets, func(t storage.Storage) bool { return t.URL() == r }) {
+			return fmt.Errorf("required target %s is not one of the targets", r)
+		}
+	}
+	if p.MinSuccess < 0 || p.MinSuccess > len(targets) {
+		return fmt.Errorf("minimum successful targets %d must be between 0 and the number of targets, %d", p.MinSuccess, len(targets))
+	}
+	return nil
+}
+
+// required whether the upload to the target must succeed
+func (p TargetPolicy) required(target string) bool {
+	return slices.Contains(p.Required, target)
+}
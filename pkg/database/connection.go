@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	mysql "github.com/go-sql-driver/mysql"
+
+	"github.com/databacker/mysql-backup/pkg/secret"
 )
 
 type Connection struct {
@@ -20,13 +22,18 @@ type Connection struct {
 	sql *sql.DB
 }
 
-// MySQL returns a MySQL connection for the Connection.
+// MySQL returns a MySQL connection for the Connection. The password may be a reference to a secret,
+// which is resolved when first connecting; see package secret.
 func (c *Connection) MySQL() (*sql.DB, error) {
 	if c.sql == nil {
+		pass, err := secret.Resolve(c.Pass)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get database password: %w", err)
+		}
 
 		config := mysql.NewConfig()
 		config.User = c.User
-		config.Passwd = c.Pass
+		config.Passwd = pass
 		if strings.HasPrefix(c.Host, "/") {
 			config.Net = "unix"
 			config.Addr = c.Host
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/databacker/mysql-backup/pkg/database/mysql"
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
 )
 
 type DumpOpts struct {
@@ -20,6 +21,8 @@ type DumpOpts struct {
 	PostDumpDelay time.Duration
 	Parallelism   int
 	IgnoreTables  []string
+	// RateLimit limits how fast the dump is read from the database, across all of the writers; nil for no limit
+	RateLimit *ratelimit.Limiter
 }
 
 func Dump(ctx context.Context, dbconn *Connection, opts DumpOpts, writers []DumpWriter) error {
@@ -53,7 +56,7 @@ func Dump(ctx context.Context, dbconn *Connection, opts DumpOpts, writers []Dump
 			defer func() { <-sem }()
 			for _, schema := range writer.Schemas {
 				dumper := &mysql.Data{
-					Out:                 writer.Writer,
+					Out:                 opts.RateLimit.Writer(ctx, writer.Writer),
 					Connection:          db,
 					Schema:              schema,
 					Host:                dbconn.Host,
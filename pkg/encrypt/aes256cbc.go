@@ -146,6 +146,10 @@ func (s *AES256CBC) Description() string {
 	return "AES-256-CBC output format with IV prepended; should work with `openssl enc -d -aes-256-cbc -K <key-in-hex> -iv auto`."
 }
 
+func (s *AES256CBC) KeyID() string {
+	return keyID(s.key)
+}
+
 func (s *AES256CBC) Decrypt(out io.Writer) (io.WriteCloser, error) {
 	if len(s.key) != 32 {
 		return nil, fmt.Errorf("key must be 32 bytes")
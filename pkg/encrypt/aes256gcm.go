@@ -0,0 +1,205 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// aesGCMMagic the start of the header of the format
+	aesGCMMagic = "DBKAESGCM"
+	// aesGCMVersion the version of the format, following the magic
+	aesGCMVersion  = 1
+	aesGCMKeySize  = 32
+	aesGCMSaltSize = 32
+	aesGCMInfo     = "mysql-backup aes256-gcm stream v1"
+
+	// the ways that the key of a stream is derived, recorded in the header after the version
+	aesGCMKDFNone     = 0
+	aesGCMKDFArgon2id = 1
+
+	// aesGCMPassphrasePrefix the start of a key that is a passphrase, as for age
+	aesGCMPassphrasePrefix = agePassphrasePrefix
+
+	// the Argon2id parameters for new streams, the second recommendation of RFC 9106
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	// the most that a header may ask of Argon2id, so that a crafted file cannot exhaust memory or time
+	argon2MaxTime   = 16
+	argon2MaxMemory = 1024 * 1024
+	// argon2ParamsSize time and memory, as big-endian uint32, and threads
+	argon2ParamsSize = 4 + 4 + 1
+
+	aesGCMHeaderSize       = len(aesGCMMagic) + 1 + 1 + aesGCMSaltSize
+	aesGCMArgon2HeaderSize = aesGCMHeaderSize + argon2ParamsSize
+)
+
+var _ Encryptor = &AES256GCM{}
+
+// AES256GCM encrypts with AES-256-GCM in chunks, sealed with the STREAM construction as Chacha20Poly1305 does,
+// so that any change to the file fails to decrypt, in memory for one chunk only. The header is the magic,
+// the version, the KDF and a random salt, followed for Argon2id by its parameters. The key is either 32 bytes,
+// from which the key of each stream is derived with HKDF and the salt, or passphrase:<passphrase>, from which
+// it is derived with Argon2id and the salt.
+type AES256GCM struct {
+	key        []byte
+	passphrase []byte
+}
+
+// NewAES256GCM create an AES-256-GCM encryptor for a 32-byte key, or passphrase:<passphrase>
+func NewAES256GCM(key []byte) (*AES256GCM, error) {
+	if passphrase, ok := strings.CutPrefix(string(key), aesGCMPassphrasePrefix); ok {
+		if passphrase == "" {
+			return nil, fmt.Errorf("passphrase cannot be empty")
+		}
+		return &AES256GCM{passphrase: []byte(passphrase)}, nil
+	}
+	if len(key) != aesGCMKeySize {
+		return nil, fmt.Errorf("key length must be %d bytes for AES-256-GCM, or a passphrase starting with %s, not %d bytes", aesGCMKeySize, aesGCMPassphrasePrefix, len(key))
+	}
+	return &AES256GCM{key: key}, nil
+}
+
+func (s *AES256GCM) Name() string {
+	return string(AlgoAES256GCM)
+}
+
+func (s *AES256GCM) Description() string {
+	return "AES-256-GCM authenticated encryption in chunks, with a key or an Argon2id passphrase."
+}
+
+// KeyID from the key, or empty for a passphrase, which a hash would make easier to guess
+func (s *AES256GCM) KeyID() string {
+	if s.passphrase != nil {
+		return ""
+	}
+	return keyID(s.key)
+}
+
+func (s *AES256GCM) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, aesGCMSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	header := append([]byte(aesGCMMagic), aesGCMVersion)
+	if s.passphrase != nil {
+		header = append(header, aesGCMKDFArgon2id)
+		header = append(header, salt...)
+		header = binary.BigEndian.AppendUint32(header, argon2Time)
+		header = binary.BigEndian.AppendUint32(header, argon2Memory)
+		header = append(header, argon2Threads)
+	} else {
+		header = append(header, aesGCMKDFNone)
+		header = append(header, salt...)
+	}
+	aead, err := s.streamAEAD(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return newStreamEncryptWriter(aead, out), nil
+}
+
+func (s *AES256GCM) Decrypt(out io.Writer) (io.WriteCloser, error) {
+	return &aesGCMDecryptWriter{encryptor: s, out: out}, nil
+}
+
+// streamAEAD the AEAD for the stream with the header, with a key of its own, derived with its salt and KDF
+func (s *AES256GCM) streamAEAD(header []byte) (cipher.AEAD, error) {
+	kdf := header[len(aesGCMMagic)+1]
+	salt := header[len(aesGCMMagic)+2 : aesGCMHeaderSize]
+	var (
+		streamKey []byte
+		err       error
+	)
+	switch {
+	case kdf == aesGCMKDFNone && s.passphrase != nil:
+		return nil, fmt.Errorf("encrypted with a key, not a passphrase")
+	case kdf == aesGCMKDFArgon2id && s.passphrase == nil:
+		return nil, fmt.Errorf("encrypted with a passphrase, not a key")
+	case kdf == aesGCMKDFNone:
+		if streamKey, err = hkdf.Key(sha256.New, s.key, salt, aesGCMInfo, aesGCMKeySize); err != nil {
+			return nil, fmt.Errorf("failed to derive stream key: %w", err)
+		}
+	case kdf == aesGCMKDFArgon2id:
+		params := header[aesGCMHeaderSize:aesGCMArgon2HeaderSize]
+		time, memory, threads := binary.BigEndian.Uint32(params), binary.BigEndian.Uint32(params[4:]), params[8]
+		if time == 0 || time > argon2MaxTime || memory == 0 || memory > argon2MaxMemory || threads == 0 {
+			return nil, fmt.Errorf("unsupported Argon2id parameters: time %d, memory %d KiB, threads %d", time, memory, threads)
+		}
+		streamKey = argon2.IDKey(s.passphrase, salt, time, memory, threads, aesGCMKeySize)
+	default:
+		return nil, fmt.Errorf("unsupported aes256-gcm key derivation %d", kdf)
+	}
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// aesGCMDecryptWriter read the header, then decrypt the chunks as they come
+type aesGCMDecryptWriter struct {
+	encryptor *AES256GCM
+	out       io.Writer
+	header    []byte
+	stream    io.WriteCloser
+	closed    bool
+}
+
+func (w *aesGCMDecryptWriter) Write(p []byte) (int, error) {
+	if w.stream != nil {
+		return w.stream.Write(p)
+	}
+	w.header = append(w.header, p...)
+	size := aesGCMHeaderSize
+	if len(w.header) > len(aesGCMMagic)+1 && w.header[len(aesGCMMagic)+1] == aesGCMKDFArgon2id {
+		size = aesGCMArgon2HeaderSize
+	}
+	if len(w.header) < size {
+		return len(p), nil
+	}
+	if string(w.header[:len(aesGCMMagic)]) != aesGCMMagic {
+		return 0, fmt.Errorf("not encrypted with aes256-gcm")
+	}
+	if version := w.header[len(aesGCMMagic)]; version != aesGCMVersion {
+		return 0, fmt.Errorf("unsupported aes256-gcm format version %d", version)
+	}
+	aead, err := w.encryptor.streamAEAD(w.header[:size])
+	if err != nil {
+		return 0, err
+	}
+	w.stream = newStreamDecryptWriter(aead, w.out)
+	if _, err := w.stream.Write(w.header[size:]); err != nil {
+		return 0, err
+	}
+	w.header = nil
+	return len(p), nil
+}
+
+func (w *aesGCMDecryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.stream == nil {
+		return fmt.Errorf("missing aes256-gcm header, the encrypted file may be truncated")
+	}
+	return w.stream.Close()
+}
@@ -0,0 +1,114 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gcmOverhead the size of the tag that GCM appends to each chunk
+const gcmOverhead = 16
+
+func TestAES256GCM(t *testing.T) {
+	key, err := generateRandomKey(aesGCMKeySize)
+	require.NoError(t, err)
+	keyEnc, err := NewAES256GCM(key)
+	require.NoError(t, err)
+	passEnc, err := NewAES256GCM([]byte("passphrase:correct horse battery staple"))
+	require.NoError(t, err)
+
+	encrypt := func(t *testing.T, enc Encryptor, data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := enc.Encrypt(&buf)
+		require.NoError(t, err)
+		require.NoError(t, writeAll(w, data, 1000))
+		return buf.Bytes()
+	}
+	decrypt := func(enc Encryptor, ciphertext []byte, size int) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := enc.Decrypt(&buf)
+		if err != nil {
+			return nil, err
+		}
+		err = writeAll(w, ciphertext, size)
+		return buf.Bytes(), err
+	}
+
+	for name, enc := range map[string]Encryptor{"key": keyEnc, "passphrase": passEnc} {
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{0, 1, streamChunkSize, 2*streamChunkSize + 1} {
+				data := make([]byte, size)
+				_, err := rand.Read(data)
+				require.NoError(t, err)
+				ciphertext := encrypt(t, enc, data)
+				// in one piece, and in pieces smaller than the header
+				for _, piece := range []int{len(ciphertext), 7} {
+					decrypted, err := decrypt(enc, ciphertext, piece)
+					require.NoError(t, err, "size %d", size)
+					assert.True(t, bytes.Equal(data, decrypted), "size %d", size)
+				}
+			}
+		})
+	}
+	assert.Equal(t, keyID(key), keyEnc.KeyID())
+	assert.Equal(t, "", passEnc.KeyID())
+
+	data := bytes.Repeat([]byte("abcdefgh"), streamChunkSize)
+	ciphertext := encrypt(t, keyEnc, data)
+	chunk := streamChunkSize + gcmOverhead
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := decrypt(keyEnc, ciphertext[:aesGCMHeaderSize+2*chunk], len(ciphertext))
+		assert.ErrorContains(t, err, "truncated")
+		_, err = decrypt(keyEnc, ciphertext[:aesGCMHeaderSize-1], len(ciphertext))
+		assert.ErrorContains(t, err, "missing aes256-gcm header")
+	})
+	t.Run("modified", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[aesGCMHeaderSize+chunk+10] ^= 1
+		_, err := decrypt(keyEnc, modified, len(modified))
+		assert.ErrorContains(t, err, "decryption failed at chunk 1")
+	})
+	t.Run("reordered", func(t *testing.T) {
+		reordered := bytes.Clone(ciphertext[:aesGCMHeaderSize])
+		reordered = append(reordered, ciphertext[aesGCMHeaderSize+chunk:aesGCMHeaderSize+2*chunk]...)
+		reordered = append(reordered, ciphertext[aesGCMHeaderSize:aesGCMHeaderSize+chunk]...)
+		reordered = append(reordered, ciphertext[aesGCMHeaderSize+2*chunk:]...)
+		_, err := decrypt(keyEnc, reordered, len(reordered))
+		assert.ErrorContains(t, err, "decryption failed at chunk 0")
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := generateRandomKey(aesGCMKeySize)
+		require.NoError(t, err)
+		otherEnc, err := NewAES256GCM(other)
+		require.NoError(t, err)
+		_, err = decrypt(otherEnc, ciphertext, len(ciphertext))
+		assert.ErrorContains(t, err, "decryption failed at chunk 0")
+	})
+	t.Run("key and passphrase", func(t *testing.T) {
+		_, err := decrypt(passEnc, ciphertext, len(ciphertext))
+		assert.ErrorContains(t, err, "encrypted with a key, not a passphrase")
+		_, err = decrypt(keyEnc, encrypt(t, passEnc, data), len(ciphertext))
+		assert.ErrorContains(t, err, "encrypted with a passphrase, not a key")
+	})
+	t.Run("excessive Argon2id parameters", func(t *testing.T) {
+		crafted := bytes.Clone(encrypt(t, passEnc, []byte("abc")))
+		binary.BigEndian.PutUint32(crafted[aesGCMHeaderSize+4:], argon2MaxMemory+1)
+		_, err := decrypt(passEnc, crafted, len(crafted))
+		assert.ErrorContains(t, err, "unsupported Argon2id parameters")
+	})
+	t.Run("not aes256-gcm", func(t *testing.T) {
+		_, err := decrypt(keyEnc, bytes.Repeat([]byte("x"), aesGCMHeaderSize), aesGCMHeaderSize)
+		assert.ErrorContains(t, err, "not encrypted with aes256-gcm")
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := NewAES256GCM([]byte("short"))
+		assert.ErrorContains(t, err, "key length must be 32 bytes")
+		_, err = NewAES256GCM([]byte("passphrase:"))
+		assert.ErrorContains(t, err, "passphrase cannot be empty")
+	})
+}
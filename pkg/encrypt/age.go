@@ -1,23 +1,144 @@
 package encrypt
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ageIdentityPrefix the start of an age identity, as opposed to a recipient
+	ageIdentityPrefix = "AGE-SECRET-KEY-"
+	// agePassphrasePrefix the start of a passphrase, for which age derives the key with scrypt
+	agePassphrasePrefix = "passphrase:"
 )
 
 var _ Encryptor = &AgeChacha20Poly1305{}
 
+// AgeChacha20Poly1305 age, encrypting to any number of recipients, each of whom can decrypt independently,
+// and decrypting with any of the identities
 type AgeChacha20Poly1305 struct {
-	recipientPubKey string
-	identityKey     string
+	recipients []age.Recipient
+	identities []age.Identity
+	keyID      string
+}
+
+// NewAgeChacha20Poly1305 create an age encryptor from a recipients file or an identity file, one per line,
+// blank lines and lines starting with # ignored. Each line is one of:
+//
+//   - a recipient, to encrypt to: an X25519 public key, age1...; or an SSH public key, ssh-ed25519 or ssh-rsa,
+//     as in authorized_keys
+//   - an identity, to decrypt with, and to encrypt to its recipient: an X25519 private key, AGE-SECRET-KEY-1...;
+//     or an unencrypted SSH private key, ssh-ed25519 or ssh-rsa, in PEM format, over several lines
+//   - passphrase:<passphrase>, to encrypt and decrypt with a passphrase, which cannot be combined with anything
+//     else, as age does not allow it
+func NewAgeChacha20Poly1305(key []byte) (*AgeChacha20Poly1305, error) {
+	a := &AgeChacha20Poly1305{}
+	var (
+		recipients []string
+		passphrase bool
+		lines      = strings.Split(string(key), "\n")
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		lineNum := i + 1
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, agePassphrasePrefix):
+			pass := strings.TrimPrefix(line, agePassphrasePrefix)
+			recipient, err := age.NewScryptRecipient(pass)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age passphrase on line %d: %w", lineNum, err)
+			}
+			identity, err := age.NewScryptIdentity(pass)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age passphrase on line %d: %w", lineNum, err)
+			}
+			a.recipients = append(a.recipients, recipient)
+			a.identities = append(a.identities, identity)
+			passphrase = true
+		case strings.HasPrefix(line, ageIdentityPrefix):
+			identity, err := age.ParseX25519Identity(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age identity on line %d: %w", lineNum, err)
+			}
+			a.recipients = append(a.recipients, identity.Recipient())
+			a.identities = append(a.identities, identity)
+		case strings.HasPrefix(line, "age1"):
+			recipient, err := age.ParseX25519Recipient(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient on line %d: %w", lineNum, err)
+			}
+			a.recipients = append(a.recipients, recipient)
+			recipients = append(recipients, line)
+		case strings.HasPrefix(line, "ssh-"):
+			recipient, err := agessh.ParseRecipient(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SSH recipient on line %d: %w", lineNum, err)
+			}
+			a.recipients = append(a.recipients, recipient)
+			// without the comment, which does not change the key
+			recipients = append(recipients, strings.Join(strings.Fields(line)[:2], " "))
+		case strings.HasPrefix(line, "-----BEGIN "):
+			// the PEM block runs to its END line
+			end := i
+			for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), "-----END ") {
+				end++
+			}
+			if end == len(lines) {
+				return nil, fmt.Errorf("SSH private key on line %d has no end", lineNum)
+			}
+			identity, recipient, err := parseSSHIdentity([]byte(strings.Join(lines[i:end+1], "\n")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid SSH private key on line %d: %w", lineNum, err)
+			}
+			a.recipients = append(a.recipients, recipient)
+			a.identities = append(a.identities, identity)
+			i = end
+		default:
+			return nil, fmt.Errorf("unknown age recipient or identity on line %d", lineNum)
+		}
+	}
+	if len(a.recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients or identities")
+	}
+	if passphrase && len(a.recipients) > 1 {
+		return nil, fmt.Errorf("an age passphrase cannot be combined with recipients, identities or other passphrases")
+	}
+	// only a set of recipients identifies the key of a file: an identity is just one of the possibly many
+	// recipients of the file, and a passphrase would be easier to guess with a hash of it
+	if len(a.identities) == 0 {
+		slices.Sort(recipients)
+		a.keyID = keyID([]byte(strings.Join(recipients, "\n")))
+	}
+	return a, nil
 }
 
-func NewAgeChacha20Poly1305(recipientPubKey []byte) (*AgeChacha20Poly1305, error) {
-	key := string(recipientPubKey)
-	return &AgeChacha20Poly1305{recipientPubKey: key}, nil
+// parseSSHIdentity parse an SSH private key in PEM format, returning the identity and its recipient
+func parseSSHIdentity(pemBytes []byte) (age.Identity, age.Recipient, error) {
+	identity, err := agessh.ParseIdentity(pemBytes)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, nil, fmt.Errorf("encrypted SSH private keys are not supported")
+		}
+		return nil, nil, err
+	}
+	switch i := identity.(type) {
+	case *agessh.Ed25519Identity:
+		return i, i.Recipient(), nil
+	case *agessh.RSAIdentity:
+		return i, i.Recipient(), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported SSH private key type %T", identity)
+	}
 }
 
 func (a *AgeChacha20Poly1305) Name() string {
@@ -28,59 +149,40 @@ func (a *AgeChacha20Poly1305) Description() string {
 	return "age format with encryption using chacha20 and poly1305."
 }
 
+// KeyID from the recipients, or empty if created with identities or a passphrase
+func (a *AgeChacha20Poly1305) KeyID() string {
+	return a.keyID
+}
+
 func (a *AgeChacha20Poly1305) Decrypt(out io.Writer) (io.WriteCloser, error) {
-	identity, err := age.ParseX25519Identity(a.identityKey)
-	if err != nil {
-		return nil, fmt.Errorf("invalid age identity: %w", err)
+	if len(a.identities) == 0 {
+		return nil, fmt.Errorf("age decryption needs an identity, i.e. a private key or passphrase, not only recipients")
 	}
+	identities := a.identities
 
-	// Buffer encrypted input until Close()
-	buf := &bytes.Buffer{}
-	return &ageDecryptWriter{
-		identity: identity,
-		buf:      buf,
-		out:      out,
-	}, nil
+	// age decrypts from a reader, so feed it what is written through a pipe, decrypting as it goes
+	return newPipeDecryptWriter(func(in io.Reader) error {
+		return decryptAge(in, identities, out)
+	}), nil
 }
 
-func (a *AgeChacha20Poly1305) Encrypt(out io.Writer) (io.WriteCloser, error) {
-	// Parse the recipient's X25519 public key
-	recipient, err := age.ParseX25519Recipient(a.recipientPubKey)
+func decryptAge(in io.Reader, identities []age.Identity, out io.Writer) error {
+	reader, err := age.Decrypt(in, identities...)
 	if err != nil {
-		return nil, fmt.Errorf("invalid recipient public key: %w", err)
+		return fmt.Errorf("age decryption failed: %w", err)
 	}
-
-	// Create an age Writer that encrypts to the recipient
-	ageWriter, err := age.Encrypt(out, recipient)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize age writer: %w", err)
+	if _, err := io.Copy(out, reader); err != nil {
+		return fmt.Errorf("age decryption failed: %w", err)
 	}
-
-	return ageWriter, nil // already an io.WriteCloser
-}
-
-type ageDecryptWriter struct {
-	identity *age.X25519Identity
-	buf      *bytes.Buffer
-	out      io.Writer
-	closed   bool
+	return nil
 }
 
-func (w *ageDecryptWriter) Write(p []byte) (int, error) {
-	return w.buf.Write(p)
-}
-
-func (w *ageDecryptWriter) Close() error {
-	if w.closed {
-		return nil
-	}
-	w.closed = true
-
-	reader, err := age.Decrypt(w.buf, w.identity)
+func (a *AgeChacha20Poly1305) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	// Create an age Writer that encrypts to all of the recipients
+	ageWriter, err := age.Encrypt(out, a.recipients...)
 	if err != nil {
-		return fmt.Errorf("age decryption failed: %w", err)
+		return nil, fmt.Errorf("failed to initialize age writer: %w", err)
 	}
 
-	_, err = io.Copy(w.out, reader)
-	return err
+	return ageWriter, nil // already an io.WriteCloser
 }
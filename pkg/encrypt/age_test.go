@@ -0,0 +1,138 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyPair an SSH private key in PEM format, optionally encrypted, and its public key as in authorized_keys
+func sshKeyPair(t *testing.T, key any, passphrase string) (private, public string) {
+	var (
+		block *pem.Block
+		err   error
+	)
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "test")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "test", []byte(passphrase))
+	}
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(block)), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " oncall@example.com"
+}
+
+func TestAgeRecipients(t *testing.T) {
+	x25519a, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	x25519b, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edPrivate, edPublic := sshKeyPair(t, edKey, "")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPrivate, rsaPublic := sshKeyPair(t, rsaKey, "")
+	encryptedPrivate, _ := sshKeyPair(t, edKey, "secret")
+
+	recipients := strings.Join([]string{
+		"# on-call engineers",
+		x25519a.Recipient().String(),
+		edPublic,
+		rsaPublic,
+		"",
+		"# offline escrow key",
+		x25519b.Recipient().String(),
+	}, "\n")
+	enc, err := NewAgeChacha20Poly1305([]byte(recipients))
+	require.NoError(t, err)
+	assert.NotEmpty(t, enc.KeyID())
+
+	// the key ID does not depend on the order of the recipients, or the comments
+	reordered, err := NewAgeChacha20Poly1305([]byte(strings.Join([]string{x25519b.Recipient().String(), strings.TrimSuffix(rsaPublic, " oncall@example.com"), edPublic, x25519a.Recipient().String()}, "\n")))
+	require.NoError(t, err)
+	assert.Equal(t, enc.KeyID(), reordered.KeyID())
+
+	data := bytes.Repeat([]byte("abcdefgh"), 10000)
+	var encrypted bytes.Buffer
+	w, err := enc.Encrypt(&encrypted)
+	require.NoError(t, err)
+	require.NoError(t, writeAll(w, data, 1000))
+
+	// only recipients cannot decrypt
+	_, err = enc.Decrypt(&bytes.Buffer{})
+	assert.ErrorContains(t, err, "needs an identity")
+
+	// each identity decrypts independently
+	for name, identity := range map[string]string{
+		"x25519":      x25519a.String(),
+		"escrow":      "# escrow\n" + x25519b.String() + "\n",
+		"ssh-ed25519": edPrivate,
+		"ssh-rsa":     rsaPrivate,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dec, err := NewAgeChacha20Poly1305([]byte(identity))
+			require.NoError(t, err)
+			assert.Empty(t, dec.KeyID())
+			var decrypted bytes.Buffer
+			w, err := dec.Decrypt(&decrypted)
+			require.NoError(t, err)
+			require.NoError(t, writeAll(w, encrypted.Bytes(), 1000))
+			assert.True(t, bytes.Equal(data, decrypted.Bytes()))
+		})
+	}
+
+	t.Run("other identity", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		dec, err := NewAgeChacha20Poly1305([]byte(other.String()))
+		require.NoError(t, err)
+		w, err := dec.Decrypt(&bytes.Buffer{})
+		require.NoError(t, err)
+		assert.Error(t, writeAll(w, encrypted.Bytes(), 1000))
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		enc, err := NewAgeChacha20Poly1305([]byte("passphrase:correct horse battery staple\n"))
+		require.NoError(t, err)
+		assert.Empty(t, enc.KeyID())
+		var encrypted, decrypted bytes.Buffer
+		w, err := enc.Encrypt(&encrypted)
+		require.NoError(t, err)
+		require.NoError(t, writeAll(w, data, 1000))
+		w, err = enc.Decrypt(&decrypted)
+		require.NoError(t, err)
+		require.NoError(t, writeAll(w, encrypted.Bytes(), 1000))
+		assert.True(t, bytes.Equal(data, decrypted.Bytes()))
+	})
+
+	tests := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{"empty", "# nothing\n\n", "no age recipients or identities"},
+		{"unknown", x25519a.Recipient().String() + "\nnot-a-key", "unknown age recipient or identity on line 2"},
+		{"invalid recipient", "age1invalid", "invalid age recipient on line 1"},
+		{"invalid SSH recipient", "ssh-ed25519 invalid", "invalid SSH recipient on line 1"},
+		{"passphrase and recipient", "passphrase:secret\n" + x25519a.Recipient().String(), "cannot be combined"},
+		{"encrypted SSH key", encryptedPrivate, "encrypted SSH private keys are not supported"},
+		{"unterminated SSH key", strings.Split(edPrivate, "-----END")[0], "has no end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAgeChacha20Poly1305([]byte(tt.key))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"fmt"
+	"io"
+)
+
+// berHeader the identifier and length of a BER element
+type berHeader struct {
+	// tag the identifier octet, of the class, whether constructed and the tag number
+	tag byte
+	// length of the contents, or -1 for indefinite length, which ends with an end-of-contents element
+	length int64
+	// raw the encoded header
+	raw []byte
+}
+
+func (h berHeader) constructed() bool {
+	return h.tag&0x20 != 0
+}
+
+// endOfContents whether the element is the end-of-contents marker of an indefinite length element
+func (h berHeader) endOfContents() bool {
+	return h.tag == 0 && h.length == 0
+}
+
+// berReader read BER elements from a stream, so that large elements can be processed without holding them whole.
+// Only tag numbers up to 30, i.e. in one octet, are supported, which is all that CMS uses.
+type berReader struct {
+	r *bufio.Reader
+}
+
+func newBERReader(in io.Reader) *berReader {
+	return &berReader{r: bufio.NewReader(in)}
+}
+
+func (b *berReader) readHeader() (berHeader, error) {
+	var h berHeader
+	tag, err := b.r.ReadByte()
+	if err != nil {
+		return h, err
+	}
+	if tag&0x1f == 0x1f {
+		return h, fmt.Errorf("unsupported BER tag number in identifier 0x%x", tag)
+	}
+	h.tag = tag
+	h.raw = append(h.raw, tag)
+	first, err := b.r.ReadByte()
+	if err != nil {
+		return h, unexpectedEOF(err)
+	}
+	h.raw = append(h.raw, first)
+	switch {
+	case first < 0x80:
+		h.length = int64(first)
+	case first == 0x80:
+		if !h.constructed() {
+			return h, fmt.Errorf("indefinite length for primitive BER element")
+		}
+		h.length = -1
+	default:
+		n := int(first & 0x7f)
+		if n > 7 {
+			return h, fmt.Errorf("BER length of %d octets is too long", n)
+		}
+		for range n {
+			c, err := b.r.ReadByte()
+			if err != nil {
+				return h, unexpectedEOF(err)
+			}
+			h.raw = append(h.raw, c)
+			h.length = h.length<<8 | int64(c)
+		}
+	}
+	return h, nil
+}
+
+// maxBERElementSize the largest element that readElement holds in memory, which is far more than any of the small
+// elements that it reads, e.g. recipient infos and algorithm identifiers, need
+const maxBERElementSize = 1 << 20
+
+// readElement read a whole element, which must be small enough to hold in memory, returning its encoding
+func (b *berReader) readElement() ([]byte, berHeader, error) {
+	h, err := b.readHeader()
+	if err != nil {
+		return nil, h, err
+	}
+	var buf bytes.Buffer
+	err = b.readContents(h, &buf, maxBERElementSize)
+	return buf.Bytes(), h, err
+}
+
+// readContents read the contents of the element with the header, writing its whole encoding to buf, failing if it
+// is more than limit octets
+func (b *berReader) readContents(h berHeader, buf *bytes.Buffer, limit int) error {
+	if buf.Len()+len(h.raw) > limit {
+		return fmt.Errorf("BER element is longer than %d octets", limit)
+	}
+	buf.Write(h.raw)
+	if h.length >= 0 {
+		if h.length > int64(limit-buf.Len()) {
+			return fmt.Errorf("BER element of %d octets is longer than %d octets", h.length, limit)
+		}
+		// in pieces, so that a length that the file lies about takes no more memory than the file has
+		if _, err := io.CopyN(buf, b.r, h.length); err != nil {
+			return unexpectedEOF(err)
+		}
+		return nil
+	}
+	for {
+		ch, err := b.readHeader()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if err := b.readContents(ch, buf, limit); err != nil {
+			return unexpectedEOF(err)
+		}
+		if ch.endOfContents() {
+			return nil
+		}
+	}
+}
+
+// readOctets pass the contents of an OCTET STRING, or an implicitly tagged one, to fn in pieces as they are read.
+// A constructed string is the concatenation of the strings inside of it, at any depth. Returns how many octets of
+// the encoding were read, including the header.
+func (b *berReader) readOctets(h berHeader, fn func([]byte) error) (int64, error) {
+	read := int64(len(h.raw))
+	if !h.constructed() {
+		buf := make([]byte, min(h.length, streamChunkSize))
+		for remaining := h.length; remaining > 0; {
+			n, err := io.ReadFull(b.r, buf[:min(remaining, int64(len(buf)))])
+			if err != nil {
+				return read, unexpectedEOF(err)
+			}
+			if err := fn(buf[:n]); err != nil {
+				return read, err
+			}
+			remaining -= int64(n)
+			read += int64(n)
+		}
+		return read, nil
+	}
+	for h.length < 0 || read-int64(len(h.raw)) < h.length {
+		ch, err := b.readHeader()
+		if err != nil {
+			return read, unexpectedEOF(err)
+		}
+		if ch.endOfContents() {
+			if h.length >= 0 {
+				return read, fmt.Errorf("unexpected end-of-contents in definite length BER element")
+			}
+			return read + int64(len(ch.raw)), nil
+		}
+		n, err := b.readOctets(ch, fn)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	if read-int64(len(h.raw)) != h.length {
+		return read, fmt.Errorf("BER element is longer than its length")
+	}
+	return read, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// expect read the header of a constructed element, which must have the tag
+func (b *berReader) expect(tag byte, name string) error {
+	h, err := b.readHeader()
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, unexpectedEOF(err))
+	}
+	if h.tag != tag {
+		return fmt.Errorf("invalid %s: unexpected tag 0x%x", name, h.tag)
+	}
+	return nil
+}
+
+// readOID read an OBJECT IDENTIFIER element
+func (b *berReader) readOID() (asn1.ObjectIdentifier, error) {
+	raw, _, err := b.readElement()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	var id asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(raw, &id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
@@ -3,15 +3,31 @@ package encrypt
 import (
 	"bytes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"io"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
+const (
+	// chacha20Magic the start of the header of the chunked format. Files without it are in the legacy format,
+	// which starts with a random nonce.
+	chacha20Magic = "DBKCHACHA"
+	// chacha20Version the version of the chunked format, following the magic
+	chacha20Version    = 1
+	chacha20SaltSize   = 32
+	chacha20HeaderSize = len(chacha20Magic) + 1 + chacha20SaltSize
+	chacha20Info       = "mysql-backup chacha20poly1305 stream v1"
+)
+
 var _ Encryptor = &Chacha20Poly1305{}
 
+// Chacha20Poly1305 encrypts in the chunked format: a header of the magic, the version and a random salt,
+// followed by the archive in chunks sealed with the STREAM construction, with a key derived from the key
+// and the salt. It decrypts both that and the legacy format, a nonce followed by the archive sealed at once.
 type Chacha20Poly1305 struct {
 	key []byte
 }
@@ -31,20 +47,18 @@ func (s *Chacha20Poly1305) Description() string {
 	return "Chacha20-Poly1305 encryption."
 }
 
+func (s *Chacha20Poly1305) KeyID() string {
+	return keyID(s.key)
+}
+
 func (s *Chacha20Poly1305) Decrypt(out io.Writer) (io.WriteCloser, error) {
 	if len(s.key) != chacha20poly1305.KeySize {
 		return nil, fmt.Errorf("key must be 32 bytes")
 	}
 
-	aead, err := chacha20poly1305.New(s.key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create chacha20poly1305: %w", err)
-	}
-
 	return &chacha20DecryptWriter{
-		aead: aead,
-		out:  out,
-		buf:  &bytes.Buffer{},
+		key: s.key,
+		out: out,
 	}, nil
 }
 
@@ -53,61 +67,81 @@ func (s *Chacha20Poly1305) Encrypt(out io.Writer) (io.WriteCloser, error) {
 		return nil, fmt.Errorf("key must be 32 bytes")
 	}
 
-	aead, err := chacha20poly1305.New(s.key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create chacha20poly1305: %w", err)
+	salt := make([]byte, chacha20SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
-
-	nonce := make([]byte, chacha20poly1305.NonceSize)
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	aead, err := chacha20StreamAEAD(s.key, salt)
+	if err != nil {
+		return nil, err
 	}
 
-	// Write the nonce first
-	if _, err := out.Write(nonce); err != nil {
-		return nil, fmt.Errorf("failed to write nonce: %w", err)
+	// Write the header first
+	header := append([]byte(chacha20Magic), chacha20Version)
+	header = append(header, salt...)
+	if _, err := out.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
 	}
 
-	return &chacha20EncryptWriter{
-		aead:   aead,
-		nonce:  nonce,
-		out:    out,
-		buffer: &bytes.Buffer{},
-	}, nil
-}
-
-type chacha20EncryptWriter struct {
-	aead   cipher.AEAD
-	nonce  []byte
-	out    io.Writer
-	buffer *bytes.Buffer
-	closed bool
+	return newStreamEncryptWriter(aead, out), nil
 }
 
-func (w *chacha20EncryptWriter) Write(p []byte) (int, error) {
-	return w.buffer.Write(p)
-}
-
-func (w *chacha20EncryptWriter) Close() error {
-	if w.closed {
-		return nil
+// chacha20StreamAEAD the AEAD for a stream, with a key derived from the key and the salt of the stream, so that
+// each stream has its own key, and the nonces of the chunks need not be random
+func chacha20StreamAEAD(key, salt []byte) (cipher.AEAD, error) {
+	streamKey, err := hkdf.Key(sha256.New, key, salt, chacha20Info, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive stream key: %w", err)
 	}
-	w.closed = true
-
-	ciphertext := w.aead.Seal(nil, w.nonce, w.buffer.Bytes(), nil)
-	_, err := w.out.Write(ciphertext)
-	return err
+	aead, err := chacha20poly1305.New(streamKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chacha20poly1305: %w", err)
+	}
+	return aead, nil
 }
 
+// chacha20DecryptWriter decrypt either format: the versioned, chunked one, which it decrypts as it goes,
+// or the legacy one, a nonce followed by the whole archive sealed at once, which it must buffer until Close.
 type chacha20DecryptWriter struct {
-	aead   cipher.AEAD
+	key    []byte
 	out    io.Writer
-	buf    *bytes.Buffer
+	header []byte
+	// stream set once the header is read, for the chunked format
+	stream io.WriteCloser
+	// legacy set once the start is known not to be a header, for the legacy format
+	legacy *bytes.Buffer
 	closed bool
 }
 
 func (w *chacha20DecryptWriter) Write(p []byte) (int, error) {
-	return w.buf.Write(p)
+	switch {
+	case w.stream != nil:
+		return w.stream.Write(p)
+	case w.legacy != nil:
+		return w.legacy.Write(p)
+	}
+	w.header = append(w.header, p...)
+	if len(w.header) >= len(chacha20Magic) && !bytes.HasPrefix(w.header, []byte(chacha20Magic)) {
+		w.legacy = bytes.NewBuffer(w.header)
+		w.header = nil
+		return len(p), nil
+	}
+	if len(w.header) < chacha20HeaderSize {
+		return len(p), nil
+	}
+	if version := w.header[len(chacha20Magic)]; version != chacha20Version {
+		return 0, fmt.Errorf("unsupported chacha20poly1305 format version %d", version)
+	}
+	aead, err := chacha20StreamAEAD(w.key, w.header[len(chacha20Magic)+1:chacha20HeaderSize])
+	if err != nil {
+		return 0, err
+	}
+	w.stream = newStreamDecryptWriter(aead, w.out)
+	if _, err := w.stream.Write(w.header[chacha20HeaderSize:]); err != nil {
+		return 0, err
+	}
+	w.header = nil
+	return len(p), nil
 }
 
 func (w *chacha20DecryptWriter) Close() error {
@@ -116,15 +150,26 @@ func (w *chacha20DecryptWriter) Close() error {
 	}
 	w.closed = true
 
-	data := w.buf.Bytes()
+	if w.stream != nil {
+		return w.stream.Close()
+	}
+	data := w.header
+	if w.legacy != nil {
+		data = w.legacy.Bytes()
+	}
 	if len(data) < chacha20poly1305.NonceSize {
 		return fmt.Errorf("missing nonce or ciphertext")
 	}
 
+	aead, err := chacha20poly1305.New(w.key)
+	if err != nil {
+		return fmt.Errorf("failed to create chacha20poly1305: %w", err)
+	}
+
 	nonce := data[:chacha20poly1305.NonceSize]
 	ciphertext := data[chacha20poly1305.NonceSize:]
 
-	plaintext, err := w.aead.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 		return fmt.Errorf("decryption failed: %w", err)
 	}
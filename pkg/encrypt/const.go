@@ -8,6 +8,10 @@ const (
 	AlgoPBKDF2AES256CBC     = api.EncryptionAlgorithmPbkdf2Aes256Cbc
 	AlgoAgeChacha20Poly1305 = api.EncryptionAlgorithmAgeChacha20Poly1305
 	AlgoChacha20Poly1305    = api.EncryptionAlgorithmChacha20Poly1305
+	// AlgoOpenPGP is not in the API yet, so it is defined here
+	AlgoOpenPGP api.EncryptionAlgorithm = "openpgp"
+	// AlgoAES256GCM is not in the API yet, so it is defined here
+	AlgoAES256GCM api.EncryptionAlgorithm = "aes256-gcm"
 )
 
 var All = []string{
@@ -16,4 +20,6 @@ var All = []string{
 	string(AlgoPBKDF2AES256CBC),
 	string(AlgoAgeChacha20Poly1305),
 	string(AlgoChacha20Poly1305),
+	string(AlgoOpenPGP),
+	string(AlgoAES256GCM),
 }
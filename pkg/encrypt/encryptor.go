@@ -1,6 +1,8 @@
 package encrypt
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 
@@ -10,19 +12,41 @@ import (
 type Encryptor interface {
 	Name() string
 	Description() string
+	// KeyID a short, public identifier of the key, recorded in the Header, so that restore can tell which key
+	// a backup needs; empty if the key cannot be identified without weakening it, e.g. a passphrase
+	KeyID() string
 	Decrypt(out io.Writer) (io.WriteCloser, error)
 	Encrypt(out io.Writer) (io.WriteCloser, error)
 }
 
-func GetEncryptor(name string, key []byte) (Encryptor, error) {
+// Option an option for an encryptor; each encryptor ignores the options that do not apply to it
+type Option func(*options)
+
+type options struct {
+	keyPassword string
+}
+
+// WithKeyPassword the password of the key, for keys that are protected by one, e.g. PKCS#12 files
+// or OpenPGP private keys
+func WithKeyPassword(password string) Option {
+	return func(o *options) {
+		o.keyPassword = password
+	}
+}
+
+func GetEncryptor(name string, key []byte, opts ...Option) (Encryptor, error) {
 	var (
 		enc Encryptor
 		err error
+		o   options
 	)
+	for _, opt := range opts {
+		opt(&o)
+	}
 	nameEnum := api.EncryptionAlgorithm(name)
 	switch nameEnum {
 	case AlgoSMimeAES256CBC:
-		enc, err = NewSMimeAES256CBC(key)
+		enc, err = NewSMimeAES256CBC(key, o.keyPassword)
 	case AlgoDirectAES256CBC:
 		enc, err = NewAES256CBC(key, nil, true)
 	case AlgoPBKDF2AES256CBC:
@@ -31,8 +55,19 @@ func GetEncryptor(name string, key []byte) (Encryptor, error) {
 		enc, err = NewAgeChacha20Poly1305(key)
 	case AlgoChacha20Poly1305:
 		enc, err = NewChacha20Poly1305(key)
+	case AlgoOpenPGP:
+		enc, err = NewOpenPGP(key, o.keyPassword)
+	case AlgoAES256GCM:
+		enc, err = NewAES256GCM(key)
 	default:
 		return nil, fmt.Errorf("unknown encryption format: %s", name)
 	}
 	return enc, err
 }
+
+// keyID the identifier of a key: the start of the hex-encoded SHA-256 of the key, after a prefix, so that it
+// cannot be mistaken for a hash of the key used anywhere else
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("mysql-backup key id\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -23,7 +23,8 @@ import (
 )
 
 func TestEncryptors(t *testing.T) {
-	cleartext, err := generateRandomCleartext(1024)
+	// more than two chunks, and not a whole number of them, for the chunked encryptors
+	cleartext, err := generateRandomCleartext(2*streamChunkSize + 1024)
 	if err != nil {
 		t.Fatalf("failed to generate cleartext: %v", err)
 	}
@@ -67,6 +68,17 @@ func TestEncryptors(t *testing.T) {
 				recipient := identity.Recipient().String() // string form of public key
 				encKey = []byte(recipient)
 				decKey = []byte(identity.String()) // string form of private key
+			case string(AlgoAES256GCM):
+				encKey, err = generateRandomKey(aesGCMKeySize)
+				if err != nil {
+					t.Fatalf("failed to generate AES256 key: %v", err)
+				}
+				decKey = encKey
+			case string(AlgoOpenPGP):
+				encKey, decKey, err = generateOpenPGPKey("recipient", "")
+				if err != nil {
+					t.Fatalf("failed to generate OpenPGP key: %v", err)
+				}
 			default:
 				t.Fatalf("unsupported encryptor: %s", tt)
 			}
@@ -169,42 +181,44 @@ func TestEncryptors(t *testing.T) {
 					t.Error("PBKDF2AES256CBC decrypted output does not match original cleartext")
 				}
 			case string(api.EncryptionAlgorithmChacha20Poly1305):
-				cipherData := encrypted.Bytes()
-
-				const (
-					nonceSize = 12
-					tagSize   = 16
-				)
-
-				if len(cipherData) < nonceSize+tagSize {
-					t.Fatalf("ciphertext too short for ChaCha20-Poly1305: got %d bytes", len(cipherData))
+				if !bytes.HasPrefix(encrypted.Bytes(), []byte(chacha20Magic)) {
+					t.Fatalf("ciphertext does not start with the chacha20poly1305 header")
 				}
-
-				nonce := cipherData[:nonceSize]
-				tag := cipherData[len(cipherData)-tagSize:]
-				ciphertext := cipherData[nonceSize : len(cipherData)-tagSize]
-
-				key := decKey
-				if len(key) != chacha20poly1305.KeySize {
-					t.Fatalf("invalid key size: expected %d bytes, got %d", chacha20poly1305.KeySize, len(key))
-				}
-
-				aead, err := chacha20poly1305.New(key)
+				var decrypted bytes.Buffer
+				decryptor, err := encryptor.Decrypt(&decrypted)
 				if err != nil {
-					t.Fatalf("failed to create AEAD: %v", err)
+					t.Fatalf("Decrypt setup failed: %v", err)
+				}
+				if _, err := decryptor.Write(encrypted.Bytes()); err != nil {
+					t.Fatalf("writing to Decryptor failed: %v", err)
+				}
+				if err := decryptor.Close(); err != nil {
+					t.Errorf("ChaCha20Poly1305 decryption failed: %v", err)
+					return
 				}
 
-				// AEAD expects tag to be appended to ciphertext
-				cipherAndTag := append(ciphertext, tag...)
-
-				plaintext, err := aead.Open(nil, nonce, cipherAndTag, nil)
+				if !bytes.Equal(decrypted.Bytes(), cleartext) {
+					t.Error("ChaCha20Poly1305 decrypted output does not match original cleartext")
+				}
+			case string(AlgoAES256GCM):
+				if !bytes.HasPrefix(encrypted.Bytes(), []byte(aesGCMMagic)) {
+					t.Fatalf("ciphertext does not start with the aes256-gcm header")
+				}
+				var decrypted bytes.Buffer
+				decryptor, err := encryptor.Decrypt(&decrypted)
 				if err != nil {
-					t.Errorf("Go AEAD failed to decrypt ChaCha20Poly1305: %v", err)
+					t.Fatalf("Decrypt setup failed: %v", err)
+				}
+				if _, err := decryptor.Write(encrypted.Bytes()); err != nil {
+					t.Fatalf("writing to Decryptor failed: %v", err)
+				}
+				if err := decryptor.Close(); err != nil {
+					t.Errorf("AES256GCM decryption failed: %v", err)
 					return
 				}
 
-				if !bytes.Equal(plaintext, cleartext) {
-					t.Error("ChaCha20Poly1305 decrypted output does not match original cleartext")
+				if !bytes.Equal(decrypted.Bytes(), cleartext) {
+					t.Error("AES256GCM decrypted output does not match original cleartext")
 				}
 			case string(api.EncryptionAlgorithmAgeChacha20Poly1305):
 				parsedIdentities, err := age.ParseIdentities(bytes.NewReader(decKey))
@@ -234,6 +248,13 @@ func TestEncryptors(t *testing.T) {
 				if !bytes.Equal(decrypted, cleartext) {
 					t.Error("decrypted output does not match original cleartext")
 				}
+			case string(AlgoOpenPGP):
+				home := gpgHome(t)
+				gpg(t, home, decKey, "--import")
+				decrypted := gpg(t, home, encrypted.Bytes(), "--decrypt")
+				if !bytes.Equal(decrypted, cleartext) {
+					t.Error("OpenPGP decrypted output does not match original cleartext")
+				}
 			default:
 				t.Logf("No OpenSSL validation for: %s", encryptor.Name())
 			}
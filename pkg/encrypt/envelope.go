@@ -0,0 +1,156 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// MasterKeySize the size of a master key for envelope encryption
+	MasterKeySize = chacha20poly1305.KeySize
+	// dataKeySize the size of the random data key of each file
+	dataKeySize = 32
+)
+
+// envelopeAlgorithms the algorithms that can encrypt with a data key, which are those whose key is random bytes,
+// rather than e.g. recipients that wrap a key of their own
+var envelopeAlgorithms = []string{
+	string(AlgoChacha20Poly1305),
+	string(AlgoAES256GCM),
+	string(AlgoDirectAES256CBC),
+	string(AlgoPBKDF2AES256CBC),
+}
+
+// WrappedKey the data key of a file, encrypted with a master key, for the Header
+type WrappedKey struct {
+	// KeyID identifies the master key that the data key is wrapped with, as keyID does
+	KeyID string `json:"keyId"`
+	// Key the nonce, followed by the data key sealed with XChaCha20-Poly1305 with the master key
+	Key []byte `json:"key"`
+}
+
+// Envelope envelope encryption: each file is encrypted with a new random data key, which is wrapped with each of
+// the master keys and stored in the header of the file, so that any one master key decrypts it, and the master
+// keys can be changed without encrypting the file again.
+type Envelope struct {
+	algorithm  string
+	masterKeys [][]byte
+}
+
+// NewEnvelope envelope encryption of files with algorithm, which must be one that takes a random key,
+// with data keys wrapped with each of the master keys, which must be MasterKeySize bytes
+func NewEnvelope(algorithm string, masterKeys [][]byte) (*Envelope, error) {
+	if !slices.Contains(envelopeAlgorithms, algorithm) {
+		return nil, fmt.Errorf("envelope encryption is not supported with %s, only with %s", algorithm, strings.Join(envelopeAlgorithms, ", "))
+	}
+	if len(masterKeys) == 0 {
+		return nil, fmt.Errorf("envelope encryption needs at least one master key")
+	}
+	for i, key := range masterKeys {
+		if len(key) != MasterKeySize {
+			return nil, fmt.Errorf("master key %d has length %d, must be %d", i+1, len(key), MasterKeySize)
+		}
+	}
+	return &Envelope{algorithm: algorithm, masterKeys: masterKeys}, nil
+}
+
+// Algorithm the algorithm that files are encrypted with
+func (e *Envelope) Algorithm() string {
+	return e.algorithm
+}
+
+// NewDataKey a new random data key for a file, returning the encryptor for it, and the key wrapped with
+// each of the master keys
+func (e *Envelope) NewDataKey() (Encryptor, []WrappedKey, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	encryptor, err := GetEncryptor(e.algorithm, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var wrapped []WrappedKey
+	for _, masterKey := range e.masterKeys {
+		w, err := WrapKey(masterKey, dataKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		wrapped = append(wrapped, w)
+	}
+	return encryptor, wrapped, nil
+}
+
+// MasterKeyID the ID of a master key, as recorded with each data key that it wraps
+func MasterKeyID(masterKey []byte) string {
+	return keyID(masterKey)
+}
+
+// WrapKey encrypt the data key with the master key
+func WrapKey(masterKey, dataKey []byte) (WrappedKey, error) {
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("invalid master key: %w", err)
+	}
+	id := MasterKeyID(masterKey)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return WrappedKey{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// the ID is authenticated too, so that a wrapped key cannot be attributed to another master key
+	return WrappedKey{KeyID: id, Key: aead.Seal(nonce, nonce, dataKey, []byte(id))}, nil
+}
+
+// UnwrapKey decrypt the data key from the one of the wrapped keys that is wrapped with the master key
+func UnwrapKey(wrapped []WrappedKey, masterKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	id := MasterKeyID(masterKey)
+	var ids []string
+	for _, w := range wrapped {
+		if w.KeyID != id {
+			ids = append(ids, w.KeyID)
+			continue
+		}
+		if len(w.Key) < aead.NonceSize() {
+			return nil, fmt.Errorf("wrapped data key for master key ID %s is too short", id)
+		}
+		dataKey, err := aead.Open(nil, w.Key[:aead.NonceSize()], w.Key[aead.NonceSize():], []byte(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key with master key ID %s: %w", id, err)
+		}
+		return dataKey, nil
+	}
+	return nil, fmt.Errorf("data key is wrapped with the master keys with IDs %s, not the given key with ID %s", strings.Join(ids, ", "), id)
+}
+
+// Rewrap replace the data key wrapped with oldKey with it wrapped with newKey, leaving the data key, and so
+// the encrypted file, as it is. Keys wrapped with other master keys are kept.
+func Rewrap(wrapped []WrappedKey, oldKey, newKey []byte) ([]WrappedKey, error) {
+	if bytes.Equal(oldKey, newKey) {
+		return nil, fmt.Errorf("the old and new master keys are the same")
+	}
+	dataKey, err := UnwrapKey(wrapped, oldKey)
+	if err != nil {
+		return nil, err
+	}
+	w, err := WrapKey(newKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	oldID := MasterKeyID(oldKey)
+	rewrapped := []WrappedKey{}
+	for _, existing := range wrapped {
+		if existing.KeyID != oldID && existing.KeyID != w.KeyID {
+			rewrapped = append(rewrapped, existing)
+		}
+	}
+	return append(rewrapped, w), nil
+}
@@ -0,0 +1,104 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	newKey := func() []byte {
+		key := make([]byte, MasterKeySize)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		return key
+	}
+	first, second, third := newKey(), newKey(), newKey()
+
+	t.Run("encrypt and decrypt with each master key", func(t *testing.T) {
+		envelope, err := NewEnvelope(string(AlgoChacha20Poly1305), [][]byte{first, second})
+		require.NoError(t, err)
+		encryptor, wrapped, err := envelope.NewDataKey()
+		require.NoError(t, err)
+		require.Len(t, wrapped, 2)
+		assert.Equal(t, MasterKeyID(first), wrapped[0].KeyID)
+		assert.Equal(t, MasterKeyID(second), wrapped[1].KeyID)
+
+		cleartext := []byte("the archive")
+		var encrypted bytes.Buffer
+		w, err := encryptor.Encrypt(&encrypted)
+		require.NoError(t, err)
+		require.NoError(t, writeAll(w, cleartext, len(cleartext)))
+		for _, masterKey := range [][]byte{first, second} {
+			dataKey, err := UnwrapKey(wrapped, masterKey)
+			require.NoError(t, err)
+			decryptor, err := GetEncryptor(envelope.Algorithm(), dataKey)
+			require.NoError(t, err)
+			var decrypted bytes.Buffer
+			w, err := decryptor.Decrypt(&decrypted)
+			require.NoError(t, err)
+			require.NoError(t, writeAll(w, encrypted.Bytes(), encrypted.Len()))
+			assert.Equal(t, cleartext, decrypted.Bytes())
+		}
+		_, err = UnwrapKey(wrapped, third)
+		assert.ErrorContains(t, err, "not the given key with ID "+MasterKeyID(third))
+
+		// each file has a data key of its own
+		_, again, err := envelope.NewDataKey()
+		require.NoError(t, err)
+		k1, err := UnwrapKey(wrapped, first)
+		require.NoError(t, err)
+		k2, err := UnwrapKey(again, first)
+		require.NoError(t, err)
+		assert.NotEqual(t, k1, k2)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		wrapped, err := WrapKey(first, []byte("data key"))
+		require.NoError(t, err)
+		wrapped.Key[len(wrapped.Key)-1] ^= 1
+		_, err = UnwrapKey([]WrappedKey{wrapped}, first)
+		assert.ErrorContains(t, err, "failed to unwrap data key")
+	})
+
+	t.Run("rewrap", func(t *testing.T) {
+		dataKey := newKey()
+		a, err := WrapKey(first, dataKey)
+		require.NoError(t, err)
+		b, err := WrapKey(second, dataKey)
+		require.NoError(t, err)
+		rewrapped, err := Rewrap([]WrappedKey{a, b}, first, third)
+		require.NoError(t, err)
+		require.Len(t, rewrapped, 2)
+		assert.Equal(t, b, rewrapped[0])
+		for _, masterKey := range [][]byte{second, third} {
+			unwrapped, err := UnwrapKey(rewrapped, masterKey)
+			require.NoError(t, err)
+			assert.Equal(t, dataKey, unwrapped)
+		}
+		_, err = UnwrapKey(rewrapped, first)
+		assert.Error(t, err)
+
+		// rewrapping with a key that already wraps it does not add it twice
+		rewrapped, err = Rewrap([]WrappedKey{a, b}, first, second)
+		require.NoError(t, err)
+		require.Len(t, rewrapped, 1)
+
+		_, err = Rewrap([]WrappedKey{a}, first, first)
+		assert.ErrorContains(t, err, "are the same")
+		_, err = Rewrap([]WrappedKey{b}, first, third)
+		assert.ErrorContains(t, err, "not the given key")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewEnvelope(string(AlgoAgeChacha20Poly1305), [][]byte{first})
+		assert.ErrorContains(t, err, "envelope encryption is not supported")
+		_, err = NewEnvelope(string(AlgoChacha20Poly1305), nil)
+		assert.ErrorContains(t, err, "at least one master key")
+		_, err = NewEnvelope(string(AlgoChacha20Poly1305), [][]byte{first, []byte("short")})
+		assert.ErrorContains(t, err, "master key 2 has length 5")
+	})
+}
@@ -0,0 +1,103 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// HeaderMagic the start of an encrypted backup file. Files without it are either not encrypted, or
+	// encrypted in the legacy layout, where the encrypted archive was compressed, rather than the other way around.
+	HeaderMagic = "DBKENC"
+	// HeaderVersion the version of the header format, following the magic
+	HeaderVersion = 1
+	// Extension appended to the extension of the compression of encrypted backup files
+	Extension = "enc"
+	// OpenPGPExtension appended instead for openpgp, whose backup files have no header, so that gpg reads them
+	OpenPGPExtension = "gpg"
+)
+
+// HasHeader whether backup files encrypted with algorithm start with the header. Those encrypted with openpgp do
+// not, as they are plain OpenPGP messages, which restore recognizes by their first packet.
+func HasHeader(algorithm string) bool {
+	return algorithm != string(AlgoOpenPGP)
+}
+
+// FileExtension the extension appended to the extension of the compression of backup files encrypted with algorithm
+func FileExtension(algorithm string) string {
+	if !HasHeader(algorithm) {
+		return OpenPGPExtension
+	}
+	return Extension
+}
+
+// Header describes an encrypted backup file, so that it can be restored without knowing how it was made.
+// It is written before the encrypted data, as the magic, the version, the big-endian uint16 length of the
+// fields, and the fields as JSON.
+type Header struct {
+	// Algorithm the encryption algorithm, as given to GetEncryptor
+	Algorithm string `json:"algorithm"`
+	// Compression the compression of the archive inside of the encryption, as given to compression.GetCompressor
+	Compression string `json:"compression,omitempty"`
+	// KeyID identifies the key that decrypts the file, see Encryptor.KeyID
+	KeyID string `json:"keyId,omitempty"`
+	// Keys with envelope encryption, the data key that the file is encrypted with, wrapped with each of the
+	// master keys; see Envelope
+	Keys []WrappedKey `json:"keys,omitempty"`
+}
+
+// WriteHeader write the header to the start of an encrypted backup file
+func WriteHeader(out io.Writer, h Header) error {
+	fields, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("failed to encode encryption header: %w", err)
+	}
+	if len(fields) > math.MaxUint16 {
+		return fmt.Errorf("encryption header is too large, %d bytes", len(fields))
+	}
+	header := append([]byte(HeaderMagic), HeaderVersion)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(fields)))
+	header = append(header, fields...)
+	if _, err := out.Write(header); err != nil {
+		return fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	return nil
+}
+
+// ReadHeader read the header from the start of a backup file. Returns nil if the file has none, i.e. it is
+// not encrypted or is in the legacy layout, along with the reader for the rest of the file, which includes
+// the bytes read to look for the header if there was none.
+func ReadHeader(in io.Reader) (*Header, io.Reader, error) {
+	br := bufio.NewReader(in)
+	magic, err := br.Peek(len(HeaderMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !bytes.Equal(magic, []byte(HeaderMagic)) {
+		return nil, br, nil
+	}
+	prefix := make([]byte, len(HeaderMagic)+3)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if version := prefix[len(HeaderMagic)]; version != HeaderVersion {
+		return nil, nil, fmt.Errorf("unsupported encryption header version %d", version)
+	}
+	fields := make([]byte, binary.BigEndian.Uint16(prefix[len(HeaderMagic)+1:]))
+	if _, err := io.ReadFull(br, fields); err != nil {
+		return nil, nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	var h Header
+	if err := json.Unmarshal(fields, &h); err != nil {
+		return nil, nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+	if h.Algorithm == "" {
+		return nil, nil, fmt.Errorf("invalid encryption header: no algorithm")
+	}
+	return &h, br, nil
+}
@@ -0,0 +1,41 @@
+package encrypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	header := Header{Algorithm: string(AlgoChacha20Poly1305), Compression: "zstd", KeyID: "0123456789abcdef"}
+	var buf bytes.Buffer
+	require.NoError(t, WriteHeader(&buf, header))
+	buf.WriteString("encrypted data")
+
+	read, rest, err := ReadHeader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, &header, read)
+	data, err := io.ReadAll(rest)
+	require.NoError(t, err)
+	assert.Equal(t, "encrypted data", string(data))
+
+	// files without a header are read from the start
+	for _, legacy := range []string{"", "\x1f\x8b\x08", "DBKE", "DBKCHACHA"} {
+		read, rest, err := ReadHeader(bytes.NewReader([]byte(legacy)))
+		require.NoError(t, err)
+		assert.Nil(t, read)
+		data, err := io.ReadAll(rest)
+		require.NoError(t, err)
+		assert.Equal(t, legacy, string(data))
+	}
+
+	_, _, err = ReadHeader(bytes.NewReader([]byte(HeaderMagic + "\x02\x00\x00")))
+	assert.ErrorContains(t, err, "unsupported encryption header version 2")
+	_, _, err = ReadHeader(bytes.NewReader([]byte(HeaderMagic + "\x01\x00\x02{}")))
+	assert.ErrorContains(t, err, "no algorithm")
+	_, _, err = ReadHeader(bytes.NewReader([]byte(HeaderMagic + "\x01\x00\x10{}")))
+	assert.ErrorContains(t, err, "failed to read encryption header")
+}
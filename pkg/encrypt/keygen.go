@@ -0,0 +1,163 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/databacker/api/go/api"
+)
+
+const (
+	// smimeKeyBits the size of the RSA keys of generated S/MIME certificates
+	smimeKeyBits = 3072
+	// smimeValidity how long generated S/MIME certificates are valid; only the key matters for encryption,
+	// but some tools refuse expired certificates
+	smimeValidity = 10 * 365 * 24 * time.Hour
+	// passphraseSize the random bytes in a generated passphrase, before it is base64-encoded
+	passphraseSize = 32
+)
+
+// GeneratedKey a new key for an algorithm, in the format that its encryptor reads
+type GeneratedKey struct {
+	// Private the key that decrypts, and for symmetric algorithms, encrypts as well
+	Private []byte
+	// Public the key that encrypts, for algorithms with public keys; nil for symmetric algorithms
+	Public []byte
+	// Fingerprint identifies the key in the usual way for the algorithm, e.g. the age recipient or the OpenPGP
+	// fingerprint; empty for a passphrase, which a hash would make easier to guess
+	Fingerprint string
+}
+
+// GenerateKey create a new key for the algorithm. The name is the owner of the key, for the algorithms that
+// record one, i.e. the subject of S/MIME certificates and the user ID of OpenPGP keys.
+func GenerateKey(algorithm, name string) (GeneratedKey, error) {
+	switch api.EncryptionAlgorithm(algorithm) {
+	case AlgoDirectAES256CBC, AlgoChacha20Poly1305, AlgoAES256GCM:
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return GeneratedKey{}, fmt.Errorf("failed to generate key: %v", err)
+		}
+		return GeneratedKey{Private: key, Fingerprint: keyID(key)}, nil
+	case AlgoPBKDF2AES256CBC:
+		passphrase := make([]byte, passphraseSize)
+		if _, err := rand.Read(passphrase); err != nil {
+			return GeneratedKey{}, fmt.Errorf("failed to generate passphrase: %v", err)
+		}
+		return GeneratedKey{Private: []byte(base64.RawURLEncoding.EncodeToString(passphrase))}, nil
+	case AlgoAgeChacha20Poly1305:
+		return newAgeKey()
+	case AlgoSMimeAES256CBC:
+		return newSMimeKey(name)
+	case AlgoOpenPGP:
+		return newOpenPGPKey(name)
+	default:
+		return GeneratedKey{}, fmt.Errorf("unknown encryption format: %s", algorithm)
+	}
+}
+
+// newAgeKey an X25519 identity, in the format of age-keygen, and its recipient
+func newAgeKey() (GeneratedKey, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to generate age identity: %v", err)
+	}
+	recipient := identity.Recipient().String()
+	private := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity.String())
+	return GeneratedKey{Private: []byte(private), Public: []byte(recipient + "\n"), Fingerprint: recipient}, nil
+}
+
+// newSMimeKey a self-signed certificate for email protection, with an RSA key, since only RSA keys can
+// decrypt. The private key is the certificate and the key in PEM, as restore reads them.
+func newSMimeKey(name string) (GeneratedKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, smimeKeyBits)
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to generate RSA key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(smimeValidity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to marshal private key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	sum := sha256.Sum256(der)
+	return GeneratedKey{
+		Private:     append(append([]byte{}, certPEM...), keyPEM...),
+		Public:      certPEM,
+		Fingerprint: "sha256:" + hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// newOpenPGPKey an Ed25519 signing key with a Curve25519 encryption subkey, armored, as gpg exports them
+func newOpenPGPKey(name string) (GeneratedKey, error) {
+	config := &packet.Config{
+		Algorithm:              packet.PubKeyAlgoEdDSA,
+		DefaultCipher:          openpgpConfig.DefaultCipher,
+		DefaultCompressionAlgo: openpgpConfig.DefaultCompressionAlgo,
+	}
+	entity, err := openpgp.NewEntity(name, "", "", config)
+	if err != nil {
+		return GeneratedKey{}, fmt.Errorf("failed to generate OpenPGP key: %v", err)
+	}
+	public, err := armorKey(openpgpPublicKeyBlock, entity.Serialize)
+	if err != nil {
+		return GeneratedKey{}, err
+	}
+	private, err := armorKey(openpgpPrivateKeyBlock, func(w io.Writer) error { return entity.SerializePrivate(w, config) })
+	if err != nil {
+		return GeneratedKey{}, err
+	}
+	return GeneratedKey{
+		Private:     private,
+		Public:      public,
+		Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+	}, nil
+}
+
+// armorKey an OpenPGP key, serialized by serialize, in an armored block of the type
+func armorKey(blockType string, serialize func(io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor OpenPGP key: %v", err)
+	}
+	if err := serialize(w); err != nil {
+		return nil, fmt.Errorf("failed to serialize OpenPGP key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to armor OpenPGP key: %v", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
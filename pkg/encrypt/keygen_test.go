@@ -0,0 +1,83 @@
+package encrypt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	cleartext, err := generateRandomCleartext(streamChunkSize + 1024)
+	if err != nil {
+		t.Fatalf("failed to generate cleartext: %v", err)
+	}
+	for _, algorithm := range All {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateKey(algorithm, "backup host")
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			// encrypt with the public key, if there is one, as dump would, and decrypt with the private key
+			encKey := key.Public
+			if encKey == nil {
+				encKey = key.Private
+			}
+			encryptor, err := GetEncryptor(algorithm, encKey)
+			if err != nil {
+				t.Fatalf("failed to get encryptor: %v", err)
+			}
+			decryptor, err := GetEncryptor(algorithm, key.Private)
+			if err != nil {
+				t.Fatalf("failed to get decryptor: %v", err)
+			}
+			var encrypted, decrypted bytes.Buffer
+			w, err := encryptor.Encrypt(&encrypted)
+			if err != nil {
+				t.Fatalf("Encrypt setup failed: %v", err)
+			}
+			if err := writeAll(w, cleartext, len(cleartext)); err != nil {
+				t.Fatalf("encrypting failed: %v", err)
+			}
+			if algorithm == string(AlgoPBKDF2AES256CBC) {
+				// decrypted with openssl, see TestEncryptors
+				if key.Fingerprint != "" {
+					t.Errorf("passphrase has a fingerprint %q", key.Fingerprint)
+				}
+				return
+			}
+			r, err := decryptor.Decrypt(&decrypted)
+			if err != nil {
+				t.Fatalf("Decrypt setup failed: %v", err)
+			}
+			if err := writeAll(r, encrypted.Bytes(), encrypted.Len()); err != nil {
+				t.Fatalf("decrypting failed: %v", err)
+			}
+			if !bytes.Equal(cleartext, decrypted.Bytes()) {
+				t.Fatalf("decrypted data does not match the cleartext")
+			}
+
+			if key.Fingerprint == "" {
+				t.Errorf("no fingerprint")
+			}
+			if key.Public != nil && bytes.Contains(key.Public, []byte("PRIVATE")) {
+				t.Errorf("public key contains a private key")
+			}
+		})
+	}
+
+	t.Run("armored", func(t *testing.T) {
+		key, err := GenerateKey(string(AlgoOpenPGP), "backup host")
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		if !strings.Contains(string(key.Public), "BEGIN PGP PUBLIC KEY BLOCK") {
+			t.Errorf("public key is not armored: %s", key.Public)
+		}
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		if _, err := GenerateKey("rot13", ""); err == nil {
+			t.Fatal("missing error")
+		}
+	})
+}
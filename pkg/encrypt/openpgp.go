@@ -0,0 +1,216 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const (
+	openpgpPublicKeyBlock  = "PGP PUBLIC KEY BLOCK"
+	openpgpPrivateKeyBlock = "PGP PRIVATE KEY BLOCK"
+
+	// packet tags of the session key packets that an encrypted OpenPGP message starts with
+	packetTagPublicKeyEncrypted    = 1
+	packetTagSymmetricKeyEncrypted = 3
+)
+
+var _ Encryptor = &OpenPGP{}
+
+// OpenPGP OpenPGP, as gpg encrypts, to any number of public keys, optionally signed with a private key.
+// Decrypts with any of the private keys, verifying the signature, if any, with any of the public keys.
+type OpenPGP struct {
+	// recipients the public keys to encrypt to
+	recipients openpgp.EntityList
+	// signer the private key to sign with; nil not to sign
+	signer *openpgp.Entity
+	// keyring the keys to decrypt and verify with
+	keyring openpgp.EntityList
+	// requireSignature whether to decrypt only signed messages, as when there are public keys of signers to verify with
+	requireSignature bool
+	keyID            string
+}
+
+// NewOpenPGP create an OpenPGP encryptor from armored keyrings, as exported by gpg --armor --export and
+// --export-secret-keys, concatenated. It encrypts to every public key, and signs with the first private key,
+// if any. It decrypts with any of the private keys, and verifies signatures with any of the public ones, so to
+// restore a signed backup, give the private key to decrypt it with, and the public key of the signer.
+// Private keys protected by a passphrase are decrypted with password.
+func NewOpenPGP(key []byte, password string) (*OpenPGP, error) {
+	o := &OpenPGP{}
+	blocks, err := splitArmor(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no armored OpenPGP keys")
+	}
+	var fingerprints []string
+	for _, block := range blocks {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(block.data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenPGP %s: %w", strings.ToLower(block.kind), err)
+		}
+		for _, entity := range entities {
+			if block.kind == openpgpPublicKeyBlock {
+				o.recipients = append(o.recipients, entity)
+				fingerprints = append(fingerprints, fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint))
+				o.keyring = append(o.keyring, entity)
+				continue
+			}
+			if entity.PrivateKey == nil {
+				return nil, fmt.Errorf("OpenPGP private key block has a key %X without its private key", entity.PrimaryKey.Fingerprint)
+			}
+			if entity.PrivateKey.Encrypted {
+				if password == "" {
+					return nil, fmt.Errorf("OpenPGP private key %X is protected by a passphrase, set the key password", entity.PrimaryKey.Fingerprint)
+				}
+				if err := entity.DecryptPrivateKeys([]byte(password)); err != nil {
+					return nil, fmt.Errorf("failed to decrypt OpenPGP private key %X: %w", entity.PrimaryKey.Fingerprint, err)
+				}
+			}
+			if o.signer == nil {
+				o.signer = entity
+			}
+			o.keyring = append(o.keyring, entity)
+		}
+	}
+	// a public key without its private key is only there to verify signatures with, so an unsigned message,
+	// which anyone with the public keys of the recipients can make, is not accepted
+	for _, entity := range o.recipients {
+		if !slices.ContainsFunc(o.keyring, func(e *openpgp.Entity) bool {
+			return e.PrivateKey != nil && bytes.Equal(e.PrimaryKey.Fingerprint, entity.PrimaryKey.Fingerprint)
+		}) {
+			o.requireSignature = true
+		}
+	}
+	// as for age, only the set of public keys identifies the key of a file, which a private key is just one of
+	if o.signer == nil {
+		slices.Sort(fingerprints)
+		o.keyID = keyID([]byte(strings.Join(fingerprints, "\n")))
+	}
+	return o, nil
+}
+
+// armorBlock an armored block of OpenPGP data, with its kind, e.g. PGP PUBLIC KEY BLOCK
+type armorBlock struct {
+	kind string
+	data []byte
+}
+
+// splitArmor split data into its armored blocks, since the armor decoder reads only the first, and reads ahead
+// past its end
+func splitArmor(data []byte) ([]armorBlock, error) {
+	var blocks []armorBlock
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "-----BEGIN ") {
+			continue
+		}
+		kind := strings.TrimSuffix(strings.TrimPrefix(line, "-----BEGIN "), "-----")
+		if kind != openpgpPublicKeyBlock && kind != openpgpPrivateKeyBlock {
+			return nil, fmt.Errorf("unsupported armored block %s on line %d", kind, i+1)
+		}
+		end := i
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "-----END "+kind+"-----" {
+			end++
+		}
+		if end == len(lines) {
+			return nil, fmt.Errorf("armored %s on line %d has no end", kind, i+1)
+		}
+		blocks = append(blocks, armorBlock{kind: kind, data: []byte(strings.Join(lines[i:end+1], "\n"))})
+		i = end
+	}
+	return blocks, nil
+}
+
+func (o *OpenPGP) Name() string {
+	return string(AlgoOpenPGP)
+}
+
+func (o *OpenPGP) Description() string {
+	return "OpenPGP, encrypted to public keys and optionally signed, readable with `gpg --decrypt`."
+}
+
+func (o *OpenPGP) KeyID() string {
+	return o.keyID
+}
+
+// openpgpConfig AES-256, without compression, since the archive is compressed before it is encrypted
+var openpgpConfig = &packet.Config{
+	DefaultCipher:          packet.CipherAES256,
+	DefaultCompressionAlgo: packet.CompressionNone,
+}
+
+func (o *OpenPGP) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	if len(o.recipients) == 0 {
+		return nil, fmt.Errorf("OpenPGP encryption needs a public key to encrypt to")
+	}
+	w, err := openpgp.Encrypt(out, o.recipients, o.signer, &openpgp.FileHints{IsBinary: true}, openpgpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize OpenPGP writer: %w", err)
+	}
+	return w, nil
+}
+
+func (o *OpenPGP) Decrypt(out io.Writer) (io.WriteCloser, error) {
+	if o.signer == nil {
+		return nil, fmt.Errorf("OpenPGP decryption needs a private key, not only public keys")
+	}
+	keyring, requireSignature := o.keyring, o.requireSignature
+	return newPipeDecryptWriter(func(in io.Reader) error {
+		return decryptOpenPGP(in, keyring, requireSignature, out)
+	}), nil
+}
+
+// DetectOpenPGP whether in starts with an OpenPGP encrypted message, i.e. with a public-key or symmetric-key
+// encrypted session key packet, in either packet format, along with the reader for all of in
+func DetectOpenPGP(in io.Reader) (bool, io.Reader, error) {
+	br := bufio.NewReader(in)
+	first, err := br.Peek(1)
+	if err != nil && err != io.EOF {
+		return false, nil, fmt.Errorf("failed to read OpenPGP packet: %w", err)
+	}
+	if len(first) == 0 || first[0]&0x80 == 0 {
+		return false, br, nil
+	}
+	tag := (first[0] & 0x3f) >> 2
+	if first[0]&0x40 != 0 {
+		tag = first[0] & 0x3f
+	}
+	return tag == packetTagPublicKeyEncrypted || tag == packetTagSymmetricKeyEncrypted, br, nil
+}
+
+// decryptOpenPGP decrypt the message, failing if it is signed, but the signature cannot be verified, or if it is
+// not signed, but a signature is required
+func decryptOpenPGP(in io.Reader, keyring openpgp.EntityList, requireSignature bool, out io.Writer) error {
+	md, err := openpgp.ReadMessage(in, keyring, nil, openpgpConfig)
+	if err != nil {
+		return fmt.Errorf("OpenPGP decryption failed: %w", err)
+	}
+	if !md.IsSigned && requireSignature {
+		return fmt.Errorf("OpenPGP message is not signed, but public keys were given to verify its signature with")
+	}
+	if md.IsSigned && md.SignedBy == nil {
+		return fmt.Errorf("OpenPGP message is signed by the unknown key %X, give its public key to verify it", md.SignedByKeyId)
+	}
+	// the integrity and the signature are checked once all of it is read
+	if _, err := io.Copy(out, md.UnverifiedBody); err != nil {
+		return fmt.Errorf("OpenPGP decryption failed: %w", err)
+	}
+	if md.IsSigned && md.SignatureError != nil {
+		return fmt.Errorf("OpenPGP signature verification failed: %w", md.SignatureError)
+	}
+	// the message may end before its input does, which is written to the end nonetheless
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return fmt.Errorf("OpenPGP decryption failed: %w", err)
+	}
+	return nil
+}
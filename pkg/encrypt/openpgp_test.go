@@ -0,0 +1,249 @@
+package encrypt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func TestOpenPGP(t *testing.T) {
+	cleartext, err := generateRandomCleartext(2*streamChunkSize + 1000)
+	if err != nil {
+		t.Fatalf("failed to generate cleartext: %v", err)
+	}
+	alicePublic, alicePrivate, err := generateOpenPGPKey("alice", "")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	bobPublic, bobPrivate, err := generateOpenPGPKey("bob", "")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signerPublic, signerPrivate, err := generateOpenPGPKey("backup host", "hunter2")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, otherSignerPrivate, err := generateOpenPGPKey("other host", "")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	concat := func(keys ...[]byte) []byte {
+		return bytes.Join(keys, []byte("\n"))
+	}
+
+	tests := []struct {
+		name        string
+		encKey      []byte
+		encPassword string
+		decKey      []byte
+		decPassword string
+		keyErr      string
+		err         string
+	}{
+		{"one recipient", alicePublic, "", alicePrivate, "", "", ""},
+		{"second of two recipients", concat(alicePublic, bobPublic), "", bobPrivate, "", "", ""},
+		{"signed", concat(alicePublic, signerPrivate), "hunter2", concat(alicePrivate, signerPublic), "", "", ""},
+		{"unsigned with signer public key", alicePublic, "", concat(alicePrivate, signerPublic), "", "", "not signed"},
+		{"unsigned with own public key", alicePublic, "", concat(alicePublic, alicePrivate), "", "", ""},
+		{"signed by unknown key", concat(alicePublic, otherSignerPrivate), "", concat(alicePrivate, signerPublic), "", "", "unknown key"},
+		{"signing key without password", concat(alicePublic, signerPrivate), "", nil, "", "protected by a passphrase", ""},
+		{"signing key with wrong password", concat(alicePublic, signerPrivate), "wrong", nil, "", "failed to decrypt", ""},
+		{"not a recipient", alicePublic, "", bobPrivate, "", "", "decryption failed"},
+		{"public keys only", alicePublic, "", alicePublic, "", "", "needs a private key"},
+		{"not armored", []byte("age1abc"), "", nil, "", "no armored OpenPGP keys", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encryptor, err := NewOpenPGP(tt.encKey, tt.encPassword)
+			if tt.keyErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.keyErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.keyErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create encryptor: %v", err)
+			}
+			var encrypted bytes.Buffer
+			w, err := encryptor.Encrypt(&encrypted)
+			if err != nil {
+				t.Fatalf("Encrypt setup failed: %v", err)
+			}
+			if err := writeAll(w, cleartext, 1000); err != nil {
+				t.Fatalf("encryption failed: %v", err)
+			}
+
+			decryptor, err := NewOpenPGP(tt.decKey, tt.decPassword)
+			if err != nil {
+				t.Fatalf("failed to create decryptor: %v", err)
+			}
+			var decrypted bytes.Buffer
+			w, err = decryptor.Decrypt(&decrypted)
+			if err == nil {
+				err = writeAll(w, encrypted.Bytes(), 1000)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decryption failed: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), cleartext) {
+				t.Error("decrypted output does not match original cleartext")
+			}
+		})
+	}
+
+	t.Run("detect", func(t *testing.T) {
+		encryptor, err := NewOpenPGP(alicePublic, "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		var encrypted bytes.Buffer
+		w, err := encryptor.Encrypt(&encrypted)
+		if err != nil {
+			t.Fatalf("Encrypt setup failed: %v", err)
+		}
+		if err := writeAll(w, cleartext, 1000); err != nil {
+			t.Fatalf("encryption failed: %v", err)
+		}
+		for _, tt := range []struct {
+			data     []byte
+			expected bool
+		}{
+			{encrypted.Bytes(), true},
+			{nil, false},
+			{[]byte("\x1f\x8b\x08"), false},
+			{[]byte(HeaderMagic), false},
+			{alicePublic, false},
+		} {
+			isOpenPGP, rest, err := DetectOpenPGP(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("detection failed: %v", err)
+			}
+			if isOpenPGP != tt.expected {
+				t.Errorf("%q: expected OpenPGP %v, got %v", tt.data[:min(len(tt.data), 8)], tt.expected, isOpenPGP)
+			}
+			if data, _ := io.ReadAll(rest); !bytes.Equal(data, tt.data) {
+				t.Errorf("%q: data changed by detection", tt.data[:min(len(tt.data), 8)])
+			}
+		}
+	})
+
+	t.Run("key ID", func(t *testing.T) {
+		ab, err := NewOpenPGP(concat(alicePublic, bobPublic), "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		ba, err := NewOpenPGP(concat(bobPublic, alicePublic), "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		a, err := NewOpenPGP(alicePrivate, "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		if ab.KeyID() == "" || ab.KeyID() != ba.KeyID() {
+			t.Errorf("key ID %q does not match %q for the same recipients", ab.KeyID(), ba.KeyID())
+		}
+		if a.KeyID() != "" {
+			t.Errorf("expected no key ID with a private key, got %q", a.KeyID())
+		}
+	})
+
+	t.Run("encrypted by gpg", func(t *testing.T) {
+		home := gpgHome(t)
+		gpg(t, home, alicePublic, "--import")
+		encrypted := gpg(t, home, cleartext, "--encrypt", "--trust-model", "always", "--recipient", "alice@example.com")
+		if isOpenPGP, _, err := DetectOpenPGP(bytes.NewReader(encrypted)); err != nil || !isOpenPGP {
+			t.Errorf("message encrypted by gpg not detected as OpenPGP: %v", err)
+		}
+		decryptor, err := NewOpenPGP(alicePrivate, "")
+		if err != nil {
+			t.Fatalf("failed to create decryptor: %v", err)
+		}
+		var decrypted bytes.Buffer
+		w, err := decryptor.Decrypt(&decrypted)
+		if err != nil {
+			t.Fatalf("Decrypt setup failed: %v", err)
+		}
+		if err := writeAll(w, encrypted, len(encrypted)); err != nil {
+			t.Fatalf("decryption failed: %v", err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), cleartext) {
+			t.Error("decrypted output does not match original cleartext")
+		}
+	})
+}
+
+// generateOpenPGPKey generate an OpenPGP key for name@example.com, returning its armored public and private keys,
+// the private one protected by passphrase, unless it is empty
+func generateOpenPGPKey(name, passphrase string) (public, private []byte, err error) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var publicBuf, privateBuf bytes.Buffer
+	w, err := armor.Encode(&publicBuf, openpgpPublicKeyBlock, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := entity.Serialize(w); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	if passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	if w, err = armor.Encode(&privateBuf, openpgpPrivateKeyBlock, nil); err != nil {
+		return nil, nil, err
+	}
+	// without signing again, which the private key cannot do once it is encrypted
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	return publicBuf.Bytes(), privateBuf.Bytes(), nil
+}
+
+// gpgHome a gpg home directory of its own for the test
+func gpgHome(t *testing.T) string {
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("failed to create gpg home: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	return home
+}
+
+// gpg run gpg non-interactively with the home directory, with stdin as its input, returning its output
+func gpg(t *testing.T, home string, stdin []byte, args ...string) []byte {
+	args = append([]string{"--homedir", home, "--batch", "--yes", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)
+	cmd := exec.Command("gpg", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("gpg %s failed: %v; %s", strings.Join(args, " "), err, stderr.Bytes())
+	}
+	return stdout.Bytes()
+}
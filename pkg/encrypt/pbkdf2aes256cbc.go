@@ -36,6 +36,11 @@ func (s *PBKDF2AES256CBC) Description() string {
 	return "PBKDF2 with AES256-CBC encryption. Should work with `openssl enc -d -aes-256-cbc -pbkdf2 -pass <pass-encoding>`"
 }
 
+// KeyID empty, as a fast hash of a passphrase would make it easier to guess
+func (s *PBKDF2AES256CBC) KeyID() string {
+	return ""
+}
+
 func (s *PBKDF2AES256CBC) Decrypt(out io.Writer) (io.WriteCloser, error) {
 	// Return a WriteCloser that buffers the salt, derives the key, and then streams decryption
 	pr := &pbkdf2DecryptReader{
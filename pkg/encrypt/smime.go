@@ -2,30 +2,107 @@ package encrypt
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 
-	cms "github.com/InfiniteLoopSpace/go_S-MIME/cms"
+	asn "github.com/InfiniteLoopSpace/go_S-MIME/asn1"
+	"github.com/InfiniteLoopSpace/go_S-MIME/cms/protocol"
+	"github.com/InfiniteLoopSpace/go_S-MIME/oid"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 var _ Encryptor = &SMimeAES256CBC{}
 
 type SMimeAES256CBC struct {
 	recipientCert *x509.Certificate
+	// privateKey the key of the recipient, needed to decrypt only
+	privateKey crypto.PrivateKey
 }
 
-func NewSMimeAES256CBC(certPEM []byte) (*SMimeAES256CBC, error) {
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode recipient cert PEM")
+// NewSMimeAES256CBC an S/MIME encryptor for the recipient certificate in key, which is either PEM, or PKCS#12
+// protected by password. To decrypt, key must include the private key of the certificate as well: in PEM, as a
+// PKCS#1, PKCS#8 or EC private key, in any order with the certificate.
+func NewSMimeAES256CBC(key []byte, password string) (*SMimeAES256CBC, error) {
+	var (
+		cert       *x509.Certificate
+		privateKey crypto.PrivateKey
+		err        error
+	)
+	if block, _ := pem.Decode(key); block != nil {
+		cert, privateKey, err = parseSMimePEM(key)
+	} else {
+		privateKey, cert, _, err = pkcs12.DecodeChain(key, password)
+		if err != nil {
+			err = fmt.Errorf("key is neither PEM nor a PKCS#12 file with the given password: %w", err)
+		}
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("invalid recipient cert: %w", err)
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("no recipient certificate in key")
+	}
+	if privateKey != nil {
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+		}
+		if public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.PublicKey) {
+			return nil, fmt.Errorf("private key does not match the recipient certificate")
+		}
+	}
+	return &SMimeAES256CBC{recipientCert: cert, privateKey: privateKey}, nil
+}
+
+// parseSMimePEM the first certificate and private key in PEM data
+func parseSMimePEM(data []byte) (*x509.Certificate, crypto.PrivateKey, error) {
+	var (
+		cert       *x509.Certificate
+		privateKey crypto.PrivateKey
+		err        error
+	)
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert != nil {
+				continue
+			}
+			if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return nil, nil, fmt.Errorf("invalid recipient cert: %w", err)
+			}
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			if privateKey != nil {
+				continue
+			}
+			if privateKey, err = parseSMimePrivateKey(block); err != nil {
+				return nil, nil, fmt.Errorf("invalid private key: %w", err)
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, nil, fmt.Errorf("encrypted PEM private keys are not supported, use PKCS#12 with a password instead")
+		}
+	}
+	return cert, privateKey, nil
+}
+
+func parseSMimePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
 	}
-	return &SMimeAES256CBC{recipientCert: cert}, nil
 }
 
 func (s *SMimeAES256CBC) Name() string {
@@ -36,28 +113,145 @@ func (s *SMimeAES256CBC) Description() string {
 	return "SMIME with AES256-CBC encryption. Should work with `openssl smime -decrypt -inform DER -recip <cert.pem> -inkey <key.pem>`"
 }
 
+func (s *SMimeAES256CBC) KeyID() string {
+	return keyID(s.recipientCert.Raw)
+}
+
 func (s *SMimeAES256CBC) Decrypt(out io.Writer) (io.WriteCloser, error) {
-	return nil, fmt.Errorf("decrypt not implemented for SMIME")
+	if s.privateKey == nil {
+		return nil, fmt.Errorf("decrypting S/MIME needs the private key of the recipient as well as its certificate")
+	}
+	return newPipeDecryptWriter(func(in io.Reader) error {
+		return s.decrypt(in, out)
+	}), nil
 }
 
 func (s *SMimeAES256CBC) Encrypt(out io.Writer) (io.WriteCloser, error) {
-	buf := &bytes.Buffer{}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	header, err := smimeHeader(s.recipientCert, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write S/MIME header: %w", err)
+	}
+
 	return &streamingEncryptWriter{
-		recipient: s.recipientCert,
-		plaintext: buf,
-		out:       out,
+		cbc: cipher.NewCBCEncrypter(block, iv),
+		buf: make([]byte, 0, streamChunkSize),
+		out: out,
 	}, nil
 }
 
+// smimeHeader the start of the ContentInfo, up to the encrypted content, encoded in BER with indefinite lengths,
+// as `openssl cms -stream` does, so that the encrypted content can be written as it goes, without knowing its length
+func smimeHeader(recipient *x509.Certificate, key, iv []byte) ([]byte, error) {
+	// the library encodes the recipient info, for RSA or EC keys, and the version
+	rInfo, err := protocol.NewRecipientInfo(recipient, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key for recipient: %w", err)
+	}
+	algorithm := pkix.AlgorithmIdentifier{
+		Algorithm:  oid.EncryptionAlgorithmAES256CBC,
+		Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv},
+	}
+	eci := protocol.EncryptedContentInfo{EContentType: oid.Data, ContentEncryptionAlgorithm: algorithm}
+	ed := protocol.NewEnvelopedData(&eci, []protocol.RecipientInfo{rInfo})
+	edDER, err := asn.Marshal(ed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode S/MIME recipient: %w", err)
+	}
+	var edSeq asn1.RawValue
+	if _, err := asn1.Unmarshal(edDER, &edSeq); err != nil {
+		return nil, fmt.Errorf("failed to decode S/MIME recipient: %w", err)
+	}
+	var version, recipientInfos asn1.RawValue
+	rest, err := asn1.Unmarshal(edSeq.Bytes, &version)
+	if err == nil {
+		_, err = asn1.Unmarshal(rest, &recipientInfos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode S/MIME recipient: %w", err)
+	}
+
+	contentType, err := asn1.Marshal(oid.EnvelopedData)
+	if err != nil {
+		return nil, err
+	}
+	eContentType, err := asn1.Marshal(oid.Data)
+	if err != nil {
+		return nil, err
+	}
+	algorithmDER, err := asn1.Marshal(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var header []byte
+	// ContentInfo ::= SEQUENCE { contentType, [0] EXPLICIT content }
+	header = append(header, berSequence...)
+	header = append(header, contentType...)
+	header = append(header, berExplicit0...)
+	// EnvelopedData ::= SEQUENCE { version, recipientInfos, encryptedContentInfo }
+	header = append(header, berSequence...)
+	header = append(header, version.FullBytes...)
+	header = append(header, recipientInfos.FullBytes...)
+	// EncryptedContentInfo ::= SEQUENCE { contentType, contentEncryptionAlgorithm, [0] IMPLICIT encryptedContent }
+	header = append(header, berSequence...)
+	header = append(header, eContentType...)
+	header = append(header, algorithmDER...)
+	// encryptedContent, a constructed OCTET STRING, of one OCTET STRING for each chunk
+	header = append(header, berImplicit0...)
+	return header, nil
+}
+
+var (
+	// the starts of BER elements with indefinite lengths, each of which ends with berEnd
+	berSequence  = []byte{0x30, 0x80}
+	berExplicit0 = []byte{0xa0, 0x80}
+	berImplicit0 = []byte{0xa0, 0x80}
+	berEnd       = []byte{0x00, 0x00}
+	// smimeOpenElements how many elements the header leaves open
+	smimeOpenElements = 5
+)
+
+// streamingEncryptWriter encrypt with AES-256-CBC, writing each chunk as it fills as an OCTET STRING
+// of the encrypted content, so that it needs memory for one chunk only
 type streamingEncryptWriter struct {
-	recipient *x509.Certificate
-	plaintext *bytes.Buffer
-	out       io.Writer
-	closed    bool
+	cbc    cipher.BlockMode
+	buf    []byte
+	out    io.Writer
+	closed bool
 }
 
 func (w *streamingEncryptWriter) Write(p []byte) (int, error) {
-	return w.plaintext.Write(p)
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == streamChunkSize {
+			if err := w.writeChunk(); err != nil {
+				return n - len(p), err
+			}
+		}
+		copied := copy(w.buf[len(w.buf):streamChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+	}
+	return n, nil
 }
 
 func (w *streamingEncryptWriter) Close() error {
@@ -66,18 +260,232 @@ func (w *streamingEncryptWriter) Close() error {
 	}
 	w.closed = true
 
-	// Create a new S/MIME wrapper
-	s, err := cms.New()
+	// PKCS#7 padding, a whole block of it if the plaintext is a multiple of the block size
+	padding := aes.BlockSize - len(w.buf)%aes.BlockSize
+	w.buf = append(w.buf, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	if err := w.writeChunk(); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(bytes.Repeat(berEnd, smimeOpenElements)); err != nil {
+		return fmt.Errorf("failed to write S/MIME trailer: %w", err)
+	}
+	return nil
+}
+
+func (w *streamingEncryptWriter) writeChunk() error {
+	w.cbc.CryptBlocks(w.buf, w.buf)
+	chunk, err := asn1.Marshal(w.buf)
+	if err != nil {
+		return fmt.Errorf("failed to encode encrypted chunk: %w", err)
+	}
+	if _, err := w.out.Write(chunk); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// decrypt read CMS EnvelopedData from in, in DER or BER, and write the decrypted content to out as it is read,
+// so that only the recipient infos are held in memory
+func (s *SMimeAES256CBC) decrypt(in io.Reader, out io.Writer) error {
+	b := newBERReader(in)
+	// ContentInfo ::= SEQUENCE { contentType, [0] EXPLICIT content }
+	if err := b.expect(0x30, "ContentInfo"); err != nil {
+		return err
+	}
+	contentType, err := b.readOID()
+	if err != nil {
+		return fmt.Errorf("invalid S/MIME content type: %w", err)
+	}
+	if !contentType.Equal(oid.EnvelopedData) {
+		return fmt.Errorf("S/MIME content type is %s, not enveloped data", contentType)
+	}
+	if err := b.expect(0xa0, "content"); err != nil {
+		return err
+	}
+	// EnvelopedData ::= SEQUENCE { version, [0] IMPLICIT originatorInfo OPTIONAL, recipientInfos, encryptedContentInfo, ... }
+	if err := b.expect(0x30, "EnvelopedData"); err != nil {
+		return err
+	}
+	if _, _, err := b.readElement(); err != nil {
+		return fmt.Errorf("invalid S/MIME version: %w", err)
+	}
+	recipientInfos, h, err := b.readElement()
+	if err == nil && h.tag == 0xa0 {
+		recipientInfos, h, err = b.readElement()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid S/MIME recipient infos: %w", err)
+	}
+	if h.tag != 0x31 {
+		return fmt.Errorf("invalid S/MIME recipient infos: unexpected tag 0x%x", h.tag)
+	}
+	key, err := s.contentKey(recipientInfos)
 	if err != nil {
-		return fmt.Errorf("failed to create smime instance: %w", err)
+		return err
 	}
 
-	// Encrypt the buffered plaintext using AES-256-CBC and wrap in CMS
-	der, err := s.Encrypt(w.plaintext.Bytes(), []*x509.Certificate{w.recipient})
+	// EncryptedContentInfo ::= SEQUENCE { contentType, contentEncryptionAlgorithm, [0] IMPLICIT encryptedContent }
+	if err := b.expect(0x30, "EncryptedContentInfo"); err != nil {
+		return err
+	}
+	if _, err := b.readOID(); err != nil {
+		return fmt.Errorf("invalid S/MIME encrypted content type: %w", err)
+	}
+	algorithmDER, _, err := b.readElement()
 	if err != nil {
-		return fmt.Errorf("S/MIME encryption failed: %w", err)
+		return fmt.Errorf("invalid S/MIME content encryption algorithm: %w", err)
+	}
+	var algorithm pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(algorithmDER, &algorithm); err != nil {
+		return fmt.Errorf("invalid S/MIME content encryption algorithm: %w", err)
 	}
+	keySize, ok := smimeAESKeySizes[algorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported S/MIME content encryption algorithm %s", algorithm.Algorithm)
+	}
+	if len(key) != keySize {
+		return fmt.Errorf("S/MIME content key has length %d, not %d for %s", len(key), keySize, algorithm.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return fmt.Errorf("invalid S/MIME content encryption IV")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	// the encrypted content is either one primitive string, as DER has it, or a constructed one of many chunks
+	h, err = b.readHeader()
+	if err != nil {
+		return fmt.Errorf("missing S/MIME encrypted content: %w", unexpectedEOF(err))
+	}
+	if h.tag&^0x20 != 0x80 {
+		return fmt.Errorf("missing S/MIME encrypted content: unexpected tag 0x%x", h.tag)
+	}
+	w := &smimeDecryptWriter{cbc: cipher.NewCBCDecrypter(block, iv), out: out}
+	if _, err := b.readOctets(h, w.write); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	// discard the rest, i.e. the ends of the open elements and any attributes, so that the writer never blocks
+	if _, err := io.Copy(io.Discard, b.r); err != nil {
+		return err
+	}
+	return nil
+}
 
-	_, err = w.out.Write(der)
-	return err
+// smimeAESKeySizes the key size of each content encryption algorithm that can be decrypted: AES-256-CBC,
+// and AES-128-CBC, which backups encrypted before S/MIME encryption was streamed used
+var smimeAESKeySizes = map[string]int{
+	oid.EncryptionAlgorithmAES128CBC.String(): 16,
+	oid.EncryptionAlgorithmAES256CBC.String(): 32,
+}
+
+// keyTransRecipientInfo KeyTransRecipientInfo, for recipients with RSA keys, which is how the content key
+// is encrypted for them
+type keyTransRecipientInfo struct {
+	Version                int
+	Rid                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// contentKey decrypt the content key from the recipient info for the certificate
+func (s *SMimeAES256CBC) contentKey(recipientInfos []byte) ([]byte, error) {
+	var infos []asn1.RawValue
+	if _, err := asn1.UnmarshalWithParams(recipientInfos, &infos, "set"); err != nil {
+		return nil, fmt.Errorf("invalid S/MIME recipient infos: %w", err)
+	}
+	for _, info := range infos {
+		// other kinds of recipient info are context tagged; key transport is the only one that is a plain SEQUENCE
+		if info.Class != asn1.ClassUniversal || info.Tag != asn1.TagSequence {
+			continue
+		}
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(info.FullBytes, &ktri); err != nil {
+			return nil, fmt.Errorf("invalid S/MIME recipient info: %w", err)
+		}
+		if !s.isRecipient(ktri.Rid) {
+			continue
+		}
+		if !ktri.KeyEncryptionAlgorithm.Algorithm.Equal(oid.EncryptionAlgorithmRSA) {
+			return nil, fmt.Errorf("unsupported S/MIME key encryption algorithm %s", ktri.KeyEncryptionAlgorithm.Algorithm)
+		}
+		privateKey, ok := s.privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("S/MIME key encryption is RSA, but the private key is not")
+		}
+		key, err := rsa.DecryptPKCS1v15(nil, privateKey, ktri.EncryptedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt S/MIME content key: %w", err)
+		}
+		return key, nil
+	}
+	if _, ok := s.privateKey.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("S/MIME decryption supports RSA keys only")
+	}
+	return nil, fmt.Errorf("S/MIME file is not encrypted for the recipient certificate")
+}
+
+// isRecipient whether the recipient identifier, either the issuer and serial number or the subject key identifier,
+// is of the certificate
+func (s *SMimeAES256CBC) isRecipient(rid asn1.RawValue) bool {
+	cert := s.recipientCert
+	if rid.Class == asn1.ClassContextSpecific && rid.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(rid.Bytes, cert.SubjectKeyId)
+	}
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(rid.FullBytes, &ias); err != nil {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// smimeDecryptWriter decrypt with CBC as the encrypted content is read, holding back the last block,
+// whose padding is removed when closed
+type smimeDecryptWriter struct {
+	cbc cipher.BlockMode
+	out io.Writer
+	buf []byte
+}
+
+func (w *smimeDecryptWriter) write(p []byte) error {
+	w.buf = append(w.buf, p...)
+	n := len(w.buf) - len(w.buf)%aes.BlockSize
+	if n == len(w.buf) {
+		n -= aes.BlockSize
+	}
+	if n <= 0 {
+		return nil
+	}
+	w.cbc.CryptBlocks(w.buf[:n], w.buf[:n])
+	if _, err := w.out.Write(w.buf[:n]); err != nil {
+		return fmt.Errorf("failed to write decrypted content: %w", err)
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	return nil
+}
+
+func (w *smimeDecryptWriter) close() error {
+	if len(w.buf) != aes.BlockSize {
+		return fmt.Errorf("S/MIME encrypted content is not a whole number of blocks, it may be truncated")
+	}
+	w.cbc.CryptBlocks(w.buf, w.buf)
+	padding := int(w.buf[len(w.buf)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(w.buf[aes.BlockSize-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return fmt.Errorf("invalid S/MIME padding, the key may be wrong or the encrypted file truncated or modified")
+	}
+	if _, err := w.out.Write(w.buf[:aes.BlockSize-padding]); err != nil {
+		return fmt.Errorf("failed to write decrypted content: %w", err)
+	}
+	return nil
 }
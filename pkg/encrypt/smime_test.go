@@ -0,0 +1,166 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/InfiniteLoopSpace/go_S-MIME/cms"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestSMimeDecrypt(t *testing.T) {
+	cleartext, err := generateRandomCleartext(2*streamChunkSize + 1000)
+	if err != nil {
+		t.Fatalf("failed to generate cleartext: %v", err)
+	}
+	certPEM, keyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("failed to generate self-signed cert: %v", err)
+	}
+	otherCertPEM, otherKeyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("failed to generate self-signed cert: %v", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse cert: %v", err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	privateKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	pfx, err := pkcs12.Modern.Encode(privateKey, cert, nil, "secret")
+	if err != nil {
+		t.Fatalf("failed to encode PKCS#12: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to encode PKCS#8: %v", err)
+	}
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+
+	encrypt := func(t *testing.T) []byte {
+		encryptor, err := NewSMimeAES256CBC(certPEM, "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		var encrypted bytes.Buffer
+		w, err := encryptor.Encrypt(&encrypted)
+		if err != nil {
+			t.Fatalf("Encrypt setup failed: %v", err)
+		}
+		if err := writeAll(w, cleartext, len(cleartext)); err != nil {
+			t.Fatalf("encryption failed: %v", err)
+		}
+		return encrypted.Bytes()
+	}
+	// legacyEncrypt encrypted in DER with definite lengths and AES-128-CBC, as backups were before streaming
+	legacyEncrypt := func(t *testing.T) []byte {
+		s, err := cms.New()
+		if err != nil {
+			t.Fatalf("failed to create CMS: %v", err)
+		}
+		der, err := s.Encrypt(cleartext, []*x509.Certificate{cert})
+		if err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+		return der
+	}
+	opensslEncrypt := func(t *testing.T) []byte {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "cert.pem")
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			t.Fatalf("failed to write cert: %v", err)
+		}
+		cmd := exec.Command("openssl", "cms", "-encrypt", "-aes256", "-binary", "-stream", "-outform", "DER", certFile)
+		var encrypted, stderr bytes.Buffer
+		cmd.Stdin = bytes.NewReader(cleartext)
+		cmd.Stdout = &encrypted
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("OpenSSL failed to encrypt: %v; %s", err, stderr.Bytes())
+		}
+		return encrypted.Bytes()
+	}
+	// hugeRecipientInfos enveloped data whose recipient infos claim to be 2^56 octets long
+	hugeRecipientInfos := func(t *testing.T) []byte {
+		return []byte("\x30\x80\x06\x09\x2a\x86\x48\x86\xf7\x0d\x01\x07\x03\xa0\x80\x30\x80\x02\x01\x00\x31\x87\x7f\xff\xff\xff\xff\xff\xff")
+	}
+
+	tests := []struct {
+		name     string
+		encrypt  func(t *testing.T) []byte
+		key      []byte
+		password string
+		keyErr   string
+		err      string
+	}{
+		{"PEM cert and key", encrypt, append(append([]byte{}, certPEM...), keyPEM...), "", "", ""},
+		{"PEM PKCS#8 key before cert", encrypt, append(append([]byte{}, pkcs8PEM...), certPEM...), "", "", ""},
+		{"PKCS#12", encrypt, pfx, "secret", "", ""},
+		{"PKCS#12 wrong password", encrypt, pfx, "wrong", "neither PEM nor a PKCS#12 file", ""},
+		{"legacy DER", legacyEncrypt, append(append([]byte{}, certPEM...), keyPEM...), "", "", ""},
+		{"openssl", opensslEncrypt, append(append([]byte{}, certPEM...), keyPEM...), "", "", ""},
+		{"cert only", encrypt, certPEM, "", "", "needs the private key"},
+		{"key does not match cert", encrypt, append(append([]byte{}, certPEM...), otherKeyPEM...), "", "does not match", ""},
+		{"recipient infos too long", hugeRecipientInfos, append(append([]byte{}, certPEM...), keyPEM...), "", "", "invalid S/MIME recipient infos: BER element of 36028797018963967 octets is longer than 1048576 octets"},
+		{"other key with the same issuer and serial", encrypt, append(append([]byte{}, otherCertPEM...), otherKeyPEM...), "", "", "failed to decrypt S/MIME content key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encryptor, err := NewSMimeAES256CBC(tt.key, tt.password)
+			if tt.keyErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.keyErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.keyErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create encryptor: %v", err)
+			}
+			encrypted := tt.encrypt(t)
+			var decrypted bytes.Buffer
+			w, err := encryptor.Decrypt(&decrypted)
+			if err == nil {
+				// small writes, so that elements are split across them
+				err = writeAll(w, encrypted, 1000)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decryption failed: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), cleartext) {
+				t.Error("decrypted output does not match original cleartext")
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		encryptor, err := NewSMimeAES256CBC(append(append([]byte{}, certPEM...), keyPEM...), "")
+		if err != nil {
+			t.Fatalf("failed to create encryptor: %v", err)
+		}
+		encrypted := encrypt(t)
+		w, err := encryptor.Decrypt(&bytes.Buffer{})
+		if err != nil {
+			t.Fatalf("Decrypt setup failed: %v", err)
+		}
+		_, _ = w.Write(encrypted[:len(encrypted)-streamChunkSize/2])
+		if err := w.Close(); err == nil {
+			t.Error("expected an error decrypting a truncated file")
+		}
+	})
+}
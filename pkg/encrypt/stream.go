@@ -0,0 +1,178 @@
+package encrypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// streamChunkSize the size of the plaintext of each chunk of a stream, other than the last
+	streamChunkSize = 64 * 1024
+)
+
+// streamNonce the nonce for a chunk of a stream, per the STREAM construction: the big-endian counter of the chunk,
+// followed by a byte that is 1 for the last chunk and 0 otherwise. Because every chunk has its own nonce, chunks
+// cannot be reordered, and because the last one is marked, the stream cannot be truncated, without failing to open.
+func streamNonce(size int, counter uint64, last bool) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-9:size-1], counter)
+	if last {
+		nonce[size-1] = 1
+	}
+	return nonce
+}
+
+// streamEncryptWriter encrypt a stream in chunks of streamChunkSize, so that it needs memory for one chunk only.
+// The key of the AEAD must be unique to the stream, since the nonces are the same for every stream.
+type streamEncryptWriter struct {
+	aead    cipher.AEAD
+	out     io.Writer
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newStreamEncryptWriter(aead cipher.AEAD, out io.Writer) *streamEncryptWriter {
+	return &streamEncryptWriter{
+		aead: aead,
+		out:  out,
+		buf:  make([]byte, 0, streamChunkSize),
+	}
+}
+
+func (w *streamEncryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// a full chunk is sealed only once there is more data, as until then it might be the last one
+		if len(w.buf) == streamChunkSize {
+			if err := w.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		copied := copy(w.buf[len(w.buf):streamChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+	}
+	return n, nil
+}
+
+func (w *streamEncryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *streamEncryptWriter) seal(last bool) error {
+	ciphertext := w.aead.Seal(nil, streamNonce(w.aead.NonceSize(), w.counter, last), w.buf, nil)
+	if _, err := w.out.Write(ciphertext); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// streamDecryptWriter decrypt a stream written by streamEncryptWriter, writing the plaintext of each chunk
+// as soon as it is authenticated
+type streamDecryptWriter struct {
+	aead    cipher.AEAD
+	out     io.Writer
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newStreamDecryptWriter(aead cipher.AEAD, out io.Writer) *streamDecryptWriter {
+	return &streamDecryptWriter{
+		aead: aead,
+		out:  out,
+		buf:  make([]byte, 0, streamChunkSize+aead.Overhead()),
+	}
+}
+
+func (w *streamDecryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	chunkSize := streamChunkSize + w.aead.Overhead()
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == chunkSize {
+			if err := w.open(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		copied := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+	}
+	return n, nil
+}
+
+func (w *streamDecryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.open(true)
+}
+
+func (w *streamDecryptWriter) open(last bool) error {
+	plaintext, err := w.aead.Open(w.buf[:0], streamNonce(w.aead.NonceSize(), w.counter, last), w.buf, nil)
+	if err != nil {
+		if last {
+			return fmt.Errorf("decryption failed at chunk %d, the encrypted file may be truncated or modified: %w", w.counter, err)
+		}
+		return fmt.Errorf("decryption failed at chunk %d: %w", w.counter, err)
+	}
+	if _, err := w.out.Write(plaintext); err != nil {
+		return fmt.Errorf("failed to write decrypted chunk: %w", err)
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// pipeDecryptWriter a writer for decryption that reads its input, e.g. for a library that decrypts from a reader:
+// what is written is piped to decrypt, running in its own goroutine, and Close returns its error
+type pipeDecryptWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+}
+
+func newPipeDecryptWriter(decrypt func(in io.Reader) error) *pipeDecryptWriter {
+	pr, pw := io.Pipe()
+	w := &pipeDecryptWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := decrypt(pr)
+		// unblock any further writes, e.g. after a failure
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *pipeDecryptWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *pipeDecryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	_ = w.pw.Close()
+	return <-w.done
+}
@@ -0,0 +1,127 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// writeAll write the data to the writer in pieces of the given size, then close it
+func writeAll(w io.WriteCloser, data []byte, size int) error {
+	for len(data) > 0 {
+		n := min(size, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return w.Close()
+}
+
+func TestChacha20Poly1305Stream(t *testing.T) {
+	key, err := generateRandomKey(chacha20poly1305.KeySize)
+	require.NoError(t, err)
+	enc, err := NewChacha20Poly1305(key)
+	require.NoError(t, err)
+
+	encrypt := func(t *testing.T, data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := enc.Encrypt(&buf)
+		require.NoError(t, err)
+		require.NoError(t, writeAll(w, data, 1000))
+		return buf.Bytes()
+	}
+	decrypt := func(ciphertext []byte, size int) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := enc.Decrypt(&buf)
+		if err != nil {
+			return nil, err
+		}
+		err = writeAll(w, ciphertext, size)
+		return buf.Bytes(), err
+	}
+
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		ciphertext := encrypt(t, data)
+		// in one piece, and in pieces smaller than the header
+		for _, piece := range []int{len(ciphertext), 7} {
+			decrypted, err := decrypt(ciphertext, piece)
+			require.NoError(t, err, "size %d", size)
+			assert.True(t, bytes.Equal(data, decrypted), "size %d", size)
+		}
+	}
+
+	data := bytes.Repeat([]byte("abcdefgh"), streamChunkSize)
+	ciphertext := encrypt(t, data)
+	chunk := streamChunkSize + chacha20poly1305.Overhead
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := decrypt(ciphertext[:chacha20HeaderSize+2*chunk], len(ciphertext))
+		assert.ErrorContains(t, err, "truncated")
+	})
+	t.Run("modified", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[chacha20HeaderSize+chunk+10] ^= 1
+		_, err := decrypt(modified, len(modified))
+		assert.ErrorContains(t, err, "decryption failed at chunk 1")
+	})
+	t.Run("reordered", func(t *testing.T) {
+		reordered := bytes.Clone(ciphertext[:chacha20HeaderSize])
+		reordered = append(reordered, ciphertext[chacha20HeaderSize+chunk:chacha20HeaderSize+2*chunk]...)
+		reordered = append(reordered, ciphertext[chacha20HeaderSize:chacha20HeaderSize+chunk]...)
+		reordered = append(reordered, ciphertext[chacha20HeaderSize+2*chunk:]...)
+		_, err := decrypt(reordered, len(reordered))
+		assert.ErrorContains(t, err, "decryption failed at chunk 0")
+	})
+	t.Run("unknown version", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[len(chacha20Magic)] = 2
+		_, err := decrypt(modified, len(modified))
+		assert.ErrorContains(t, err, "unsupported chacha20poly1305 format version 2")
+	})
+	t.Run("legacy", func(t *testing.T) {
+		// a nonce followed by the whole archive sealed at once, as written before the chunked format
+		aead, err := chacha20poly1305.New(key)
+		require.NoError(t, err)
+		nonce := make([]byte, chacha20poly1305.NonceSize)
+		_, err = rand.Read(nonce)
+		require.NoError(t, err)
+		legacy := aead.Seal(bytes.Clone(nonce), nonce, data, nil)
+		decrypted, err := decrypt(legacy, 1000)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(data, decrypted))
+	})
+}
+
+func TestAgeDecrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	enc, err := NewAgeChacha20Poly1305([]byte(identity.String() + "\n"))
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("abcdefgh"), streamChunkSize)
+	var encrypted bytes.Buffer
+	w, err := enc.Encrypt(&encrypted)
+	require.NoError(t, err)
+	require.NoError(t, writeAll(w, data, 1000))
+
+	var decrypted bytes.Buffer
+	w, err = enc.Decrypt(&decrypted)
+	require.NoError(t, err)
+	require.NoError(t, writeAll(w, encrypted.Bytes(), 1000))
+	assert.True(t, bytes.Equal(data, decrypted.Bytes()))
+
+	// a truncated file fails, rather than silently restoring part of it
+	w, err = enc.Decrypt(io.Discard)
+	require.NoError(t, err)
+	assert.Error(t, writeAll(w, encrypted.Bytes()[:encrypted.Len()/2], 1000))
+}
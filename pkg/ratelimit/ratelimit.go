@@ -0,0 +1,156 @@
+// Package ratelimit limits the rate of reading and writing data, using token buckets whose rates follow a Schedule.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter a token bucket limiting the rate of data, shared by all of the readers and writers that it wraps.
+// The methods of a nil Limiter do nothing, so that callers need not check whether there is a limit.
+type Limiter struct {
+	schedule Schedule
+	now      func() time.Time
+
+	mu      sync.Mutex
+	current int64
+	// limiter the token bucket for the current rate, nil when unlimited
+	limiter *rate.Limiter
+}
+
+// New create a limiter following the schedule, or nil if the schedule is unlimited
+func New(schedule Schedule) *Limiter {
+	if schedule.IsZero() {
+		return nil
+	}
+	return &Limiter{schedule: schedule, now: time.Now}
+}
+
+// Wait block until n bytes are allowed at the current rate, or the context is done
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	bps := l.schedule.RateAt(l.now())
+	l.mu.Lock()
+	if bps != l.current {
+		// a new bucket, full, with a burst of a second's worth, so that the rate evens out quickly after changes
+		l.limiter = nil
+		if bps > 0 {
+			l.limiter = rate.NewLimiter(rate.Limit(bps), int(bps))
+		}
+		l.current = bps
+	}
+	limiter := l.limiter
+	l.mu.Unlock()
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// Reader wrap a reader, so that it reads no faster than the limit
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+// Writer wrap a writer, so that it writes no faster than the limit
+func (l *Limiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, l: l}
+}
+
+// WriterAt wrap a writer at offsets, e.g. for concurrent downloads, so that it writes no faster than the limit
+func (l *Limiter) WriterAt(ctx context.Context, w io.WriterAt) io.WriterAt {
+	if l == nil {
+		return w
+	}
+	return &writerAt{ctx: ctx, w: w, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.Wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.l.Wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+type writerAt struct {
+	ctx context.Context
+	w   io.WriterAt
+	l   *Limiter
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	if err := w.l.Wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.WriteAt(p, off)
+}
+
+type contextKey string
+
+const (
+	uploadKey   contextKey = "mysql-backup-upload-limiter"
+	downloadKey contextKey = "mysql-backup-download-limiter"
+)
+
+// ContextWithUpload add the limiter for uploads to storage to the context
+func ContextWithUpload(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, uploadKey, l)
+}
+
+// Upload the limiter for uploads to storage, or nil if there is none
+func Upload(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(uploadKey).(*Limiter)
+	return l
+}
+
+// ContextWithDownload add the limiter for downloads from storage to the context
+func ContextWithDownload(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, downloadKey, l)
+}
+
+// Download the limiter for downloads from storage, or nil if there is none
+func Download(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(downloadKey).(*Limiter)
+	return l
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Schedule
+		err      string
+	}{
+		{"empty", "", Schedule{}, ""},
+		{"bytes", "1000", Schedule{Default: 1000}, ""},
+		{"suffixes", "2k", Schedule{Default: 2 << 10}, ""},
+		{"unlimited", "0", Schedule{}, ""},
+		{"window and default", "08:00-18:00=1M, 1G", Schedule{Default: 1 << 30, Windows: []Window{{Start: 8 * time.Hour, End: 18 * time.Hour, Rate: 1 << 20}}}, ""},
+		{"overnight window", "22:30-06:00=0", Schedule{Windows: []Window{{Start: 22*time.Hour + 30*time.Minute, End: 6 * time.Hour}}}, ""},
+		{"invalid rate", "fast", Schedule{}, "invalid rate"},
+		{"negative rate", "-1", Schedule{}, "invalid rate"},
+		{"two defaults", "1M,2M", Schedule{}, "more than one default"},
+		{"invalid window", "08:00=1M", Schedule{}, "invalid window"},
+		{"invalid time", "08:00-25:00=1M", Schedule{}, "invalid time of day"},
+		{"empty window", "08:00-08:00=1M", Schedule{}, "start and end are the same"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.input)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, schedule)
+		})
+	}
+}
+
+func TestRateAt(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:00=100,22:00-06:00=0,50")
+	require.NoError(t, err)
+	tests := []struct {
+		at       string
+		expected int64
+	}{
+		{"07:59", 50},
+		{"08:00", 100},
+		{"17:59", 100},
+		{"18:00", 50},
+		{"23:00", 0},
+		{"00:00", 0},
+		{"06:00", 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.at, func(t *testing.T) {
+			at, err := time.Parse("15:04", tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, schedule.RateAt(at))
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		var l *Limiter = New(Schedule{})
+		assert.Nil(t, l)
+		var buf bytes.Buffer
+		w := l.Writer(context.Background(), &buf)
+		assert.Equal(t, &buf, w)
+		assert.NoError(t, l.Wait(context.Background(), 1<<30))
+	})
+	t.Run("limited", func(t *testing.T) {
+		// the first second's worth is the burst, so two seconds' worth takes one second
+		const rate = 1000
+		data := bytes.Repeat([]byte("a"), 2*rate)
+
+		var out bytes.Buffer
+		start := time.Now()
+		_, err := io.Copy(New(Schedule{Default: rate}).Writer(context.Background(), &out), bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+		assert.Equal(t, data, out.Bytes())
+
+		start = time.Now()
+		read, err := io.ReadAll(New(Schedule{Default: rate}).Reader(context.Background(), bytes.NewReader(data)))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+		assert.Equal(t, data, read)
+	})
+	t.Run("schedule changes", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+		schedule, err := ParseSchedule("08:00-09:00=10")
+		require.NoError(t, err)
+		l := New(schedule)
+		l.now = func() time.Time { return now }
+		// unlimited outside of the window
+		start := time.Now()
+		require.NoError(t, l.Wait(context.Background(), 1<<20))
+		assert.Less(t, time.Since(start), time.Second)
+		// limited inside of it, until the context is done
+		now = now.Add(time.Hour)
+		require.NoError(t, l.Wait(context.Background(), 10))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Error(t, l.Wait(ctx, 10))
+	})
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule rate limits in bytes per second, by time of day. The zero value is unlimited.
+type Schedule struct {
+	// Default the rate outside of all of the windows; 0 for unlimited
+	Default int64
+	// Windows the rates for particular times of day; the first that matches applies
+	Windows []Window
+}
+
+// Window a rate limit for a part of each day
+type Window struct {
+	// Start, End time of day, as the time since midnight. If End is before Start, the window wraps past midnight.
+	Start, End time.Duration
+	// Rate bytes per second; 0 for unlimited
+	Rate int64
+}
+
+// IsZero whether the schedule is unlimited at all times
+func (s Schedule) IsZero() bool {
+	if s.Default > 0 {
+		return false
+	}
+	for _, w := range s.Windows {
+		if w.Rate > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RateAt the rate limit at a particular time, in its own location; 0 for unlimited
+func (s Schedule) RateAt(t time.Time) int64 {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, w := range s.Windows {
+		if w.contains(tod) {
+			return w.Rate
+		}
+	}
+	return s.Default
+}
+
+func (w Window) contains(tod time.Duration) bool {
+	if w.Start <= w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// ParseSchedule parse a rate limit schedule: a comma-separated list of rates, each either for a window
+// of the day, as `HH:MM-HH:MM=<rate>`, or the default outside of all windows, as `<rate>`.
+// A rate is bytes per second, with an optional suffix K, M or G for KiB, MiB or GiB, and 0 is unlimited.
+// For example, `08:00-18:00=1M,10M` is 1 MiB/s during office hours, and 10 MiB/s the rest of the time.
+// An empty string is unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	if strings.TrimSpace(s) == "" {
+		return schedule, nil
+	}
+	var hasDefault bool
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		window, rateStr, isWindow := strings.Cut(entry, "=")
+		if !isWindow {
+			if hasDefault {
+				return schedule, fmt.Errorf("more than one default rate in %q", s)
+			}
+			rate, err := ParseRate(entry)
+			if err != nil {
+				return schedule, err
+			}
+			schedule.Default, hasDefault = rate, true
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(window, "-")
+		if !ok {
+			return schedule, fmt.Errorf("invalid window %q, must be HH:MM-HH:MM", window)
+		}
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return schedule, err
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return schedule, err
+		}
+		if start == end {
+			return schedule, fmt.Errorf("invalid window %q, start and end are the same", window)
+		}
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Windows = append(schedule.Windows, Window{Start: start, End: end, Rate: rate})
+	}
+	return schedule, nil
+}
+
+// ParseRate parse a rate in bytes per second, with an optional suffix K, M or G for KiB, MiB or GiB
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	rate, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid rate %q, must be a non-negative number of bytes per second, optionally followed by K, M or G", s)
+	}
+	return rate * multiplier, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,19 @@
+package remote
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// GenerateCredentials create new credentials to authenticate to a remote server and decrypt the configuration
+// it sends, as the base64-encoded Curve25519 private key that Connection.Credentials takes, and the
+// base64-encoded Curve25519 public key that encrypted configuration is encrypted to.
+func GenerateCredentials() (credentials, publicKey string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
@@ -1,6 +1,7 @@
 package remote
 
 import (
+	"crypto/ecdh"
 	"crypto/ed25519"
 	cryptorand "crypto/rand"
 	"crypto/x509"
@@ -124,3 +125,25 @@ func TestOpenConnection(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateCredentials(t *testing.T) {
+	credentials, publicKey, err := GenerateCredentials()
+	if err != nil {
+		t.Fatalf("failed to generate credentials: %v", err)
+	}
+	// the credentials work for a connection
+	if _, err := GetTLSConfig("example.com", nil, credentials); err != nil {
+		t.Fatalf("credentials are not usable: %v", err)
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		t.Fatalf("failed to decode credentials: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(keyBytes)
+	if err != nil {
+		t.Fatalf("credentials are not a Curve25519 key: %v", err)
+	}
+	if got := base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()); got != publicKey {
+		t.Errorf("public key %s does not match the credentials, expected %s", publicKey, got)
+	}
+}
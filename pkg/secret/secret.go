@@ -0,0 +1,122 @@
+// Package secret resolves secrets, such as passwords and keys, that are given as a reference to where the
+// secret is, rather than as the secret itself.
+//
+// A reference is one of:
+//
+//   - file:<path> the contents of the file, without leading and trailing whitespace
+//   - env:<name> the value of the environment variable
+//   - exec:<command> [args...] the output of the command, without leading and trailing whitespace. The command
+//     and its arguments are split on whitespace, and run directly, not in a shell.
+//
+// Anything else is the secret itself. A secret that starts with one of these prefixes is given with the prefix
+// literal:, which is removed, e.g. literal:env:abc for the secret env:abc.
+//
+// References from a remote source, such as a remote configuration, must be rejected with CheckLocal, since
+// whoever controls the source could otherwise read local files and run local commands.
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// reference prefixes
+const (
+	FilePrefix = "file:"
+	EnvPrefix  = "env:"
+	ExecPrefix = "exec:"
+	// LiteralPrefix the start of a secret that is given as is, for secrets that start with a reference prefix
+	LiteralPrefix = "literal:"
+)
+
+// IsReference whether the value is a reference to a secret, rather than the secret itself
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, FilePrefix) || strings.HasPrefix(value, EnvPrefix) || strings.HasPrefix(value, ExecPrefix)
+}
+
+// CheckLocal return an error if the value is a reference, for values from a remote source, which must not
+// resolve local secrets. The error describes the reference, never the value.
+func CheckLocal(value string) error {
+	if !IsReference(value) {
+		return nil
+	}
+	prefix, _, _ := strings.Cut(value, ":")
+	return fmt.Errorf("secret reference %s: is only allowed in local configuration; use %s for a secret that starts with it", prefix, LiteralPrefix)
+}
+
+// Resolve get the secret that the value references, or the value itself if it is not a reference.
+// Errors describe the reference, never the secret.
+func Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, LiteralPrefix):
+		return strings.TrimPrefix(value, LiteralPrefix), nil
+	case strings.HasPrefix(value, FilePrefix):
+		filename := strings.TrimPrefix(value, FilePrefix)
+		if filename == "" {
+			return "", fmt.Errorf("secret reference %q has no file", value)
+		}
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret from file %s: %w", filename, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	case strings.HasPrefix(value, EnvPrefix):
+		name := strings.TrimPrefix(value, EnvPrefix)
+		if name == "" {
+			return "", fmt.Errorf("secret reference %q has no environment variable", value)
+		}
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s for secret is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, ExecPrefix):
+		args := strings.Fields(strings.TrimPrefix(value, ExecPrefix))
+		if len(args) == 0 {
+			return "", fmt.Errorf("secret reference %q has no command", value)
+		}
+		var stdout bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = &stdout
+		// errors from the command go to the user, but its output, the secret, never does
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to run command %s for secret: %w", args[0], err)
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	default:
+		return value, nil
+	}
+}
+
+// Lazy a secret, or a reference to one, that is resolved when it is first needed, and only once, so that
+// a command runs once however often the secret is used. A nil Lazy is the empty secret.
+type Lazy struct {
+	value    string
+	once     sync.Once
+	resolved string
+	err      error
+}
+
+// NewLazy a secret for the value, which is resolved on the first Get; nil for the empty value
+func NewLazy(value string) *Lazy {
+	if value == "" {
+		return nil
+	}
+	return &Lazy{value: value}
+}
+
+// Get the secret, resolving the reference the first time
+func (l *Lazy) Get() (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	l.once.Do(func() {
+		l.resolved, l.err = Resolve(l.value)
+	})
+	return l.resolved, l.err
+}
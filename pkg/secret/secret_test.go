@@ -0,0 +1,93 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+	t.Setenv("SECRET_TEST_VALUE", "from-env")
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      string
+	}{
+		{"literal", "plain-password", "plain-password", ""},
+		{"empty", "", "", ""},
+		{"file", "file:" + secretFile, "from-file", ""},
+		{"missing file", "file:" + filepath.Join(dir, "missing"), "", "failed to read secret from file"},
+		{"no file", "file:", "", "has no file"},
+		{"env", "env:SECRET_TEST_VALUE", "from-env", ""},
+		{"unset env", "env:SECRET_TEST_UNSET", "", "SECRET_TEST_UNSET for secret is not set"},
+		{"no env", "env:", "", "has no environment variable"},
+		{"exec", "exec:echo  from-exec ", "from-exec", ""},
+		{"exec args", "exec:cat " + secretFile, "from-file", ""},
+		{"exec fails", "exec:false", "", "failed to run command false"},
+		{"no command", "exec: ", "", "has no command"},
+		{"literal reference", "literal:exec:rm -rf /", "exec:rm -rf /", ""},
+		{"literal literal", "literal:literal:abc", "literal:abc", ""},
+		{"literal empty", "literal:", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := Resolve(tt.value)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				assert.Empty(t, secret)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, secret)
+		})
+	}
+}
+
+func TestCheckLocal(t *testing.T) {
+	for _, value := range []string{"plain-password", "", "literal:exec:abc", "envy:abc"} {
+		assert.NoError(t, CheckLocal(value), value)
+	}
+	for _, value := range []string{"file:/etc/shadow", "env:HOME", "exec:id"} {
+		err := CheckLocal(value)
+		require.ErrorContains(t, err, "only allowed in local configuration", value)
+		// the error names the kind of reference, not what it refers to
+		assert.NotContains(t, err.Error(), value[strings.Index(value, ":")+1:])
+	}
+}
+
+func TestLazy(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "counter")
+	script := filepath.Join(dir, "get-secret")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho run >> "+counter+"\necho from-exec\n"), 0o700))
+
+	lazy := NewLazy("exec:" + script)
+	_, err := os.Stat(counter)
+	assert.True(t, os.IsNotExist(err), "command ran before the secret was needed")
+	for range 2 {
+		secret, err := lazy.Get()
+		require.NoError(t, err)
+		assert.Equal(t, "from-exec", secret)
+	}
+	runs, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(runs), "command ran more than once")
+
+	_, err = NewLazy("env:SECRET_TEST_UNSET").Get()
+	assert.ErrorContains(t, err, "SECRET_TEST_UNSET for secret is not set")
+
+	var empty *Lazy
+	assert.Nil(t, NewLazy(""))
+	secret, err := empty.Get()
+	require.NoError(t, err)
+	assert.Empty(t, secret)
+}
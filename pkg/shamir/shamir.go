@@ -0,0 +1,170 @@
+// Package shamir splits a key into shares with Shamir's secret sharing, so that any threshold of them, but no fewer,
+// reconstruct it. Each byte of the key is split separately, with a random polynomial over GF(2^8).
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxShares the most shares a key can be split into, one for each non-zero x coordinate
+	MaxShares = 255
+
+	shareVersion = 1
+	splitIDSize  = 4
+	// version, split ID, threshold and x coordinate, before the y coordinates
+	headerSize = 1 + splitIDSize + 1 + 1
+)
+
+// ErrMismatch the shares are not all from splitting the same key
+var ErrMismatch = errors.New("shares are from different splits")
+
+// Share one of the shares of a split key
+type Share struct {
+	// ID random, the same for all of the shares of one split, to tell shares of different splits apart
+	ID        [splitIDSize]byte
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+// String the share encoded as text, as it is handed out, and as Parse reads it
+func (s Share) String() string {
+	b := make([]byte, 0, headerSize+len(s.Y))
+	b = append(b, shareVersion)
+	b = append(b, s.ID[:]...)
+	b = append(b, byte(s.Threshold), s.X)
+	b = append(b, s.Y...)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Parse read a share encoded with String
+func Parse(text string) (Share, error) {
+	var share Share
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return share, fmt.Errorf("invalid share: %v", err)
+	}
+	if len(b) <= headerSize {
+		return share, fmt.Errorf("invalid share: length %d is too short", len(b))
+	}
+	if b[0] != shareVersion {
+		return share, fmt.Errorf("invalid share: unsupported version %d", b[0])
+	}
+	copy(share.ID[:], b[1:1+splitIDSize])
+	share.Threshold = int(b[1+splitIDSize])
+	share.X = b[2+splitIDSize]
+	share.Y = b[headerSize:]
+	if share.Threshold < 2 || share.X == 0 {
+		return share, fmt.Errorf("invalid share: threshold %d, x %d", share.Threshold, share.X)
+	}
+	return share, nil
+}
+
+// Split the key into n shares, any threshold of which reconstruct it
+func Split(key []byte, n, threshold int) ([]Share, error) {
+	switch {
+	case len(key) == 0:
+		return nil, fmt.Errorf("key is empty")
+	case threshold < 2:
+		return nil, fmt.Errorf("threshold %d must be at least 2", threshold)
+	case n < threshold:
+		return nil, fmt.Errorf("number of shares %d must be at least the threshold %d", n, threshold)
+	case n > MaxShares:
+		return nil, fmt.Errorf("number of shares %d must be at most %d", n, MaxShares)
+	}
+	var id [splitIDSize]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate split ID: %v", err)
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{ID: id, Threshold: threshold, X: byte(i + 1), Y: make([]byte, len(key))}
+	}
+	// coefficients of the polynomial for each byte: the byte itself, then random ones
+	coefficients := make([]byte, threshold)
+	for i, secret := range key {
+		coefficients[0] = secret
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate coefficients: %v", err)
+		}
+		for j := range shares {
+			shares[j].Y[i] = evaluate(coefficients, shares[j].X)
+		}
+	}
+	clear(coefficients)
+	return shares, nil
+}
+
+// Combine reconstruct the key from at least the threshold of its shares
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares")
+	}
+	first := shares[0]
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if share.ID != first.ID || share.Threshold != first.Threshold || len(share.Y) != len(first.Y) {
+			return nil, ErrMismatch
+		}
+		if seen[share.X] {
+			return nil, fmt.Errorf("share %d is given more than once", share.X)
+		}
+		seen[share.X] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("need %d shares to reconstruct the key, have %d", first.Threshold, len(shares))
+	}
+	// any threshold of the shares determine the polynomial, so only that many are needed
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation at x = 0; in GF(2^8), subtraction is the same as addition, i.e. xor
+	key := make([]byte, len(first.Y))
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, div(other.X, other.X^share.X))
+		}
+		for k := range key {
+			key[k] ^= mul(share.Y[k], basis)
+		}
+	}
+	return key, nil
+}
+
+// evaluate the polynomial with the coefficients, lowest degree first, at x
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// mul multiply in GF(2^8) with the AES polynomial, without branching on the values
+func mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// div divide in GF(2^8); b must not be 0
+func div(a, b byte) byte {
+	// the inverse of b is b^254
+	inverse := b
+	for range 6 {
+		inverse = mul(mul(inverse, inverse), b)
+	}
+	return mul(a, mul(inverse, inverse))
+}
@@ -0,0 +1,88 @@
+package shamir
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCombine(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	shares, err := Split(key, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+	for _, share := range shares {
+		assert.NotEqual(t, key, share.Y)
+	}
+
+	tests := []struct {
+		name    string
+		indexes []int
+		err     string
+	}{
+		{"first threshold", []int{0, 1, 2}, ""},
+		{"last threshold", []int{4, 3, 2}, ""},
+		{"more than threshold", []int{0, 2, 3, 4}, ""},
+		{"all", []int{0, 1, 2, 3, 4}, ""},
+		{"too few", []int{1, 3}, "need 3 shares"},
+		{"duplicate", []int{1, 1, 3}, "more than once"},
+		{"none", nil, "no shares"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var given []Share
+			for _, i := range tt.indexes {
+				// through the text encoding, as they are handed out
+				share, err := Parse(shares[i].String())
+				require.NoError(t, err)
+				given = append(given, share)
+			}
+			combined, err := Combine(given)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, key, combined)
+		})
+	}
+
+	t.Run("different splits", func(t *testing.T) {
+		other, err := Split(key, 5, 3)
+		require.NoError(t, err)
+		_, err = Combine([]Share{shares[0], shares[1], other[2]})
+		require.ErrorIs(t, err, ErrMismatch)
+	})
+}
+
+func TestSplitInvalid(t *testing.T) {
+	key := []byte("abc")
+	_, err := Split(nil, 3, 2)
+	assert.ErrorContains(t, err, "empty")
+	_, err = Split(key, 3, 1)
+	assert.ErrorContains(t, err, "at least 2")
+	_, err = Split(key, 2, 3)
+	assert.ErrorContains(t, err, "at least the threshold")
+	_, err = Split(key, 256, 3)
+	assert.ErrorContains(t, err, "at most 255")
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse("not base64!")
+	assert.ErrorContains(t, err, "invalid share")
+	_, err = Parse("AQ==")
+	assert.ErrorContains(t, err, "too short")
+	_, err = Parse(base64.StdEncoding.EncodeToString([]byte{2, 0, 0, 0, 0, 2, 1, 'a'}))
+	assert.ErrorContains(t, err, "unsupported version")
+}
+
+func TestField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), div(byte(a), byte(a)), "a=%d", a)
+		assert.Equal(t, byte(a), mul(div(byte(a), 7), 7), "a=%d", a)
+	}
+	// 0x53 and 0xca are inverses in the AES field
+	assert.Equal(t, byte(1), mul(0x53, 0xca))
+}
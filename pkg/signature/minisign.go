@@ -0,0 +1,380 @@
+// Package signature signs backup files with Ed25519 keys, and verifies them, in the format of minisign, so that
+// signatures can also be checked with `minisign -V`. Signatures are detached, in a file next to the backup.
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Extension appended to the name of a backup file for its signature file, as minisign expects
+	Extension = ".minisig"
+
+	// algorithms of keys and signatures
+	algEd25519       = "Ed"
+	algEd25519Hashed = "ED"
+	kdfScrypt        = "Sc"
+	kdfNone          = "\x00\x00"
+	checksumBlake2b  = "B2"
+
+	untrustedCommentPrefix = "untrusted comment: "
+	trustedCommentPrefix   = "trusted comment: "
+
+	// the scrypt limits of minisign, which it encrypts secret keys with
+	scryptOpsLimit = 33554432
+	scryptMemLimit = 1073741824
+
+	keyIDSize         = 8
+	publicKeySize     = 2 + keyIDSize + ed25519.PublicKeySize
+	signatureSize     = 2 + keyIDSize + ed25519.SignatureSize
+	saltSize          = 32
+	secretKeyDataSize = keyIDSize + ed25519.PrivateKeySize + blake2b.Size256
+	secretKeySize     = 2 + 2 + 2 + saltSize + 8 + 8 + secretKeyDataSize
+
+	// maxUnhashedSize the largest file whose signature of the file itself, rather than of its hash, is verified,
+	// since the whole file must be in memory
+	maxUnhashedSize = 16 << 20
+)
+
+// ErrInvalid the signature does not match the file, or is not by any of the keys
+var ErrInvalid = errors.New("invalid signature")
+
+// PublicKey an Ed25519 public key that verifies signatures, with the ID that signatures by it name
+type PublicKey struct {
+	id  [keyIDSize]byte
+	key ed25519.PublicKey
+}
+
+// ID the ID of the key, as minisign shows it
+func (k PublicKey) ID() string {
+	return formatKeyID(k.id)
+}
+
+// MarshalText the public key file, as minisign writes it
+func (k PublicKey) MarshalText() ([]byte, error) {
+	data := append(append([]byte(algEd25519), k.id[:]...), k.key...)
+	return fmt.Appendf(nil, "%sminisign public key %s\n%s\n", untrustedCommentPrefix, k.ID(), base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// SecretKey an Ed25519 secret key that signs files
+type SecretKey struct {
+	id  [keyIDSize]byte
+	key ed25519.PrivateKey
+}
+
+// GenerateKey generate a new secret key, with a random ID
+func GenerateKey() (*SecretKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	k := &SecretKey{key: key}
+	if _, err := rand.Read(k.id[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	return k, nil
+}
+
+// Public the public key that verifies signatures by the key
+func (k *SecretKey) Public() PublicKey {
+	return PublicKey{id: k.id, key: k.key.Public().(ed25519.PublicKey)}
+}
+
+// ID the ID of the key, as minisign shows it
+func (k *SecretKey) ID() string {
+	return formatKeyID(k.id)
+}
+
+// Marshal the secret key file, as minisign writes it, encrypted with the password, unless it is empty
+func (k *SecretKey) Marshal(password string) ([]byte, error) {
+	return k.marshal(password, scryptOpsLimit, scryptMemLimit)
+}
+
+func (k *SecretKey) marshal(password string, opsLimit, memLimit uint64) ([]byte, error) {
+	data := make([]byte, 0, secretKeySize)
+	kdf := kdfNone
+	if password != "" {
+		kdf = kdfScrypt
+	}
+	data = append(data, algEd25519+kdf+checksumBlake2b...)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	data = append(data, salt...)
+	if password == "" {
+		opsLimit, memLimit = 0, 0
+	}
+	data = binary.LittleEndian.AppendUint64(data, opsLimit)
+	data = binary.LittleEndian.AppendUint64(data, memLimit)
+	keyData := append(append([]byte{}, k.id[:]...), k.key...)
+	keyData = append(keyData, secretKeyChecksum(k.id, k.key)...)
+	if password != "" {
+		stream, err := secretKeyStream(password, salt, opsLimit, memLimit)
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keyData, keyData, stream)
+	}
+	data = append(data, keyData...)
+	comment := "minisign unencrypted secret key"
+	if password != "" {
+		comment = "minisign encrypted secret key"
+	}
+	return fmt.Appendf(nil, "%s%s\n%s\n", untrustedCommentPrefix, comment, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// ParseSecretKey parse a minisign secret key file, as made by `minisign -G`, decrypting it with the password,
+// unless it was made without one
+func ParseSecretKey(data []byte, password string) (*SecretKey, error) {
+	lines := keyLines(data)
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("minisign secret key must have exactly one key line, has %d", len(lines))
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid minisign secret key: %w", err)
+	}
+	if len(raw) != secretKeySize {
+		return nil, fmt.Errorf("invalid minisign secret key: length %d, must be %d", len(raw), secretKeySize)
+	}
+	alg, kdf, checksum := string(raw[0:2]), string(raw[2:4]), string(raw[4:6])
+	if alg != algEd25519 || checksum != checksumBlake2b {
+		return nil, fmt.Errorf("unsupported minisign secret key algorithm %q with checksum %q", alg, checksum)
+	}
+	salt := raw[6 : 6+saltSize]
+	opsLimit := binary.LittleEndian.Uint64(raw[6+saltSize:])
+	memLimit := binary.LittleEndian.Uint64(raw[6+saltSize+8:])
+	keyData := append([]byte{}, raw[secretKeySize-secretKeyDataSize:]...)
+	switch kdf {
+	case kdfNone:
+	case kdfScrypt:
+		if password == "" {
+			return nil, fmt.Errorf("minisign secret key is encrypted, set the signing key password")
+		}
+		stream, err := secretKeyStream(password, salt, opsLimit, memLimit)
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keyData, keyData, stream)
+	default:
+		return nil, fmt.Errorf("unsupported minisign secret key derivation %q", kdf)
+	}
+	k := &SecretKey{key: ed25519.PrivateKey(keyData[keyIDSize : keyIDSize+ed25519.PrivateKeySize])}
+	copy(k.id[:], keyData[:keyIDSize])
+	if subtle.ConstantTimeCompare(keyData[keyIDSize+ed25519.PrivateKeySize:], secretKeyChecksum(k.id, k.key)) != 1 {
+		if kdf == kdfScrypt {
+			return nil, fmt.Errorf("failed to decrypt minisign secret key, wrong password")
+		}
+		return nil, fmt.Errorf("invalid minisign secret key: checksum does not match")
+	}
+	return k, nil
+}
+
+// secretKeyChecksum the checksum of a secret key, which shows whether it was decrypted with the right password
+func secretKeyChecksum(id [keyIDSize]byte, key ed25519.PrivateKey) []byte {
+	sum := blake2b.Sum256(append(append([]byte(algEd25519), id[:]...), key...))
+	return sum[:]
+}
+
+// secretKeyStream the stream that the secret key is XORed with to encrypt it, derived from the password with
+// scrypt, with the parameters that libsodium derives from the limits
+func secretKeyStream(password string, salt []byte, opsLimit, memLimit uint64) ([]byte, error) {
+	logN, r, p := scryptParams(opsLimit, memLimit)
+	stream, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, secretKeyDataSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from password: %w", err)
+	}
+	return stream, nil
+}
+
+// scryptParams the scrypt parameters for the limits, as libsodium's crypto_pwhash_scryptsalsa208sha256 picks them
+func scryptParams(opsLimit, memLimit uint64) (logN uint, r, p int) {
+	opsLimit = max(opsLimit, 32768)
+	r = 8
+	var maxN uint64
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN = opsLimit / uint64(r*4)
+	} else {
+		maxN = memLimit / uint64(r*128)
+	}
+	for logN = 1; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if opsLimit >= memLimit/32 {
+		maxrp := min((opsLimit/4)/(uint64(1)<<logN), 0x3fffffff)
+		p = int(maxrp) / r
+	}
+	return logN, r, p
+}
+
+// ParsePublicKeys parse minisign or signify public key files, concatenated, or the key lines alone, as given
+// to `minisign -P`
+func ParsePublicKeys(data []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+	for _, line := range keyLines(data) {
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minisign public key: %w", err)
+		}
+		if len(raw) != publicKeySize || string(raw[:2]) != algEd25519 {
+			return nil, fmt.Errorf("invalid minisign public key: not an Ed25519 key")
+		}
+		k := PublicKey{key: ed25519.PublicKey(raw[2+keyIDSize:])}
+		copy(k.id[:], raw[2:2+keyIDSize])
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no minisign public keys")
+	}
+	return keys, nil
+}
+
+// keyLines the lines of a key file that are not comments or blank
+func keyLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, untrustedCommentPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Sign sign the contents of in, returning the signature file. The trusted comment records the time, and the name
+// of the file, so that a signature cannot be passed off as that of another file.
+func (k *SecretKey) Sign(in io.Reader, filename string) ([]byte, error) {
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, in); err != nil {
+		return nil, fmt.Errorf("failed to read file to sign: %w", err)
+	}
+	sig := ed25519.Sign(k.key, h.Sum(nil))
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filename)
+	global := ed25519.Sign(k.key, append(append([]byte{}, sig...), trusted...))
+	data := append(append([]byte(algEd25519Hashed), k.id[:]...), sig...)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%ssignature from mysql-backup secret key %s\n", untrustedCommentPrefix, k.ID())
+	fmt.Fprintf(&buf, "%s\n", base64.StdEncoding.EncodeToString(data))
+	fmt.Fprintf(&buf, "%s%s\n", trustedCommentPrefix, trusted)
+	fmt.Fprintf(&buf, "%s\n", base64.StdEncoding.EncodeToString(global))
+	return buf.Bytes(), nil
+}
+
+// Verified a signature that was verified, with the key that made it, and the trusted comment, if any
+type Verified struct {
+	Key            PublicKey
+	TrustedComment string
+}
+
+// Filename the name of the file that the signature says that it is for, from the trusted comment, if any
+func (v Verified) Filename() string {
+	for _, field := range strings.Split(v.TrustedComment, "\t") {
+		if name, ok := strings.CutPrefix(field, "file:"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Verify verify that sig, a minisign signature file, is a signature of the contents of in by one of the keys.
+// Signatures of the file itself, rather than of its hash, as signify and older minisign versions make, need
+// the whole file in memory, so are only verified for files of up to 16 MiB.
+func Verify(in io.Reader, sig []byte, keys []PublicKey) (Verified, error) {
+	var lines []string
+	for _, line := range strings.Split(string(sig), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 2 && len(lines) != 4 {
+		return Verified{}, fmt.Errorf("invalid signature file: %d lines, must be 2 or 4", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], untrustedCommentPrefix) {
+		return Verified{}, fmt.Errorf("invalid signature file: no untrusted comment")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != signatureSize {
+		return Verified{}, fmt.Errorf("invalid signature file: invalid signature line")
+	}
+	alg := string(raw[:2])
+	var id [keyIDSize]byte
+	copy(id[:], raw[2:2+keyIDSize])
+	signature := raw[2+keyIDSize:]
+	var key *PublicKey
+	for i := range keys {
+		if keys[i].id == id {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return Verified{}, fmt.Errorf("%w: signed with the key with ID %s, which is not one of the given keys", ErrInvalid, formatKeyID(id))
+	}
+
+	var message []byte
+	switch alg {
+	case algEd25519Hashed:
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return Verified{}, err
+		}
+		if _, err := io.Copy(h, in); err != nil {
+			return Verified{}, fmt.Errorf("failed to read signed file: %w", err)
+		}
+		message = h.Sum(nil)
+	case algEd25519:
+		if message, err = io.ReadAll(io.LimitReader(in, maxUnhashedSize+1)); err != nil {
+			return Verified{}, fmt.Errorf("failed to read signed file: %w", err)
+		}
+		if len(message) > maxUnhashedSize {
+			return Verified{}, fmt.Errorf("%w: signature of the file itself, rather than of its hash, is only verified for files of up to %d bytes", ErrInvalid, maxUnhashedSize)
+		}
+	default:
+		return Verified{}, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if !ed25519.Verify(key.key, message, signature) {
+		return Verified{}, fmt.Errorf("%w: the file does not match the signature by the key with ID %s", ErrInvalid, key.ID())
+	}
+	verified := Verified{Key: *key}
+	if len(lines) == 2 {
+		return verified, nil
+	}
+	trusted, ok := strings.CutPrefix(lines[2], trustedCommentPrefix)
+	if !ok {
+		return Verified{}, fmt.Errorf("invalid signature file: no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return Verified{}, fmt.Errorf("invalid signature file: invalid trusted comment signature")
+	}
+	if !ed25519.Verify(key.key, append(append([]byte{}, signature...), trusted...), global) {
+		return Verified{}, fmt.Errorf("%w: the trusted comment does not match its signature", ErrInvalid)
+	}
+	verified.TrustedComment = trusted
+	return verified, nil
+}
+
+// formatKeyID the key ID as minisign shows it, the hex of the ID as a little-endian number
+func formatKeyID(id [keyIDSize]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
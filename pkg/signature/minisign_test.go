@@ -0,0 +1,133 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	data := bytes.Repeat([]byte("INSERT INTO `t` VALUES (1,'abc');\n"), 1000)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	other, err := GenerateKey()
+	require.NoError(t, err)
+
+	sig, err := key.Sign(bytes.NewReader(data), "db_backup_2021-01-01T00:00:00Z.tgz")
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		verified, err := Verify(bytes.NewReader(data), sig, []PublicKey{other.Public(), key.Public()})
+		require.NoError(t, err)
+		assert.Equal(t, key.ID(), verified.Key.ID())
+		assert.Equal(t, "db_backup_2021-01-01T00:00:00Z.tgz", verified.Filename())
+	})
+	t.Run("tampered file", func(t *testing.T) {
+		tampered := append(append([]byte{}, data...), "DROP TABLE `t`;\n"...)
+		_, err := Verify(bytes.NewReader(tampered), sig, []PublicKey{key.Public()})
+		require.ErrorIs(t, err, ErrInvalid)
+	})
+	t.Run("other key", func(t *testing.T) {
+		_, err := Verify(bytes.NewReader(data), sig, []PublicKey{other.Public()})
+		require.ErrorIs(t, err, ErrInvalid)
+		assert.ErrorContains(t, err, key.ID())
+	})
+	t.Run("tampered trusted comment", func(t *testing.T) {
+		tampered := strings.Replace(string(sig), "file:db_backup_2021-01-01", "file:db_backup_2020-01-01", 1)
+		_, err := Verify(bytes.NewReader(data), []byte(tampered), []PublicKey{key.Public()})
+		require.ErrorIs(t, err, ErrInvalid)
+	})
+	t.Run("signify", func(t *testing.T) {
+		// signify signs the file itself, with no trusted comment
+		raw := append(append([]byte(algEd25519), key.id[:]...), ed25519.Sign(key.key, data)...)
+		signify := fmt.Sprintf("untrusted comment: verify with key.pub\n%s\n", base64.StdEncoding.EncodeToString(raw))
+		verified, err := Verify(bytes.NewReader(data), []byte(signify), []PublicKey{key.Public()})
+		require.NoError(t, err)
+		assert.Equal(t, "", verified.Filename())
+
+		// which is not read into memory for a large file
+		large := bytes.Repeat([]byte{'a'}, maxUnhashedSize+1)
+		raw = append(append([]byte(algEd25519), key.id[:]...), ed25519.Sign(key.key, large)...)
+		signify = fmt.Sprintf("untrusted comment: verify with key.pub\n%s\n", base64.StdEncoding.EncodeToString(raw))
+		_, err = Verify(bytes.NewReader(large), []byte(signify), []PublicKey{key.Public()})
+		require.ErrorIs(t, err, ErrInvalid)
+		assert.ErrorContains(t, err, "only verified for files of up to")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Verify(bytes.NewReader(data), []byte("untrusted comment: x\n"), []PublicKey{key.Public()})
+		assert.ErrorContains(t, err, "invalid signature file")
+		_, err = Verify(bytes.NewReader(data), []byte("untrusted comment: x\nnotbase64!\n"), []PublicKey{key.Public()})
+		assert.ErrorContains(t, err, "invalid signature line")
+	})
+}
+
+func TestKeys(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	t.Run("public key", func(t *testing.T) {
+		text, err := key.Public().MarshalText()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(text), "untrusted comment: minisign public key "+key.ID()+"\n"))
+		other, err := GenerateKey()
+		require.NoError(t, err)
+		otherText, err := other.Public().MarshalText()
+		require.NoError(t, err)
+
+		// key files concatenated, or the key lines alone
+		keyLine := strings.Split(string(otherText), "\n")[1]
+		keys, err := ParsePublicKeys(append(text, keyLine...))
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, key.Public(), keys[0])
+		assert.Equal(t, other.Public(), keys[1])
+
+		_, err = ParsePublicKeys([]byte("untrusted comment: nothing\n"))
+		assert.ErrorContains(t, err, "no minisign public keys")
+		_, err = ParsePublicKeys([]byte("YWJj"))
+		assert.ErrorContains(t, err, "not an Ed25519 key")
+	})
+
+	t.Run("unencrypted secret key", func(t *testing.T) {
+		text, err := key.Marshal("")
+		require.NoError(t, err)
+		parsed, err := ParseSecretKey(text, "")
+		require.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	})
+
+	t.Run("encrypted secret key", func(t *testing.T) {
+		// small limits, so that the test does not need the memory of the defaults
+		text, err := key.marshal("hunter2", 32768, 1<<20)
+		require.NoError(t, err)
+		parsed, err := ParseSecretKey(text, "hunter2")
+		require.NoError(t, err)
+		assert.Equal(t, key, parsed)
+
+		_, err = ParseSecretKey(text, "wrong")
+		assert.ErrorContains(t, err, "wrong password")
+		_, err = ParseSecretKey(text, "")
+		assert.ErrorContains(t, err, "set the signing key password")
+	})
+
+	t.Run("invalid secret key", func(t *testing.T) {
+		_, err := ParseSecretKey([]byte("untrusted comment: x\nYWJj\n"), "")
+		assert.ErrorContains(t, err, "length 3")
+		_, err = ParseSecretKey([]byte("a\nb\n"), "")
+		assert.ErrorContains(t, err, "exactly one key line")
+	})
+}
+
+func TestScryptParams(t *testing.T) {
+	// minisign's limits, as libsodium turns them into scrypt parameters
+	logN, r, p := scryptParams(scryptOpsLimit, scryptMemLimit)
+	assert.Equal(t, uint(20), logN)
+	assert.Equal(t, 8, r)
+	assert.Equal(t, 1, p)
+}
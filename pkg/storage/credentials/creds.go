@@ -1,8 +1,9 @@
 package credentials
 
 type Creds struct {
-	SMB SMBCreds
-	AWS AWSCreds
+	SMB  SMBCreds
+	AWS  AWSCreds
+	HTTP HTTPCreds
 }
 
 type SMBCreds struct {
@@ -17,4 +18,23 @@ type AWSCreds struct {
 	Endpoint        string
 	PathStyle       bool
 	Region          string
+	// settings for uploaded objects
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	SSECustomerKey       string
+	StorageClass         string
+	Tags                 map[string]string
+	Metadata             map[string]string
+	ACL                  string
+	ChecksumAlgorithm    string
+	ObjectLockMode       string
+	ObjectLockLegalHold  bool
+}
+
+type HTTPCreds struct {
+	Method      string
+	BearerToken string
+	ClientCert  string
+	ClientKey   string
+	CACert      string
 }
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
 )
 
 type File struct {
@@ -23,11 +25,11 @@ func New(u url.URL) *File {
 }
 
 func (f *File) Pull(ctx context.Context, source, target string, logger *log.Entry) (int64, error) {
-	return copyFile(path.Join(f.path, source), target)
+	return copyFile(ctx, path.Join(f.path, source), target, ratelimit.Download(ctx))
 }
 
 func (f *File) Push(ctx context.Context, target, source string, logger *log.Entry) (int64, error) {
-	return copyFile(source, filepath.Join(f.path, target))
+	return copyFile(ctx, source, filepath.Join(f.path, target), ratelimit.Upload(ctx))
 }
 
 func (f *File) Clean(filename string) string {
@@ -63,8 +65,8 @@ func (f *File) Remove(ctx context.Context, target string, logger *log.Entry) err
 	return os.Remove(filepath.Join(f.path, target))
 }
 
-// copyFile copy a file from to as efficiently as possible
-func copyFile(from, to string) (int64, error) {
+// copyFile copy a file from to as efficiently as possible, no faster than the limiter allows, if any
+func copyFile(ctx context.Context, from, to string, limiter *ratelimit.Limiter) (int64, error) {
 	src, err := os.Open(from)
 	if err != nil {
 		return 0, fmt.Errorf("failed to open source file %s: %w", from, err)
@@ -76,6 +78,6 @@ func copyFile(from, to string) (int64, error) {
 		return 0, fmt.Errorf("failed to create target file %s: %w", to, err)
 	}
 	defer func() { _ = dst.Close() }()
-	n, err := io.Copy(dst, src)
+	n, err := io.Copy(dst, limiter.Reader(ctx, src))
 	return n, err
 }
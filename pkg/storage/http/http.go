@@ -0,0 +1,492 @@
+// Package http is a storage backend for generic HTTP(S) servers, such as artifact stores, that
+// accept uploads via PUT or multipart POST, serve downloads via GET and list backups via a JSON index.
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/remote"
+	"github.com/databacker/mysql-backup/pkg/secret"
+)
+
+const (
+	// default templates for the URLs of each operation
+	defaultFileURL  = "{{ .base }}/{{ .filename }}"
+	defaultIndexURL = "{{ .base }}/{{ .dirname }}"
+
+	defaultFormField = "file"
+
+	// maxErrorBody how much of the response body to include in errors
+	maxErrorBody = 512
+)
+
+type HTTP struct {
+	url    url.URL
+	method string
+	// templates for the URL of each operation
+	uploadURL   string
+	downloadURL string
+	indexURL    string
+	deleteURL   string
+	formField   string
+	// authentication; the secrets may be references, resolved when first needed
+	bearerToken *secret.Lazy
+	username    string
+	password    *secret.Lazy
+	headers     map[string]string
+	// TLS
+	clientCertFile string
+	clientKeyFile  string
+	caCertFile     string
+	certificates   []string
+	credentials    *secret.Lazy
+	// the client, made on first use and reused, so that connections are too
+	clientOnce sync.Once
+	client     *nethttp.Client
+	clientErr  error
+}
+
+type Option func(h *HTTP)
+
+// WithMethod method for uploading, PUT (the default) for the raw file as the body, or POST for a multipart form
+func WithMethod(method string) Option {
+	return func(h *HTTP) {
+		h.method = strings.ToUpper(method)
+	}
+}
+
+// WithUploadURL template for the URL to upload to. The template is given {{ .base }}, the target URL,
+// and {{ .filename }}, the name of the backup file, escaped for a path. Defaults to {{ .base }}/{{ .filename }}.
+// {{ .rawfilename }} is the name unescaped, e.g. for {{ .rawfilename | urlquery }} in a query.
+func WithUploadURL(tmpl string) Option {
+	return func(h *HTTP) {
+		h.uploadURL = tmpl
+	}
+}
+
+// WithDownloadURL template for the URL to download from, with the same fields as WithUploadURL
+func WithDownloadURL(tmpl string) Option {
+	return func(h *HTTP) {
+		h.downloadURL = tmpl
+	}
+}
+
+// WithIndexURL template for the URL of the JSON index of backups. The template is given {{ .base }}, the target URL,
+// and {{ .dirname }}, the directory to list, normally empty, escaped for a path, as well as {{ .rawdirname }}
+// unescaped. Defaults to {{ .base }}/{{ .dirname }}.
+func WithIndexURL(tmpl string) Option {
+	return func(h *HTTP) {
+		h.indexURL = tmpl
+	}
+}
+
+// WithDeleteURL template for the URL to delete, with the same fields as WithUploadURL
+func WithDeleteURL(tmpl string) Option {
+	return func(h *HTTP) {
+		h.deleteURL = tmpl
+	}
+}
+
+// WithFormField name of the form field for the file, when uploading via POST. Defaults to "file".
+func WithFormField(field string) Option {
+	return func(h *HTTP) {
+		h.formField = field
+	}
+}
+
+// WithBearerToken authenticate with a bearer token, or a reference to it
+func WithBearerToken(token string) Option {
+	return func(h *HTTP) {
+		h.bearerToken = secret.NewLazy(token)
+	}
+}
+
+// WithBasicAuth authenticate with basic auth, overriding any user and password in the URL. The password
+// may be a reference to it.
+func WithBasicAuth(username, password string) Option {
+	return func(h *HTTP) {
+		h.username = username
+		h.password = secret.NewLazy(password)
+	}
+}
+
+// WithHeaders additional headers to send with every request
+func WithHeaders(headers map[string]string) Option {
+	return func(h *HTTP) {
+		h.headers = headers
+	}
+}
+
+// WithClientCertificate authenticate with mTLS, using the PEM-encoded certificate and key files
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(h *HTTP) {
+		h.clientCertFile = certFile
+		h.clientKeyFile = keyFile
+	}
+}
+
+// WithCACertificate trust server certificates signed by the CA in the PEM-encoded file, in addition to the system CAs
+func WithCACertificate(caFile string) Option {
+	return func(h *HTTP) {
+		h.caCertFile = caFile
+	}
+}
+
+// WithRemoteCredentials authenticate with mTLS the same way as for remote configuration and telemetry:
+// a base64-encoded curve25519 key as the credentials, or a reference to it, and fingerprints of acceptable
+// server certificates.
+func WithRemoteCredentials(certificates []string, credentials string) Option {
+	return func(h *HTTP) {
+		h.certificates = certificates
+		h.credentials = secret.NewLazy(credentials)
+	}
+}
+
+func New(u url.URL, opts ...Option) *HTTP {
+	h := &HTTP{url: u}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *HTTP) Pull(ctx context.Context, source, target string, logger *log.Entry) (int64, error) {
+	u, err := h.fileURL(h.downloadURL, source)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := h.do(ctx, nethttp.MethodGet, u, nil, "", -1, logger)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	f, err := os.Create(target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create target restore file %q: %w", target, err)
+	}
+	defer func() { _ = f.Close() }()
+	n, err := io.Copy(f, ratelimit.Download(ctx).Reader(ctx, resp.Body))
+	if err != nil {
+		return n, fmt.Errorf("failed to download file: %w", err)
+	}
+	return n, nil
+}
+
+func (h *HTTP) Push(ctx context.Context, target, source string, logger *log.Entry) (int64, error) {
+	u, err := h.fileURL(h.uploadURL, target)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read input file %q: %w", source, err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat input file %q: %w", source, err)
+	}
+	body := ratelimit.Upload(ctx).Reader(ctx, f)
+
+	var resp *nethttp.Response
+	switch h.method {
+	case "", nethttp.MethodPut:
+		resp, err = h.do(ctx, nethttp.MethodPut, u, body, "application/octet-stream", info.Size(), logger)
+	case nethttp.MethodPost:
+		// stream the multipart form, rather than building it in memory
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			part, err := mw.CreateFormFile(h.formFieldName(), path.Base(target))
+			if err == nil {
+				_, err = io.Copy(part, body)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			_ = pw.CloseWithError(err)
+		}()
+		resp, err = h.do(ctx, nethttp.MethodPost, u, pr, mw.FormDataContentType(), -1, logger)
+		_ = pr.Close()
+	default:
+		return 0, fmt.Errorf("invalid upload method %q, must be PUT or POST", h.method)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload file: %w", err)
+	}
+	_ = resp.Body.Close()
+	return info.Size(), nil
+}
+
+func (h *HTTP) Clean(filename string) string {
+	return filename
+}
+
+func (h *HTTP) Protocol() string {
+	return h.url.Scheme
+}
+
+func (h *HTTP) URL() string {
+	return h.url.Redacted()
+}
+
+// ReadDir list the backups from the index, which must be a JSON array of entries, each of the form
+// {"name": "db_backup_...", "size": 1234, "modified": "2024-01-01T00:00:00Z", "dir": false}.
+// Only name is required.
+func (h *HTTP) ReadDir(ctx context.Context, dirname string, logger *log.Entry) ([]fs.FileInfo, error) {
+	tmpl := h.indexURL
+	if tmpl == "" {
+		tmpl = defaultIndexURL
+	}
+	u, err := h.expand(tmpl, map[string]string{"dirname": strings.Trim(dirname, "/")})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.do(ctx, nethttp.MethodGet, u, nil, "", -1, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var entries []indexEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to parse index from %s: %w", redact(u), err)
+	}
+	var files []fs.FileInfo
+	for _, entry := range entries {
+		if entry.Name == "" {
+			continue
+		}
+		files = append(files, &httpFileInfo{
+			name:         entry.Name,
+			lastModified: entry.Modified,
+			size:         entry.Size,
+			isDir:        entry.Dir,
+		})
+	}
+	return files, nil
+}
+
+func (h *HTTP) Remove(ctx context.Context, target string, logger *log.Entry) error {
+	u, err := h.fileURL(h.deleteURL, target)
+	if err != nil {
+		return err
+	}
+	resp, err := h.do(ctx, nethttp.MethodDelete, u, nil, "", -1, logger)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// fileURL get the URL for a single file from the template, or the default if the template is empty
+func (h *HTTP) fileURL(tmpl, filename string) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultFileURL
+	}
+	return h.expand(tmpl, map[string]string{"filename": filename})
+}
+
+// expand expand a URL template with the provided fields, each escaped for a path, and unescaped as raw<field>,
+// adding the base URL
+func (h *HTTP) expand(tmpl string, fields map[string]string) (string, error) {
+	t, err := template.New("url").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL template %q: %w", tmpl, err)
+	}
+	values := make(map[string]string, 2*len(fields)+1)
+	for k, v := range fields {
+		values[k] = escapePath(v)
+		values["raw"+k] = v
+	}
+	base := h.url
+	base.User = nil
+	values["base"] = strings.TrimSuffix(base.String(), "/")
+	var buf strings.Builder
+	if err := t.Execute(&buf, values); err != nil {
+		return "", fmt.Errorf("failed to execute URL template %q: %w", tmpl, err)
+	}
+	return buf.String(), nil
+}
+
+// escapePath escape each segment of a path, keeping the slashes between them
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (h *HTTP) formFieldName() string {
+	if h.formField == "" {
+		return defaultFormField
+	}
+	return h.formField
+}
+
+// do perform a request with authentication, returning an error for any non-2xx response.
+// size is the length of the body, or -1 if unknown.
+func (h *HTTP) do(ctx context.Context, method, u string, body io.Reader, contentType string, size int64, logger *log.Entry) (*nethttp.Response, error) {
+	client, err := h.getClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := nethttp.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case h.bearerToken != nil:
+		token, err := h.bearerToken.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case h.username != "":
+		password, err := h.password.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get password: %w", err)
+		}
+		req.SetBasicAuth(h.username, password)
+	case h.url.User != nil:
+		password, _ := h.url.User.Password()
+		req.SetBasicAuth(h.url.User.Username(), password)
+	}
+
+	logger.Debugf("%s %s", method, redact(u))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s %s returned %s: %s", method, redact(u), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// getClient get the HTTP client with the TLS configuration for the target, made once, along with any error making it
+func (h *HTTP) getClient() (*nethttp.Client, error) {
+	h.clientOnce.Do(func() {
+		h.client, h.clientErr = h.newClient()
+	})
+	return h.client, h.clientErr
+}
+
+// newClient make an HTTP client with the TLS configuration for the target
+func (h *HTTP) newClient() (*nethttp.Client, error) {
+	var (
+		tlsConfig *tls.Config
+		err       error
+	)
+	switch {
+	case h.credentials != nil:
+		if h.clientCertFile != "" || h.caCertFile != "" {
+			return nil, errors.New("cannot use both remote credentials and client or CA certificate files")
+		}
+		var credentials string
+		if credentials, err = h.credentials.Get(); err != nil {
+			return nil, fmt.Errorf("failed to get remote credentials: %w", err)
+		}
+		tlsConfig, err = remote.GetTLSConfig(h.url.Hostname(), h.certificates, credentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TLS config: %w", err)
+		}
+	case h.clientCertFile != "" || h.caCertFile != "":
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if h.clientCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(h.clientCertFile, h.clientKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if h.caCertFile != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			pem, err := os.ReadFile(h.caCertFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no valid certificates in CA certificate file %s", h.caCertFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	default:
+		return nethttp.DefaultClient, nil
+	}
+	transport := nethttp.DefaultTransport.(*nethttp.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &nethttp.Client{Transport: transport}, nil
+}
+
+// redact remove any password from a URL, for logging and errors
+func redact(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// indexEntry a single entry in the JSON index
+type indexEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Dir      bool      `json:"dir"`
+}
+
+type httpFileInfo struct {
+	name         string
+	lastModified time.Time
+	size         int64
+	isDir        bool
+}
+
+func (h httpFileInfo) Name() string       { return h.name }
+func (h httpFileInfo) Size() int64        { return h.size }
+func (h httpFileInfo) ModTime() time.Time { return h.lastModified }
+func (h httpFileInfo) IsDir() bool        { return h.isDir }
+func (h httpFileInfo) Sys() interface{}   { return nil }
+func (h httpFileInfo) Mode() fs.FileMode {
+	if h.isDir {
+		return fs.ModeDir
+	}
+	return 0
+}
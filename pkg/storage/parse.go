@@ -6,6 +6,7 @@ import (
 	"github.com/databacker/api/go/api"
 	"github.com/databacker/mysql-backup/pkg/storage/credentials"
 	"github.com/databacker/mysql-backup/pkg/storage/file"
+	"github.com/databacker/mysql-backup/pkg/storage/http"
 	"github.com/databacker/mysql-backup/pkg/storage/s3"
 	"github.com/databacker/mysql-backup/pkg/storage/scp"
 	"github.com/databacker/mysql-backup/pkg/storage/smb"
@@ -54,9 +55,36 @@ func ParseURL(url string, creds credentials.Creds) (Storage, error) {
 		if creds.AWS.PathStyle {
 			opts = append(opts, s3.WithPathStyle())
 		}
+		opts = append(opts, s3ObjectOptions(S3Objects{
+			ServerSideEncryption: &creds.AWS.ServerSideEncryption,
+			SSEKMSKeyID:          &creds.AWS.SSEKMSKeyID,
+			SSECustomerKey:       &creds.AWS.SSECustomerKey,
+			StorageClass:         &creds.AWS.StorageClass,
+			Tags:                 &creds.AWS.Tags,
+			Metadata:             &creds.AWS.Metadata,
+			ACL:                  &creds.AWS.ACL,
+			ChecksumAlgorithm:    &creds.AWS.ChecksumAlgorithm,
+			ObjectLockMode:       &creds.AWS.ObjectLockMode,
+			ObjectLockLegalHold:  &creds.AWS.ObjectLockLegalHold,
+		})...)
 		store = s3.New(*u, opts...)
 	case "scp":
 		store = scp.New(*u)
+	case "http", "https":
+		opts := []http.Option{}
+		if creds.HTTP.Method != "" {
+			opts = append(opts, http.WithMethod(creds.HTTP.Method))
+		}
+		if creds.HTTP.BearerToken != "" {
+			opts = append(opts, http.WithBearerToken(creds.HTTP.BearerToken))
+		}
+		if creds.HTTP.ClientCert != "" {
+			opts = append(opts, http.WithClientCertificate(creds.HTTP.ClientCert, creds.HTTP.ClientKey))
+		}
+		if creds.HTTP.CACert != "" {
+			opts = append(opts, http.WithCACertificate(creds.HTTP.CACert))
+		}
+		store = http.New(*u, opts...)
 	default:
 		return nil, fmt.Errorf("unknown url protocol: %s", u.Scheme)
 	}
@@ -79,6 +107,10 @@ func FromTarget(target api.Target) (store Storage, err error) {
 		if err := yaml.Unmarshal(specBytes, &spec); err != nil {
 			return nil, fmt.Errorf("parsed yaml had kind S3, but spec invalid")
 		}
+		var objectSpec S3Objects
+		if err := yaml.Unmarshal(specBytes, &objectSpec); err != nil {
+			return nil, fmt.Errorf("parsed yaml had kind S3, but spec invalid")
+		}
 
 		opts := []s3.Option{}
 		if spec.Region != nil && *spec.Region != "" {
@@ -93,6 +125,7 @@ func FromTarget(target api.Target) (store Storage, err error) {
 		if spec.SecretAccessKey != nil && *spec.SecretAccessKey != "" {
 			opts = append(opts, s3.WithSecretAccessKey(*spec.SecretAccessKey))
 		}
+		opts = append(opts, s3ObjectOptions(objectSpec)...)
 		store = s3.New(*u, opts...)
 	case api.TargetTypeSmb:
 		var spec api.SMB
@@ -115,6 +148,111 @@ func FromTarget(target api.Target) (store Storage, err error) {
 			opts = append(opts, smb.WithPassword(*spec.Password))
 		}
 		store = smb.New(*u, opts...)
+	case TargetTypeSCP:
+		var spec SCP
+		specBytes, err := yaml.Marshal(target.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling spec part of target: %w", err)
+		}
+		if err := yaml.Unmarshal(specBytes, &spec); err != nil {
+			return nil, fmt.Errorf("parsed yaml had kind SCP, but spec invalid")
+		}
+
+		opts := []scp.Option{}
+		if spec.Username != nil && *spec.Username != "" {
+			opts = append(opts, scp.WithUsername(*spec.Username))
+		}
+		if spec.Password != nil && *spec.Password != "" {
+			opts = append(opts, scp.WithPassword(*spec.Password))
+		}
+		if spec.PrivateKey != nil && *spec.PrivateKey != "" {
+			opts = append(opts, scp.WithPrivateKey([]byte(*spec.PrivateKey)))
+		}
+		if spec.PrivateKeyPath != nil && *spec.PrivateKeyPath != "" {
+			opts = append(opts, scp.WithPrivateKeyFile(*spec.PrivateKeyPath))
+		}
+		if spec.Passphrase != nil && *spec.Passphrase != "" {
+			opts = append(opts, scp.WithPassphrase(*spec.Passphrase))
+		}
+		if spec.Port != nil && *spec.Port != 0 {
+			opts = append(opts, scp.WithPort(*spec.Port))
+		}
+		if spec.KnownHosts != nil && *spec.KnownHosts != "" {
+			opts = append(opts, scp.WithKnownHostsFile(*spec.KnownHosts))
+		}
+		if spec.HostKeyFingerprints != nil && len(*spec.HostKeyFingerprints) > 0 {
+			opts = append(opts, scp.WithHostKeyFingerprints(*spec.HostKeyFingerprints...))
+		}
+		if spec.StrictHostKeyChecking != nil && *spec.StrictHostKeyChecking != "" {
+			opts = append(opts, scp.WithStrictHostKeyChecking(*spec.StrictHostKeyChecking))
+		}
+		if spec.JumpHost != nil && *spec.JumpHost != "" {
+			opts = append(opts, scp.WithJumpHost(*spec.JumpHost))
+		}
+		store = scp.New(*u, opts...)
+	case TargetTypeHTTP:
+		var spec HTTP
+		specBytes, err := yaml.Marshal(target.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling spec part of target: %w", err)
+		}
+		if err := yaml.Unmarshal(specBytes, &spec); err != nil {
+			return nil, fmt.Errorf("parsed yaml had kind HTTP, but spec invalid")
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("target of kind HTTP must have http or https URL, not %s", u.Scheme)
+		}
+
+		opts := []http.Option{}
+		if spec.Method != nil && *spec.Method != "" {
+			opts = append(opts, http.WithMethod(*spec.Method))
+		}
+		if spec.UploadURL != nil && *spec.UploadURL != "" {
+			opts = append(opts, http.WithUploadURL(*spec.UploadURL))
+		}
+		if spec.DownloadURL != nil && *spec.DownloadURL != "" {
+			opts = append(opts, http.WithDownloadURL(*spec.DownloadURL))
+		}
+		if spec.IndexURL != nil && *spec.IndexURL != "" {
+			opts = append(opts, http.WithIndexURL(*spec.IndexURL))
+		}
+		if spec.DeleteURL != nil && *spec.DeleteURL != "" {
+			opts = append(opts, http.WithDeleteURL(*spec.DeleteURL))
+		}
+		if spec.FormField != nil && *spec.FormField != "" {
+			opts = append(opts, http.WithFormField(*spec.FormField))
+		}
+		if spec.BearerToken != nil && *spec.BearerToken != "" {
+			opts = append(opts, http.WithBearerToken(*spec.BearerToken))
+		}
+		if spec.Username != nil && *spec.Username != "" {
+			var password string
+			if spec.Password != nil {
+				password = *spec.Password
+			}
+			opts = append(opts, http.WithBasicAuth(*spec.Username, password))
+		}
+		if spec.Headers != nil && len(*spec.Headers) > 0 {
+			opts = append(opts, http.WithHeaders(*spec.Headers))
+		}
+		if spec.ClientCert != nil && *spec.ClientCert != "" {
+			var key string
+			if spec.ClientKey != nil {
+				key = *spec.ClientKey
+			}
+			opts = append(opts, http.WithClientCertificate(*spec.ClientCert, key))
+		}
+		if spec.CACert != nil && *spec.CACert != "" {
+			opts = append(opts, http.WithCACertificate(*spec.CACert))
+		}
+		if spec.Credentials != nil && *spec.Credentials != "" {
+			var certs []string
+			if spec.Certificates != nil {
+				certs = *spec.Certificates
+			}
+			opts = append(opts, http.WithRemoteCredentials(certs, *spec.Credentials))
+		}
+		store = http.New(*u, opts...)
 	case api.TargetTypeFile:
 		store, err = ParseURL(target.URL, credentials.Creds{})
 		if err != nil {
@@ -125,3 +263,39 @@ func FromTarget(target api.Target) (store Storage, err error) {
 	}
 	return store, nil
 }
+
+// s3ObjectOptions get the s3 options for uploaded objects from the spec, skipping unset fields
+func s3ObjectOptions(spec S3Objects) []s3.Option {
+	opts := []s3.Option{}
+	if spec.ServerSideEncryption != nil && *spec.ServerSideEncryption != "" {
+		opts = append(opts, s3.WithServerSideEncryption(*spec.ServerSideEncryption))
+	}
+	if spec.SSEKMSKeyID != nil && *spec.SSEKMSKeyID != "" {
+		opts = append(opts, s3.WithSSEKMSKeyID(*spec.SSEKMSKeyID))
+	}
+	if spec.SSECustomerKey != nil && *spec.SSECustomerKey != "" {
+		opts = append(opts, s3.WithSSECustomerKey(*spec.SSECustomerKey))
+	}
+	if spec.StorageClass != nil && *spec.StorageClass != "" {
+		opts = append(opts, s3.WithStorageClass(*spec.StorageClass))
+	}
+	if spec.Tags != nil && len(*spec.Tags) > 0 {
+		opts = append(opts, s3.WithTags(*spec.Tags))
+	}
+	if spec.Metadata != nil && len(*spec.Metadata) > 0 {
+		opts = append(opts, s3.WithMetadata(*spec.Metadata))
+	}
+	if spec.ACL != nil && *spec.ACL != "" {
+		opts = append(opts, s3.WithACL(*spec.ACL))
+	}
+	if spec.ChecksumAlgorithm != nil && *spec.ChecksumAlgorithm != "" {
+		opts = append(opts, s3.WithChecksumAlgorithm(*spec.ChecksumAlgorithm))
+	}
+	if spec.ObjectLockMode != nil && *spec.ObjectLockMode != "" {
+		opts = append(opts, s3.WithObjectLockMode(*spec.ObjectLockMode))
+	}
+	if spec.ObjectLockLegalHold != nil && *spec.ObjectLockLegalHold {
+		opts = append(opts, s3.WithObjectLockLegalHold())
+	}
+	return opts
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/databacker/api/go/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromTargetLazySecrets(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewEntry(log.New())
+	tests := []struct {
+		name   string
+		target api.Target
+		err    string
+	}{
+		{"s3", api.Target{Type: api.TargetTypeS3, URL: "s3://bucket/path", Spec: &map[string]interface{}{
+			"accessKeyID":     "abc",
+			"secretAccessKey": "env:STORAGE_TEST_UNSET",
+		}}, "failed to get AWS secret access key"},
+		{"smb", api.Target{Type: api.TargetTypeSmb, URL: "smb://127.0.0.1:1/share", Spec: &map[string]interface{}{
+			"password": "env:STORAGE_TEST_UNSET",
+		}}, "failed to get SMB password"},
+		{"scp", api.Target{Type: TargetTypeSCP, URL: "scp://127.0.0.1:1/path", Spec: &map[string]interface{}{
+			"password": "env:STORAGE_TEST_UNSET",
+		}}, "failed to get SSH password"},
+		{"http", api.Target{Type: TargetTypeHTTP, URL: "http://127.0.0.1:1/files", Spec: &map[string]interface{}{
+			"bearerToken": "env:STORAGE_TEST_UNSET",
+		}}, "failed to get bearer token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the reference is not resolved until the target is used
+			store, err := FromTarget(tt.target)
+			require.NoError(t, err)
+			_, err = store.Pull(ctx, "db_backup.tgz", filepath.Join(t.TempDir(), "db_backup.tgz"), logger)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.Contains(t, err.Error(), "STORAGE_TEST_UNSET")
+		})
+	}
+}
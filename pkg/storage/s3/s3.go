@@ -2,12 +2,16 @@ package s3
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,18 +20,42 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/secret"
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+const (
+	// sseCustomerAlgorithm the only algorithm S3 supports for SSE-C
+	sseCustomerAlgorithm = "AES256"
+	// maxDeleteObjects the most objects that can be deleted in a single DeleteObjects request
+	maxDeleteObjects = 1000
 )
 
 type S3 struct {
 	url url.URL
 	// pathStyle option is not really used, but may be required
 	// at some point; see https://aws.amazon.com/blogs/aws/amazon-s3-path-deprecation-plan-the-rest-of-the-story/
-	pathStyle       bool
-	region          string
-	endpoint        string
-	accessKeyId     string
-	secretAccessKey string
+	pathStyle bool
+	region    string
+	endpoint  string
+	// credentials, which may be references to secrets, resolved when the client is first needed
+	accessKeyId     *secret.Lazy
+	secretAccessKey *secret.Lazy
+	// settings for uploaded objects
+	serverSideEncryption string
+	sseKMSKeyID          string
+	sseCustomerKey       *secret.Lazy
+	storageClass         string
+	tags                 map[string]string
+	metadata             map[string]string
+	acl                  string
+	checksumAlgorithm    string
+	objectLockMode       string
+	objectLockLegalHold  bool
 }
 
 type Option func(s *S3)
@@ -49,12 +77,87 @@ func WithEndpoint(endpoint string) Option {
 }
 func WithAccessKeyId(accessKeyId string) Option {
 	return func(s *S3) {
-		s.accessKeyId = accessKeyId
+		s.accessKeyId = secret.NewLazy(accessKeyId)
 	}
 }
 func WithSecretAccessKey(secretAccessKey string) Option {
 	return func(s *S3) {
-		s.secretAccessKey = secretAccessKey
+		s.secretAccessKey = secret.NewLazy(secretAccessKey)
+	}
+}
+
+// WithServerSideEncryption server-side encryption for uploaded objects, one of AES256 (SSE-S3), aws:kms (SSE-KMS)
+// or aws:kms:dsse. For SSE-C, use WithSSECustomerKey instead.
+func WithServerSideEncryption(sse string) Option {
+	return func(s *S3) {
+		s.serverSideEncryption = sse
+	}
+}
+
+// WithSSEKMSKeyID KMS key ID or ARN for SSE-KMS; implies aws:kms if no server-side encryption is set
+func WithSSEKMSKeyID(keyID string) Option {
+	return func(s *S3) {
+		s.sseKMSKeyID = keyID
+	}
+}
+
+// WithSSECustomerKey base64-encoded 256-bit key for SSE-C, or a reference to it. The same key is required
+// to retrieve the object.
+func WithSSECustomerKey(key string) Option {
+	return func(s *S3) {
+		s.sseCustomerKey = secret.NewLazy(key)
+	}
+}
+
+// WithStorageClass storage class for uploaded objects, e.g. STANDARD_IA, GLACIER_IR, DEEP_ARCHIVE
+func WithStorageClass(storageClass string) Option {
+	return func(s *S3) {
+		s.storageClass = storageClass
+	}
+}
+
+// WithTags tags to apply to uploaded objects
+func WithTags(tags map[string]string) Option {
+	return func(s *S3) {
+		s.tags = tags
+	}
+}
+
+// WithMetadata user metadata to apply to uploaded objects, in addition to the metadata for each backup
+func WithMetadata(metadata map[string]string) Option {
+	return func(s *S3) {
+		s.metadata = metadata
+	}
+}
+
+// WithACL canned ACL for uploaded objects, e.g. private, bucket-owner-full-control
+func WithACL(acl string) Option {
+	return func(s *S3) {
+		s.acl = acl
+	}
+}
+
+// WithChecksumAlgorithm checksum algorithm for uploads, e.g. CRC32, CRC32C, SHA1, SHA256, CRC64NVME
+func WithChecksumAlgorithm(algo string) Option {
+	return func(s *S3) {
+		s.checksumAlgorithm = algo
+	}
+}
+
+// WithObjectLockMode Object Lock retention mode for uploaded objects, GOVERNANCE or COMPLIANCE. The objects
+// are retained until the date given by the retention policy, which must be time-based. The bucket must have
+// Object Lock enabled.
+func WithObjectLockMode(mode string) Option {
+	return func(s *S3) {
+		s.objectLockMode = mode
+	}
+}
+
+// WithObjectLockLegalHold place a legal hold on uploaded objects, which prevents removal until it is
+// explicitly released, independent of any retention date. The bucket must have Object Lock enabled.
+func WithObjectLockLegalHold() Option {
+	return func(s *S3) {
+		s.objectLockLegalHold = true
 	}
 }
 
@@ -73,7 +176,7 @@ func (s *S3) Pull(ctx context.Context, source, target string, logger *log.Entry)
 		return 0, fmt.Errorf("failed to get AWS client: %v", err)
 	}
 
-	bucket, path := s.url.Hostname(), path.Join(s.url.Path, source)
+	bucket, key := s.url.Hostname(), s.key(source)
 
 	// Create a downloader with the session and default options
 	downloader := manager.NewDownloader(client)
@@ -85,11 +188,20 @@ func (s *S3) Pull(ctx context.Context, source, target string, logger *log.Entry)
 	}
 	defer func() { _ = f.Close() }()
 
-	// Write the contents of S3 Object to the file
-	n, err := downloader.Download(context.TODO(), f, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(path),
-	})
+		Key:    aws.String(key),
+	}
+	if s.sseCustomerKey != nil {
+		algo, key, keyMD5, err := s.sseCustomerParams()
+		if err != nil {
+			return 0, err
+		}
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = algo, key, keyMD5
+	}
+
+	// Write the contents of S3 Object to the file
+	n, err := downloader.Download(context.TODO(), ratelimit.Download(ctx).WriterAt(ctx, f), input)
 	if err != nil {
 		return 0, fmt.Errorf("failed to download file, %v", err)
 	}
@@ -102,7 +214,7 @@ func (s *S3) Push(ctx context.Context, target, source string, logger *log.Entry)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get AWS client: %v", err)
 	}
-	bucket, key := s.url.Hostname(), s.url.Path
+	bucket, key := s.url.Hostname(), s.key(target)
 
 	// Create an uploader with the session and default options
 	uploader := manager.NewUploader(client)
@@ -113,24 +225,123 @@ func (s *S3) Push(ctx context.Context, target, source string, logger *log.Entry)
 		return 0, fmt.Errorf("failed to read input file %q, %v", source, err)
 	}
 	defer func() { _ = f.Close() }()
-	countingReader := NewCountingReader(f)
+	countingReader := NewCountingReader(ratelimit.Upload(ctx).Reader(ctx, f))
 
-	// S3 always prepends a /, so if it already has one, it would become //
-	// For some services, that is ok, but for others, it causes issues.
-	key = strings.TrimPrefix(path.Join(key, target), "/")
+	input, err := s.putObjectInput(ctx, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	input.Body = countingReader
 
 	// Write the contents of the file to the S3 object
-	_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   countingReader,
-	})
+	_, err = uploader.Upload(context.TODO(), input)
 	if err != nil {
 		return 0, fmt.Errorf("failed to upload file, %v", err)
 	}
 	return countingReader.Bytes(), nil
 }
 
+// putObjectInput build the input for uploading an object, applying all of the configured
+// object settings and any backup metadata from the context. The Body is left for the caller.
+func (s *S3) putObjectInput(ctx context.Context, bucket, key string) (*s3.PutObjectInput, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+
+	sse := s.serverSideEncryption
+	if sse == "" && s.sseKMSKeyID != "" {
+		sse = string(types.ServerSideEncryptionAwsKms)
+	}
+	if sse != "" {
+		if s.sseCustomerKey != nil {
+			return nil, fmt.Errorf("cannot use both server-side encryption %s and a customer-provided key", sse)
+		}
+		if !slices.Contains(types.ServerSideEncryption("").Values(), types.ServerSideEncryption(sse)) {
+			return nil, fmt.Errorf("invalid server-side encryption %q", sse)
+		}
+		input.ServerSideEncryption = types.ServerSideEncryption(sse)
+	}
+	if s.sseKMSKeyID != "" {
+		if input.ServerSideEncryption != types.ServerSideEncryptionAwsKms && input.ServerSideEncryption != types.ServerSideEncryptionAwsKmsDsse {
+			return nil, fmt.Errorf("KMS key ID requires server-side encryption aws:kms or aws:kms:dsse, not %s", sse)
+		}
+		input.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+	}
+	if s.sseCustomerKey != nil {
+		algo, key, keyMD5, err := s.sseCustomerParams()
+		if err != nil {
+			return nil, err
+		}
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = algo, key, keyMD5
+	}
+	if s.storageClass != "" {
+		if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(s.storageClass)) {
+			return nil, fmt.Errorf("invalid storage class %q", s.storageClass)
+		}
+		input.StorageClass = types.StorageClass(s.storageClass)
+	}
+	if s.acl != "" {
+		if !slices.Contains(types.ObjectCannedACL("").Values(), types.ObjectCannedACL(s.acl)) {
+			return nil, fmt.Errorf("invalid ACL %q", s.acl)
+		}
+		input.ACL = types.ObjectCannedACL(s.acl)
+	}
+	if s.checksumAlgorithm != "" {
+		algo := types.ChecksumAlgorithm(strings.ToUpper(s.checksumAlgorithm))
+		if !slices.Contains(types.ChecksumAlgorithm("").Values(), algo) {
+			return nil, fmt.Errorf("invalid checksum algorithm %q", s.checksumAlgorithm)
+		}
+		input.ChecksumAlgorithm = algo
+	}
+	if len(s.tags) > 0 {
+		tags := url.Values{}
+		for k, v := range s.tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if s.objectLockMode != "" {
+		mode := types.ObjectLockMode(strings.ToUpper(s.objectLockMode))
+		if !slices.Contains(types.ObjectLockMode("").Values(), mode) {
+			return nil, fmt.Errorf("invalid object lock mode %q", s.objectLockMode)
+		}
+		retainUntil := util.RetainUntilFromContext(ctx)
+		if retainUntil.IsZero() {
+			return nil, fmt.Errorf("object lock mode %s requires a time-based retention policy", mode)
+		}
+		input.ObjectLockMode = mode
+		input.ObjectLockRetainUntilDate = aws.Time(retainUntil)
+	}
+	if s.objectLockLegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+	metadata := map[string]string{}
+	maps.Copy(metadata, s.metadata)
+	maps.Copy(metadata, util.ObjectMetadataFromContext(ctx))
+	if len(metadata) > 0 {
+		input.Metadata = metadata
+	}
+	return input, nil
+}
+
+// sseCustomerParams get the algorithm, key and key MD5 for SSE-C requests
+func (s *S3) sseCustomerParams() (algo, key, keyMD5 *string, err error) {
+	customerKey, err := s.sseCustomerKey.Get()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get SSE-C customer key: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(customerKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("SSE-C customer key must be base64-encoded: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, nil, nil, fmt.Errorf("SSE-C customer key must be 32 bytes, not %d", len(raw))
+	}
+	sum := md5.Sum(raw)
+	return aws.String(sseCustomerAlgorithm), aws.String(customerKey), aws.String(base64.StdEncoding.EncodeToString(sum[:])), nil
+}
+
 func (s *S3) Clean(filename string) string {
 	return filename
 }
@@ -150,22 +361,37 @@ func (s *S3) ReadDir(ctx context.Context, dirname string, logger *log.Entry) ([]
 		return nil, fmt.Errorf("failed to get AWS client: %v", err)
 	}
 
-	// Call ListObjectsV2 with your bucket and prefix
-	// ensure that there is no leading /
-	p := strings.TrimPrefix(filepath.Join(s.url.Path, dirname), "/")
-	result, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{Bucket: aws.String(s.url.Hostname()), Prefix: aws.String(filepath.Join(p, dirname))})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects, %v", err)
+	// list only the immediate contents of the directory, like a filesystem would; anything deeper
+	// is returned as a common prefix, which we treat as a directory.
+	prefix := s.key(dirname)
+	if prefix != "" {
+		prefix += "/"
 	}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.url.Hostname()),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
 
-	// Convert s3.Object to fs.FileInfo
 	var files []fs.FileInfo
-	for _, item := range result.Contents {
-		files = append(files, &s3FileInfo{
-			name:         *item.Key,
-			lastModified: *item.LastModified,
-			size:         *item.Size,
-		})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects, %v", err)
+		}
+		for _, item := range page.CommonPrefixes {
+			files = append(files, &s3FileInfo{
+				name:  strings.TrimSuffix(strings.TrimPrefix(aws.ToString(item.Prefix), prefix), "/"),
+				isDir: true,
+			})
+		}
+		for _, item := range page.Contents {
+			files = append(files, &s3FileInfo{
+				name:         strings.TrimPrefix(aws.ToString(item.Key), prefix),
+				lastModified: aws.ToTime(item.LastModified),
+				size:         aws.ToInt64(item.Size),
+			})
+		}
 	}
 
 	return files, nil
@@ -179,9 +405,9 @@ func (s *S3) Remove(ctx context.Context, target string, logger *log.Entry) error
 	}
 
 	// Call DeleteObject with your bucket and the key of the object you want to delete
-	_, err = client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.url.Hostname()),
-		Key:    aws.String(target),
+		Key:    aws.String(s.key(target)),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete object, %v", err)
@@ -190,6 +416,112 @@ func (s *S3) Remove(ctx context.Context, target string, logger *log.Entry) error
 	return nil
 }
 
+// RemoveBatch remove many files, using as few DeleteObjects requests as possible
+func (s *S3) RemoveBatch(ctx context.Context, targets []string, logger *log.Entry) error {
+	client, err := s.getClient(logger)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS client: %v", err)
+	}
+
+	var errs []error
+	for batch := range slices.Chunk(targets, maxDeleteObjects) {
+		objects := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, target := range batch {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(s.key(target))})
+		}
+		logger.Debugf("deleting batch of %d objects", len(objects))
+		result, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.url.Hostname()),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects, %v", err)
+		}
+		for _, e := range result.Errors {
+			errs = append(errs, fmt.Errorf("failed to delete object %s: %s %s", aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// key get the object key for a file, relative to the path in the URL. S3 keys have no leading /.
+func (s *S3) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.url.Path, name), "/")
+}
+
+// Locked report whether the object is protected by Object Lock, either by a retention date
+// that has not yet passed or by a legal hold. Objects in GOVERNANCE mode are reported as locked
+// as well, as bypassing governance retention is a deliberate act that prune should not take.
+func (s *S3) Locked(ctx context.Context, target string, logger *log.Entry) (bool, string, error) {
+	head, err := s.headObject(ctx, target, logger)
+	if err != nil {
+		return false, "", err
+	}
+	if head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn {
+		return true, "object lock legal hold is on", nil
+	}
+	if head.ObjectLockMode != "" && head.ObjectLockRetainUntilDate != nil && head.ObjectLockRetainUntilDate.After(time.Now()) {
+		return true, fmt.Sprintf("object lock %s retention until %s", head.ObjectLockMode, head.ObjectLockRetainUntilDate.UTC().Format(time.RFC3339)), nil
+	}
+	return false, "", nil
+}
+
+// Checksums get the checksums of the backup from the object metadata, where Push stores them
+func (s *S3) Checksums(ctx context.Context, target string, logger *log.Entry) (map[string]string, error) {
+	head, err := s.headObject(ctx, target, logger)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]string{}
+	for k, v := range head.Metadata {
+		// S3 returns metadata keys in lower case
+		if algorithm, ok := strings.CutPrefix(strings.ToLower(k), util.MetadataChecksumPrefix); ok {
+			sums[algorithm] = v
+		}
+	}
+	return sums, nil
+}
+
+// Metadata get the object metadata of the backup, and the time until which it is retained by object lock
+func (s *S3) Metadata(ctx context.Context, target string, logger *log.Entry) (map[string]string, time.Time, error) {
+	head, err := s.headObject(ctx, target, logger)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	metadata := map[string]string{}
+	for k, v := range head.Metadata {
+		metadata[strings.ToLower(k)] = v
+	}
+	var retainUntil time.Time
+	if head.ObjectLockRetainUntilDate != nil {
+		retainUntil = *head.ObjectLockRetainUntilDate
+	}
+	return metadata, retainUntil, nil
+}
+
+func (s *S3) headObject(ctx context.Context, target string, logger *log.Entry) (*s3.HeadObjectOutput, error) {
+	client, err := s.getClient(logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS client: %v", err)
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.url.Hostname()),
+		Key:    aws.String(s.key(target)),
+	}
+	if s.sseCustomerKey != nil {
+		algo, key, keyMD5, err := s.sseCustomerParams()
+		if err != nil {
+			return nil, err
+		}
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = algo, key, keyMD5
+	}
+	head, err := client.HeadObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %v", target, err)
+	}
+	return head, nil
+}
+
 func (s *S3) getClient(logger *log.Entry) (*s3.Client, error) {
 	// Get the AWS config
 	var configOpts []func(*config.LoadOptions) error // global client options
@@ -199,10 +531,18 @@ func (s *S3) getClient(logger *log.Entry) (*s3.Client, error) {
 	if s.region != "" {
 		configOpts = append(configOpts, config.WithRegion(s.region))
 	}
-	if s.accessKeyId != "" {
+	if s.accessKeyId != nil {
+		accessKeyId, err := s.accessKeyId.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get AWS access key ID: %w", err)
+		}
+		secretAccessKey, err := s.secretAccessKey.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get AWS secret access key: %w", err)
+		}
 		configOpts = append(configOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			s.accessKeyId,
-			s.secretAccessKey,
+			accessKeyId,
+			secretAccessKey,
 			"",
 		)))
 	}
@@ -258,11 +598,17 @@ type s3FileInfo struct {
 	name         string
 	lastModified time.Time
 	size         int64
+	isDir        bool
 }
 
 func (s s3FileInfo) Name() string       { return s.name }
 func (s s3FileInfo) Size() int64        { return s.size }
-func (s s3FileInfo) Mode() os.FileMode  { return 0 } // Not applicable in S3
 func (s s3FileInfo) ModTime() time.Time { return s.lastModified }
-func (s s3FileInfo) IsDir() bool        { return false } // Not applicable in S3
-func (s s3FileInfo) Sys() interface{}   { return nil }   // Not applicable in S3
+func (s s3FileInfo) IsDir() bool        { return s.isDir } // common prefixes, when listing with a delimiter
+func (s s3FileInfo) Sys() interface{}   { return nil }     // Not applicable in S3
+func (s s3FileInfo) Mode() os.FileMode {
+	if s.isDir {
+		return fs.ModeDir
+	}
+	return 0 // Not applicable in S3
+}
@@ -0,0 +1,77 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/databacker/mysql-backup/pkg/util"
+)
+
+func TestPutObjectInput(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	// the MD5 of 32 times "k", base64-encoded
+	customerKeyMD5 := "mT2HRsMGJ5IX5C+0rreZ8Q=="
+	retainUntil := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		ctx      context.Context
+		expected *s3.PutObjectInput
+		err      string
+	}{
+		{"none", nil, nil, &s3.PutObjectInput{}, ""},
+		{"sse-s3", []Option{WithServerSideEncryption("AES256")}, nil, &s3.PutObjectInput{ServerSideEncryption: types.ServerSideEncryptionAes256}, ""},
+		{"sse-kms with key", []Option{WithServerSideEncryption("aws:kms"), WithSSEKMSKeyID("key-id")}, nil, &s3.PutObjectInput{ServerSideEncryption: types.ServerSideEncryptionAwsKms, SSEKMSKeyId: aws.String("key-id")}, ""},
+		{"kms key implies aws:kms", []Option{WithSSEKMSKeyID("key-id")}, nil, &s3.PutObjectInput{ServerSideEncryption: types.ServerSideEncryptionAwsKms, SSEKMSKeyId: aws.String("key-id")}, ""},
+		{"kms key with dsse", []Option{WithServerSideEncryption("aws:kms:dsse"), WithSSEKMSKeyID("key-id")}, nil, &s3.PutObjectInput{ServerSideEncryption: types.ServerSideEncryptionAwsKmsDsse, SSEKMSKeyId: aws.String("key-id")}, ""},
+		{"kms key without aws:kms", []Option{WithServerSideEncryption("AES256"), WithSSEKMSKeyID("key-id")}, nil, nil, "KMS key ID requires server-side encryption aws:kms"},
+		{"invalid sse", []Option{WithServerSideEncryption("rot13")}, nil, nil, `invalid server-side encryption "rot13"`},
+		{"sse-c", []Option{WithSSECustomerKey(customerKey)}, nil, &s3.PutObjectInput{SSECustomerAlgorithm: aws.String("AES256"), SSECustomerKey: aws.String(customerKey), SSECustomerKeyMD5: aws.String(customerKeyMD5)}, ""},
+		{"sse-c without a key", []Option{WithServerSideEncryption("SSE-C")}, nil, nil, `invalid server-side encryption "SSE-C"`},
+		{"sse-c and sse", []Option{WithServerSideEncryption("AES256"), WithSSECustomerKey(customerKey)}, nil, nil, "cannot use both server-side encryption AES256 and a customer-provided key"},
+		{"sse-c and kms key", []Option{WithSSEKMSKeyID("key-id"), WithSSECustomerKey(customerKey)}, nil, nil, "cannot use both server-side encryption aws:kms and a customer-provided key"},
+		{"sse-c key not base64", []Option{WithSSECustomerKey("not base64!")}, nil, nil, "must be base64-encoded"},
+		{"sse-c key too short", []Option{WithSSECustomerKey(base64.StdEncoding.EncodeToString([]byte("short")))}, nil, nil, "must be 32 bytes, not 5"},
+		{"storage class", []Option{WithStorageClass("GLACIER_IR")}, nil, &s3.PutObjectInput{StorageClass: types.StorageClassGlacierIr}, ""},
+		{"invalid storage class", []Option{WithStorageClass("COLD")}, nil, nil, `invalid storage class "COLD"`},
+		{"acl", []Option{WithACL("bucket-owner-full-control")}, nil, &s3.PutObjectInput{ACL: types.ObjectCannedACLBucketOwnerFullControl}, ""},
+		{"invalid acl", []Option{WithACL("everyone")}, nil, nil, `invalid ACL "everyone"`},
+		{"checksum algorithm", []Option{WithChecksumAlgorithm("sha256")}, nil, &s3.PutObjectInput{ChecksumAlgorithm: types.ChecksumAlgorithmSha256}, ""},
+		{"invalid checksum algorithm", []Option{WithChecksumAlgorithm("md5")}, nil, nil, `invalid checksum algorithm "md5"`},
+		{"tags", []Option{WithTags(map[string]string{"env": "prod", "team": "db ops"})}, nil, &s3.PutObjectInput{Tagging: aws.String("env=prod&team=db+ops")}, ""},
+		{"object lock", []Option{WithObjectLockMode("compliance")}, util.ContextWithRetainUntil(context.Background(), retainUntil), &s3.PutObjectInput{ObjectLockMode: types.ObjectLockModeCompliance, ObjectLockRetainUntilDate: aws.Time(retainUntil)}, ""},
+		{"object lock without retention", []Option{WithObjectLockMode("GOVERNANCE")}, nil, nil, "object lock mode GOVERNANCE requires a time-based retention policy"},
+		{"invalid object lock mode", []Option{WithObjectLockMode("forever")}, util.ContextWithRetainUntil(context.Background(), retainUntil), nil, `invalid object lock mode "forever"`},
+		{"legal hold", []Option{WithObjectLockLegalHold()}, nil, &s3.PutObjectInput{ObjectLockLegalHoldStatus: types.ObjectLockLegalHoldStatusOn}, ""},
+		{"metadata", []Option{WithMetadata(map[string]string{"owner": "dba", "run-id": "configured"})}, util.ContextWithObjectMetadata(context.Background(), map[string]string{"run-id": "abc"}), &s3.PutObjectInput{Metadata: map[string]string{"owner": "dba", "run-id": "abc"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			s := New(url.URL{Scheme: "s3", Host: "bucket"}, tt.opts...)
+			input, err := s.putObjectInput(ctx, "bucket", "path/to/file")
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			tt.expected.Bucket = aws.String("bucket")
+			tt.expected.Key = aws.String("path/to/file")
+			assert.Equal(t, tt.expected, input)
+		})
+	}
+}
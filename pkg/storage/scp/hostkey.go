@@ -0,0 +1,217 @@
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// values for StrictHostKeyChecking, matching the semantics of ssh_config(5) as closely as
+// possible for a non-interactive client
+const (
+	// StrictHostKeyCheckingYes never add host keys automatically; refuse to connect to unknown hosts
+	StrictHostKeyCheckingYes = "yes"
+	// StrictHostKeyCheckingAsk is treated as "yes", as there is nobody to ask
+	StrictHostKeyCheckingAsk = "ask"
+	// StrictHostKeyCheckingAcceptNew add unknown hosts to known_hosts, refuse changed keys. This is the default.
+	StrictHostKeyCheckingAcceptNew = "accept-new"
+	// StrictHostKeyCheckingNo add unknown hosts to known_hosts, accept changed keys
+	StrictHostKeyCheckingNo = "no"
+	// StrictHostKeyCheckingOff same as "no"
+	StrictHostKeyCheckingOff = "off"
+
+	defaultStrictHostKeyChecking = StrictHostKeyCheckingAcceptNew
+
+	// fingerprintQueryParam URL query parameter for pinning the host key fingerprint, e.g.
+	// scp://host/path?fingerprint=SHA256:...
+	fingerprintQueryParam = "fingerprint"
+
+	fingerprintPrefixSHA256 = "SHA256:"
+	fingerprintPrefixMD5    = "MD5:"
+)
+
+// hostKeyPolicy everything needed to decide whether to trust a host key
+type hostKeyPolicy struct {
+	// fingerprints pinned fingerprints; if any are set, known_hosts is not consulted at all
+	fingerprints []string
+	// knownHostsFiles files to check for known hosts; new hosts are added to the first one
+	knownHostsFiles []string
+	// strict one of the StrictHostKeyChecking values
+	strict string
+}
+
+// callback get an ssh.HostKeyCallback that applies the policy
+func (p hostKeyPolicy) callback() (ssh.HostKeyCallback, error) {
+	switch p.strict {
+	case StrictHostKeyCheckingYes, StrictHostKeyCheckingAsk, StrictHostKeyCheckingAcceptNew, StrictHostKeyCheckingNo, StrictHostKeyCheckingOff:
+	default:
+		return nil, fmt.Errorf("invalid StrictHostKeyChecking value %q", p.strict)
+	}
+	for _, fp := range p.fingerprints {
+		if !strings.HasPrefix(fp, fingerprintPrefixSHA256) && !strings.HasPrefix(fp, fingerprintPrefixMD5) {
+			return nil, fmt.Errorf("invalid host key fingerprint %q, must start with %s or %s", fp, fingerprintPrefixSHA256, fingerprintPrefixMD5)
+		}
+	}
+	if len(p.fingerprints) > 0 {
+		return p.checkFingerprint, nil
+	}
+	if len(p.knownHostsFiles) == 0 {
+		return nil, errors.New("no known_hosts file configured")
+	}
+	return p.checkKnownHosts, nil
+}
+
+// checkFingerprint accept the host key only if it matches one of the pinned fingerprints
+func (p hostKeyPolicy) checkFingerprint(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	sha := ssh.FingerprintSHA256(key)
+	md5 := fingerprintPrefixMD5 + ssh.FingerprintLegacyMD5(key)
+	for _, fp := range p.fingerprints {
+		if fp == sha || strings.EqualFold(fp, md5) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host key for %s has fingerprint %s, which does not match any pinned fingerprint", hostname, sha)
+}
+
+// existingKnownHostsFiles the known_hosts files that exist; the others are ignored, as ssh does
+func (p hostKeyPolicy) existingKnownHostsFiles() []string {
+	var existing []string
+	for _, f := range p.knownHostsFiles {
+		if stat, err := os.Stat(f); err == nil && !stat.IsDir() {
+			existing = append(existing, f)
+		}
+	}
+	return existing
+}
+
+// hostKeyAlgorithms the algorithms of the keys in known_hosts for the address, so that a server with several
+// host keys offers one that can be verified, rather than the one that it prefers. nil if fingerprints are pinned
+// or no key is known, so that any algorithm is accepted.
+func (p hostKeyPolicy) hostKeyAlgorithms(address string) []string {
+	existing := p.existingKnownHostsFiles()
+	if len(p.fingerprints) > 0 || len(existing) == 0 {
+		return nil
+	}
+	cb, err := knownhosts.New(existing...)
+	if err != nil {
+		// the callback reports it when connecting
+		return nil
+	}
+	// a key that is never in known_hosts, so that the error lists the keys that are
+	var keyErr *knownhosts.KeyError
+	if err := cb(address, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, want := range keyErr.Want {
+		keyAlgorithms := []string{want.Key.Type()}
+		if want.Key.Type() == ssh.KeyAlgoRSA {
+			// the same key signs with any of these, preferring SHA-2, as ssh does
+			keyAlgorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algorithm := range keyAlgorithms {
+			if !slices.Contains(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+// placeholderKey a public key of a type that no known_hosts file contains
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string                        { return "placeholder" }
+func (placeholderKey) Marshal() []byte                     { return []byte{} }
+func (placeholderKey) Verify([]byte, *ssh.Signature) error { return errors.New("placeholder key") }
+
+// checkKnownHosts check the host key against the known_hosts files, adding it if unknown and the policy permits
+func (p hostKeyPolicy) checkKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if existing := p.existingKnownHostsFiles(); len(existing) > 0 {
+		cb, err := knownhosts.New(existing...)
+		if err != nil {
+			return fmt.Errorf("failed to read known_hosts files %v: %w", existing, err)
+		}
+		err = cb(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		switch {
+		case !errors.As(err, &keyErr):
+			// revoked, or something else that we never accept
+			return fmt.Errorf("host key verification failed for %s: %w", hostname, err)
+		case len(keyErr.Want) > 0 && (p.strict == StrictHostKeyCheckingNo || p.strict == StrictHostKeyCheckingOff):
+			// changed key, but the policy says to accept it anyways
+			return nil
+		case len(keyErr.Want) > 0:
+			return fmt.Errorf("host key verification failed for %s, key %s does not match known_hosts; possible man-in-the-middle attack: %w", hostname, ssh.FingerprintSHA256(key), err)
+		}
+	}
+
+	// if we got here, the host is unknown
+	switch p.strict {
+	case StrictHostKeyCheckingYes, StrictHostKeyCheckingAsk:
+		return fmt.Errorf("host key %s for %s not found in known_hosts %v and strict host key checking is enabled", ssh.FingerprintSHA256(key), hostname, p.knownHostsFiles)
+	}
+	if err := appendKnownHost(p.knownHostsFiles[0], hostname, remote, key); err != nil {
+		return fmt.Errorf("failed to add host key for %s to known_hosts: %w", hostname, err)
+	}
+	return nil
+}
+
+// knownHostsLocks one lock for each known_hosts file, so that concurrent connections to a new host,
+// e.g. for uploads in parallel, do not interleave their writes or add the host more than once
+var knownHostsLocks sync.Map
+
+// appendKnownHost add the key for the host to the known_hosts file, creating it if necessary,
+// unless another connection added it already
+func appendKnownHost(filename, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	lock, _ := knownHostsLocks.LoadOrStore(filepath.Clean(filename), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(filename); err == nil && remote != nil {
+		if cb, err := knownhosts.New(filename); err == nil && cb(hostname, remote, key) == nil {
+			return nil
+		}
+	}
+
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil {
+		if ra := knownhosts.Normalize(remote.String()); ra != addresses[0] {
+			addresses = append(addresses, ra)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, knownhosts.Line(addresses, key)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// expandHome expand a leading ~ in a path to the user's home directory
+func expandHome(p string) string {
+	if p == "~" {
+		return os.Getenv("HOME")
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(os.Getenv("HOME"), p[2:])
+	}
+	return p
+}
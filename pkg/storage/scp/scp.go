@@ -3,13 +3,17 @@ package scp
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	scp "github.com/bramvdbogaerde/go-scp"
@@ -18,6 +22,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/secret"
 )
 
 var baseIdentityFileNames = []string{
@@ -28,11 +35,17 @@ var baseIdentityFileNames = []string{
 	"id_rsa",        // still common, though SHA-1 is discouraged
 }
 
-func getIdentityFiles() []string {
-	idFileDir := os.Getenv("SSH_HOME")
-	if idFileDir == "" {
-		idFileDir = filepath.Join(os.Getenv("HOME"), ".ssh")
+// sshDir the directory in which to find ssh config, identity and known_hosts files
+func sshDir() string {
+	dir := os.Getenv("SSH_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".ssh")
 	}
+	return dir
+}
+
+func getIdentityFiles() []string {
+	idFileDir := sshDir()
 	var files []string
 	for _, name := range baseIdentityFileNames {
 		filename := filepath.Join(idFileDir, name)
@@ -44,12 +57,108 @@ func getIdentityFiles() []string {
 	return files
 }
 
+const (
+	defaultSSHPort = "22"
+)
+
 type SCP struct {
-	url url.URL
+	url                   url.URL
+	username              string
+	password              *secret.Lazy
+	privateKey            *secret.Lazy
+	privateKeyFile        string
+	passphrase            *secret.Lazy
+	port                  int
+	jumpHost              string
+	knownHostsFile        string
+	hostKeyFingerprints   []string
+	strictHostKeyChecking string
+}
+
+type Option func(s *SCP)
+
+// WithUsername user to log in as, overriding the URL and ssh config
+func WithUsername(username string) Option {
+	return func(s *SCP) {
+		s.username = username
+	}
+}
+
+// WithPassword password for password authentication, tried after any keys, or a reference to it
+func WithPassword(password string) Option {
+	return func(s *SCP) {
+		s.password = secret.NewLazy(password)
+	}
+}
+
+// WithPrivateKey PEM-encoded private key to use for authentication, in addition to the ssh agent, or
+// a reference to it. When a key is provided, the default identity files are not used.
+func WithPrivateKey(key []byte) Option {
+	return func(s *SCP) {
+		s.privateKey = secret.NewLazy(string(key))
+	}
+}
+
+// WithPrivateKeyFile path to a private key file to use for authentication, in addition to the ssh agent.
+// When a key is provided, the default identity files are not used.
+func WithPrivateKeyFile(path string) Option {
+	return func(s *SCP) {
+		s.privateKeyFile = path
+	}
+}
+
+// WithPassphrase passphrase to decrypt encrypted private keys, or a reference to it
+func WithPassphrase(passphrase string) Option {
+	return func(s *SCP) {
+		s.passphrase = secret.NewLazy(passphrase)
+	}
+}
+
+// WithPort port to connect to, overriding the URL and ssh config
+func WithPort(port int) Option {
+	return func(s *SCP) {
+		s.port = port
+	}
+}
+
+// WithJumpHost connect via one or more jump hosts, comma-separated, each [user@]host[:port];
+// same as ProxyJump in the ssh config, which it overrides
+func WithJumpHost(jumpHost string) Option {
+	return func(s *SCP) {
+		s.jumpHost = jumpHost
+	}
+}
+
+// WithKnownHostsFile use the given known_hosts file, instead of UserKnownHostsFile from the ssh config
+// or the default known_hosts in the ssh directory
+func WithKnownHostsFile(path string) Option {
+	return func(s *SCP) {
+		s.knownHostsFile = path
+	}
+}
+
+// WithHostKeyFingerprints pin the host key to one of the given fingerprints, in the format
+// output by `ssh-keygen -l`, e.g. SHA256:<base64>. When set, known_hosts is not used.
+func WithHostKeyFingerprints(fingerprints ...string) Option {
+	return func(s *SCP) {
+		s.hostKeyFingerprints = append(s.hostKeyFingerprints, fingerprints...)
+	}
+}
+
+// WithStrictHostKeyChecking set the policy for unknown hosts, overriding StrictHostKeyChecking from the ssh config.
+// Must be one of the StrictHostKeyChecking* values.
+func WithStrictHostKeyChecking(mode string) Option {
+	return func(s *SCP) {
+		s.strictHostKeyChecking = mode
+	}
 }
 
-func New(u url.URL) *SCP {
-	return &SCP{u}
+func New(u url.URL, opts ...Option) *SCP {
+	s := &SCP{url: u}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *SCP) Pull(ctx context.Context, source, target string, logger *log.Entry) (int64, error) {
@@ -69,7 +178,7 @@ func (s *SCP) Pull(ctx context.Context, source, target string, logger *log.Entry
 		_ = f.Close()
 	}()
 
-	if err := client.CopyFromRemote(ctx, f, source); err != nil {
+	if err := client.CopyFromRemotePassThru(ctx, f, source, passThru(ctx, ratelimit.Download(ctx))); err != nil {
 		return 0, fmt.Errorf("failed to copy file from SCP server: %w", err)
 	}
 	stat, err := f.Stat()
@@ -97,7 +206,7 @@ func (s *SCP) Push(ctx context.Context, target, source string, logger *log.Entry
 		_ = f.Close()
 	}()
 
-	if err := client.CopyFromFile(ctx, *f, target, "0644"); err != nil {
+	if err := client.CopyFromFilePassThru(ctx, *f, target, "0644", passThru(ctx, ratelimit.Upload(ctx))); err != nil {
 		return 0, fmt.Errorf("failed to copy file to SCP server: %w", err)
 	}
 	stat, err := f.Stat()
@@ -107,6 +216,16 @@ func (s *SCP) Push(ctx context.Context, target, source string, logger *log.Entry
 	return stat.Size(), nil
 }
 
+// passThru limit the rate of copying, if there is a limiter
+func passThru(ctx context.Context, limiter *ratelimit.Limiter) scp.PassThru {
+	if limiter == nil {
+		return nil
+	}
+	return func(r io.Reader, total int64) io.Reader {
+		return limiter.Reader(ctx, r)
+	}
+}
+
 func (s *SCP) Clean(filename string) string {
 	return filename
 }
@@ -190,60 +309,170 @@ func (s *SCP) getSSHClient() (*ssh.Client, error) {
 		return nil, fmt.Errorf("failed to load SSH config: %w", err)
 	}
 	// check items as provided against ssh config, URL override, defaults
-	hostname := s.url.Hostname()
-	port := s.url.Port()
-	username := s.url.User.Username()
-	var identityFiles []string
-	configPort, err := sshConfig.Get(hostname, "Port")
+	alias := s.url.Hostname()
+	endpoint, err := resolveEndpoint(sshConfig, alias, s.url.User.Username(), s.url.Port())
 	if err != nil {
-		return nil, fmt.Errorf("error getting hostname from SSH config: %w", err)
+		return nil, err
 	}
-	configHostname, err := sshConfig.Get(hostname, "HostName")
-	if err != nil {
-		return nil, fmt.Errorf("error getting port from SSH config: %w", err)
+	// explicit options override everything
+	if s.username != "" {
+		endpoint.username = s.username
 	}
-	configIdentityFile, err := sshConfig.Get(hostname, "IdentityFile")
-	if err != nil {
-		return nil, fmt.Errorf("error getting identity file from SSH config: %w", err)
+	if s.port != 0 {
+		endpoint.port = strconv.Itoa(s.port)
 	}
-	configUsername, err := sshConfig.Get(hostname, "User")
-	if err != nil {
-		return nil, fmt.Errorf("error getting username from SSH config: %w", err)
-	}
-	if configPort != "" {
-		port = configPort
+
+	var (
+		identityFiles []string
+		keys          [][]byte
+	)
+	if s.privateKey != nil {
+		key, err := s.privateKey.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get private key: %w", err)
+		}
+		keys = append(keys, []byte(key))
 	}
-	if configHostname != "" {
-		hostname = configHostname
+	if s.privateKeyFile != "" {
+		key, err := os.ReadFile(s.privateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file %s: %w", s.privateKeyFile, err)
+		}
+		keys = append(keys, key)
 	}
-	if configUsername != "" {
-		username = configUsername
+	configIdentityFile, err := sshConfig.Get(alias, "IdentityFile")
+	if err != nil {
+		return nil, fmt.Errorf("error getting identity file from SSH config: %w", err)
 	}
 	if configIdentityFile != "" {
-		identityFiles = append(identityFiles, configIdentityFile)
+		identityFiles = append(identityFiles, expandHome(configIdentityFile))
 	}
 	// look for fixed identity files, if none explicitly specified
-	if len(identityFiles) == 0 {
+	if len(identityFiles) == 0 && len(keys) == 0 {
 		identityFiles = getIdentityFiles()
 	}
-	authMethods, err := authMethodsFromAgentAndFiles(identityFiles)
+	password, err := s.password.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH password: %w", err)
+	}
+	if password == "" {
+		password, _ = s.url.User.Password()
+	}
+	passphrase, err := s.passphrase.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get private key passphrase: %w", err)
+	}
+	authMethods, err := authMethodsFromAgentAndFiles(keys, identityFiles, []byte(passphrase), password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get SSH auth methods: %w", err)
 	}
-	clientConfig := &ssh.ClientConfig{
-		User:            username,
-		Auth:            authMethods,
-		Timeout:         15 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: use a proper host key callback in production
+
+	// connect via any jump hosts first, in order
+	jumpHosts := s.jumpHost
+	if jumpHosts == "" {
+		if jumpHosts, err = sshConfig.Get(alias, "ProxyJump"); err != nil {
+			return nil, fmt.Errorf("error getting proxy jump from SSH config: %w", err)
+		}
+	}
+	if strings.EqualFold(jumpHosts, "none") {
+		jumpHosts = ""
+	}
+	var via *ssh.Client
+	for _, hop := range strings.Split(jumpHosts, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop == "" {
+			continue
+		}
+		hopUser, hopHost, hopPort := parseJumpHost(hop)
+		hopEndpoint, err := resolveEndpoint(sshConfig, hopHost, hopUser, hopPort)
+		if err != nil {
+			return nil, err
+		}
+		if hopEndpoint.username == "" {
+			hopEndpoint.username = endpoint.username
+		}
+		hopConfig, err := s.clientConfig(sshConfig, hopEndpoint, authMethods, false)
+		if err != nil {
+			return nil, err
+		}
+		if via, err = dialSSH(via, hopEndpoint.address(), hopConfig); err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH jump host %s: %w", hop, err)
+		}
 	}
 
-	client, err := ssh.Dial("tcp", net.JoinHostPort(hostname, port), clientConfig)
+	clientConfig, err := s.clientConfig(sshConfig, endpoint, authMethods, true)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dialSSH(via, endpoint.address(), clientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
 	}
 	return client, nil
 }
 
+// clientConfig get the ssh client config for connecting to a particular endpoint. Pinned fingerprints
+// apply only to the target itself, not to any jump hosts.
+func (s *SCP) clientConfig(sshConfig *ssh_config.Config, endpoint sshEndpoint, authMethods []ssh.AuthMethod, target bool) (*ssh.ClientConfig, error) {
+	policy, err := s.hostKeyPolicy(sshConfig, endpoint.alias, target)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := policy.callback()
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key verification settings for %s: %w", endpoint.alias, err)
+	}
+	return &ssh.ClientConfig{
+		User:              endpoint.username,
+		Auth:              authMethods,
+		Timeout:           15 * time.Second,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: policy.hostKeyAlgorithms(endpoint.address()),
+	}, nil
+}
+
+// hostKeyPolicy determine how to verify the host key. Explicit options override the URL, which
+// overrides the ssh config, which overrides the defaults.
+func (s *SCP) hostKeyPolicy(sshConfig *ssh_config.Config, alias string, target bool) (hostKeyPolicy, error) {
+	policy := hostKeyPolicy{
+		strict: s.strictHostKeyChecking,
+	}
+	if target {
+		policy.fingerprints = s.hostKeyFingerprints
+		for _, fp := range s.url.Query()[fingerprintQueryParam] {
+			// base64 may include '+', which turns into a space if the URL was not properly escaped
+			policy.fingerprints = append(policy.fingerprints, strings.ReplaceAll(fp, " ", "+"))
+		}
+	}
+
+	if s.knownHostsFile != "" {
+		policy.knownHostsFiles = []string{s.knownHostsFile}
+	} else {
+		configKnownHosts, err := sshConfig.Get(alias, "UserKnownHostsFile")
+		if err != nil {
+			return policy, fmt.Errorf("error getting known hosts file from SSH config: %w", err)
+		}
+		for _, f := range strings.Fields(configKnownHosts) {
+			policy.knownHostsFiles = append(policy.knownHostsFiles, expandHome(f))
+		}
+		if len(policy.knownHostsFiles) == 0 {
+			policy.knownHostsFiles = []string{filepath.Join(sshDir(), "known_hosts")}
+		}
+	}
+
+	if policy.strict == "" {
+		configStrict, err := sshConfig.Get(alias, "StrictHostKeyChecking")
+		if err != nil {
+			return policy, fmt.Errorf("error getting strict host key checking from SSH config: %w", err)
+		}
+		policy.strict = strings.ToLower(configStrict)
+	}
+	if policy.strict == "" {
+		policy.strict = defaultStrictHostKeyChecking
+	}
+	return policy, nil
+}
+
 func (s *SCP) getSCPClient() (*scp.Client, error) {
 	sshClient, err := s.getSSHClient()
 	if err != nil {
@@ -258,11 +487,7 @@ func (s *SCP) getSCPClient() (*scp.Client, error) {
 }
 
 func loadSSHConfig() (*ssh_config.Config, error) {
-	idFileDir := os.Getenv("SSH_HOME")
-	if idFileDir == "" {
-		idFileDir = filepath.Join(os.Getenv("HOME"), ".ssh")
-	}
-	path := filepath.Join(idFileDir, "config")
+	path := filepath.Join(sshDir(), "config")
 	f, err := os.Open(path)
 	if err != nil {
 		// No config is fine; act like empty config.
@@ -272,11 +497,20 @@ func loadSSHConfig() (*ssh_config.Config, error) {
 	return ssh_config.Decode(f)
 }
 
-func authMethodsFromAgentAndFiles(identityFiles []string) ([]ssh.AuthMethod, error) {
+func authMethodsFromAgentAndFiles(keys [][]byte, identityFiles []string, passphrase []byte, password string) ([]ssh.AuthMethod, error) {
 	var (
 		methods []ssh.AuthMethod
 		signers []ssh.Signer
 	)
+	// explicitly provided keys; unlike identity files, these must be valid
+	for i, key := range keys {
+		signer, err := signerFromKey(key, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse provided private key %d: %w", i, err)
+		}
+		signers = append(signers, signer)
+	}
+
 	// ssh-agent
 	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
 		if conn, err := net.Dial("unix", sock); err == nil {
@@ -295,24 +529,117 @@ func authMethodsFromAgentAndFiles(identityFiles []string) ([]ssh.AuthMethod, err
 		if err != nil {
 			continue
 		}
-		raw, err := ssh.ParseRawPrivateKey(key)
-		if err != nil && errors.Is(err, &ssh.PassphraseMissingError{}) {
-			// ignore encrypted keys
+		signer, err := signerFromKey(key, passphrase)
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) || errors.Is(err, x509.IncorrectPasswordError) {
+			// ignore encrypted keys we cannot open
 			continue
 		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse private key %s: %w", p, err)
 		}
-		signer, err := ssh.NewSignerFromKey(raw)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create signer from key %s: %w", p, err)
-		}
 		signers = append(signers, signer)
 	}
 	methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
 		// assemble signers here (file first, then agent)
 		return signers, nil
 	}))
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
 
 	return methods, nil
 }
+
+// signerFromKey parse a PEM-encoded private key, decrypting it with the passphrase if it is encrypted
+func signerFromKey(key, passphrase []byte) (ssh.Signer, error) {
+	raw, err := ssh.ParseRawPrivateKey(key)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) && len(passphrase) > 0 {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(key, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(raw)
+}
+
+// sshEndpoint a host to connect to, after applying the ssh config
+type sshEndpoint struct {
+	// alias the name by which the host is looked up in the ssh config and known_hosts
+	alias    string
+	hostname string
+	port     string
+	username string
+}
+
+func (e sshEndpoint) address() string {
+	return net.JoinHostPort(e.hostname, e.port)
+}
+
+// resolveEndpoint apply the ssh config for the alias on top of the provided username and port, either of which may be empty
+func resolveEndpoint(sshConfig *ssh_config.Config, alias, username, port string) (sshEndpoint, error) {
+	endpoint := sshEndpoint{alias: alias, hostname: alias, port: port, username: username}
+	configPort, err := sshConfig.Get(alias, "Port")
+	if err != nil {
+		return endpoint, fmt.Errorf("error getting port from SSH config: %w", err)
+	}
+	configHostname, err := sshConfig.Get(alias, "HostName")
+	if err != nil {
+		return endpoint, fmt.Errorf("error getting hostname from SSH config: %w", err)
+	}
+	configUsername, err := sshConfig.Get(alias, "User")
+	if err != nil {
+		return endpoint, fmt.Errorf("error getting username from SSH config: %w", err)
+	}
+	if configPort != "" {
+		endpoint.port = configPort
+	}
+	if configHostname != "" {
+		endpoint.hostname = configHostname
+	}
+	if configUsername != "" {
+		endpoint.username = configUsername
+	}
+	if endpoint.port == "" {
+		endpoint.port = defaultSSHPort
+	}
+	return endpoint, nil
+}
+
+// parseJumpHost parse a single ProxyJump entry of the form [ssh://][user@]host[:port]
+func parseJumpHost(hop string) (username, host, port string) {
+	hop = strings.TrimPrefix(hop, "ssh://")
+	if i := strings.LastIndex(hop, "@"); i >= 0 {
+		username, hop = hop[:i], hop[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(hop); err == nil {
+		return username, h, p
+	}
+	return username, strings.Trim(hop, "[]"), ""
+}
+
+// dialSSH connect to the address, directly or, if via is not nil, tunneled through via.
+// via is closed when the returned client is closed.
+func dialSSH(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		_ = via.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		_ = via.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		_ = client.Wait()
+		_ = via.Close()
+	}()
+	return client, nil
+}
@@ -286,6 +286,257 @@ Host testhost
 	})
 }
 
+func TestHostKeyVerification(t *testing.T) {
+	t.Run("unknown host added to known_hosts", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		knownHosts := filepath.Join(os.Getenv("SSH_HOME"), "known_hosts")
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr})
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error getting SSH client: %v", err)
+		}
+		_ = client.Close()
+		content, err := os.ReadFile(knownHosts)
+		if err != nil {
+			t.Fatalf("failed to read known_hosts: %v", err)
+		}
+		if !strings.Contains(string(content), string(ssh.MarshalAuthorizedKey(server.Key.PublicKey())[:40])) {
+			t.Errorf("known_hosts does not contain server key: %s", content)
+		}
+		// second connection should succeed against the saved key
+		client, err = handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error on second connection: %v", err)
+		}
+		_ = client.Close()
+	})
+	t.Run("changed host key rejected", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		knownHosts := filepath.Join(os.Getenv("SSH_HOME"), "known_hosts")
+		otherPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		otherSigner, err := ssh.NewSignerFromKey(otherPriv)
+		if err != nil {
+			t.Fatalf("host key: %v", err)
+		}
+		if err := appendKnownHost(knownHosts, server.Addr, nil, otherSigner.PublicKey()); err != nil {
+			t.Fatalf("failed to write known_hosts: %v", err)
+		}
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr})
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error for changed host key, got none")
+		}
+	})
+	t.Run("known key of a less preferred algorithm", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		knownHosts := filepath.Join(os.Getenv("SSH_HOME"), "known_hosts")
+		// the server offers ECDSA first, but only its Ed25519 key is known
+		_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate host key: %v", err)
+		}
+		edSigner, err := ssh.NewSignerFromKey(edPriv)
+		if err != nil {
+			t.Fatalf("host key: %v", err)
+		}
+		server.srv.AddHostKey(edSigner)
+		if err := appendKnownHost(knownHosts, server.Addr, nil, edSigner.PublicKey()); err != nil {
+			t.Fatalf("failed to write known_hosts: %v", err)
+		}
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithStrictHostKeyChecking(StrictHostKeyCheckingYes))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error with known Ed25519 host key: %v", err)
+		}
+		_ = client.Close()
+	})
+	t.Run("concurrent connections to unknown host", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		knownHosts := filepath.Join(os.Getenv("SSH_HOME"), "known_hosts")
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr})
+		errs := make(chan error, 8)
+		for range cap(errs) {
+			go func() {
+				client, err := handler.getSSHClient()
+				if err == nil {
+					_ = client.Close()
+				}
+				errs <- err
+			}()
+		}
+		for range cap(errs) {
+			if err := <-errs; err != nil {
+				t.Fatalf("unexpected error connecting: %v", err)
+			}
+		}
+		content, err := os.ReadFile(knownHosts)
+		if err != nil {
+			t.Fatalf("failed to read known_hosts: %v", err)
+		}
+		if lines := strings.Count(string(content), "\n"); lines != 1 {
+			t.Errorf("known_hosts has %d lines, expected the host once: %s", lines, content)
+		}
+	})
+	t.Run("strict with unknown host", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithStrictHostKeyChecking(StrictHostKeyCheckingYes))
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error for unknown host with strict checking, got none")
+		}
+	})
+	t.Run("strict from ssh config", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		knownHosts := filepath.Join(t.TempDir(), "custom_known_hosts")
+		config := fmt.Sprintf(`
+Host %s
+  StrictHostKeyChecking yes
+  UserKnownHostsFile %s
+`, server.Hostname(), knownHosts)
+		_ = os.WriteFile(filepath.Join(os.Getenv("SSH_HOME"), "config"), []byte(config), 0600)
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr})
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error for unknown host with strict checking, got none")
+		}
+		if err := appendKnownHost(knownHosts, server.Addr, nil, server.Key.PublicKey()); err != nil {
+			t.Fatalf("failed to write known_hosts: %v", err)
+		}
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error with known host: %v", err)
+		}
+		_ = client.Close()
+	})
+	t.Run("pinned fingerprint", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		fp := ssh.FingerprintSHA256(server.Key.PublicKey())
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithHostKeyFingerprints(fp), WithStrictHostKeyChecking(StrictHostKeyCheckingYes))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error with pinned fingerprint: %v", err)
+		}
+		_ = client.Close()
+
+		handler = New(url.URL{Scheme: "scp", Host: server.Addr, RawQuery: url.Values{"fingerprint": {fp}}.Encode()})
+		client, err = handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error with fingerprint in URL: %v", err)
+		}
+		_ = client.Close()
+
+		handler = New(url.URL{Scheme: "scp", Host: server.Addr}, WithHostKeyFingerprints("SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"))
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error for mismatched fingerprint, got none")
+		}
+	})
+}
+
+func TestAuthOptions(t *testing.T) {
+	t.Run("password", func(t *testing.T) {
+		server := testStartServer(t)
+		t.Setenv("SSH_HOME", t.TempDir())
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithUsername(testUser), WithPassword(testPass))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error getting SSH client: %v", err)
+		}
+		_ = client.Close()
+
+		handler = New(url.URL{Scheme: "scp", Host: server.Addr}, WithUsername(testUser), WithPassword("wrong"))
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error with wrong password, got none")
+		}
+	})
+	t.Run("inline encrypted key with passphrase", func(t *testing.T) {
+		server := testStartServer(t)
+		t.Setenv("SSH_HOME", t.TempDir())
+		const passphrase = "opensesame"
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate keypair: %v", err)
+		}
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+		if err != nil {
+			t.Fatalf("marshal key: %v", err)
+		}
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("failed to create SSH public key: %v", err)
+		}
+		server.UserKeys = append(server.UserKeys, sshPub)
+		keyPEM := pem.EncodeToMemory(block)
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithPrivateKey(keyPEM), WithPassphrase(passphrase))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error getting SSH client: %v", err)
+		}
+		_ = client.Close()
+
+		handler = New(url.URL{Scheme: "scp", Host: server.Addr}, WithPrivateKey(keyPEM))
+		if _, err := handler.getSSHClient(); err == nil {
+			t.Fatal("expected error with encrypted key and no passphrase, got none")
+		}
+	})
+	t.Run("port option", func(t *testing.T) {
+		server := testStartServerWithKeys(t)
+		handler := New(url.URL{Scheme: "scp", Host: server.Hostname()}, WithPort(server.Port()))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error getting SSH client: %v", err)
+		}
+		_ = client.Close()
+	})
+	t.Run("jump host", func(t *testing.T) {
+		jump := testStartServerWithKeys(t)
+		server := testStartServer(t)
+		// same user keys as the jump host
+		server.UserKeys = jump.UserKeys
+
+		handler := New(url.URL{Scheme: "scp", Host: server.Addr}, WithJumpHost(jump.Addr))
+		client, err := handler.getSSHClient()
+		if err != nil {
+			t.Fatalf("unexpected error getting SSH client via jump host: %v", err)
+		}
+		_ = client.Close()
+		// both should now be in known_hosts
+		content, err := os.ReadFile(filepath.Join(os.Getenv("SSH_HOME"), "known_hosts"))
+		if err != nil {
+			t.Fatalf("failed to read known_hosts: %v", err)
+		}
+		if lines := strings.Count(string(content), "\n"); lines != 2 {
+			t.Errorf("expected 2 known_hosts entries, got %d: %s", lines, content)
+		}
+	})
+}
+
+func TestParseJumpHost(t *testing.T) {
+	tests := []struct {
+		hop                  string
+		username, host, port string
+	}{
+		{"host", "", "host", ""},
+		{"user@host", "user", "host", ""},
+		{"user@host:2222", "user", "host", "2222"},
+		{"ssh://user@host:2222", "user", "host", "2222"},
+		{"[::1]:2222", "", "::1", "2222"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hop, func(t *testing.T) {
+			username, host, port := parseJumpHost(tt.hop)
+			if username != tt.username || host != tt.host || port != tt.port {
+				t.Errorf("got %q %q %q, want %q %q %q", username, host, port, tt.username, tt.host, tt.port)
+			}
+		})
+	}
+}
+
 func testWriteKeypairToDir(pubkey crypto.PublicKey, privkey crypto.PrivateKey, dir string) error {
 	privBytes, err := x509.MarshalPKCS8PrivateKey(privkey)
 	if err != nil {
@@ -368,6 +619,14 @@ func testStartServer(t *testing.T) *Server {
 		PublicKeyHandler:  pubAuth,
 		SubsystemHandlers: subsystemHandlers,
 		Handler:           handler,
+		// allow use as a jump host
+		LocalPortForwardingCallback: func(ctx gliderssh.Context, host string, port uint32) bool {
+			return true
+		},
+		ChannelHandlers: map[string]gliderssh.ChannelHandler{
+			"session":      gliderssh.DefaultSessionHandler,
+			"direct-tcpip": gliderssh.DirectTCPIPHandler,
+		},
 	}
 	// Bind
 	lis, err := net.Listen("tcp", server.Addr)
@@ -12,6 +12,9 @@ import (
 
 	"github.com/cloudsoda/go-smb2"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/databacker/mysql-backup/pkg/ratelimit"
+	"github.com/databacker/mysql-backup/pkg/secret"
 )
 
 const (
@@ -22,7 +25,8 @@ type SMB struct {
 	url      url.URL
 	domain   string
 	username string
-	password string
+	// password may be a reference to a secret, resolved when first connecting
+	password *secret.Lazy
 }
 
 type Option func(s *SMB)
@@ -39,7 +43,7 @@ func WithUsername(username string) Option {
 }
 func WithPassword(password string) Option {
 	return func(s *SMB) {
-		s.password = password
+		s.password = secret.NewLazy(password)
 	}
 }
 
@@ -70,7 +74,7 @@ func (s *SMB) Pull(ctx context.Context, source, target string, logger *log.Entry
 			return err
 		}
 		defer func() { _ = from.Close() }()
-		copied, err = io.Copy(to, from)
+		copied, err = io.Copy(to, ratelimit.Download(ctx).Reader(ctx, from))
 		return err
 	})
 	return copied, err
@@ -94,7 +98,7 @@ func (s *SMB) Push(ctx context.Context, target, source string, logger *log.Entry
 			return err
 		}
 		defer func() { _ = to.Close() }()
-		copied, err = io.Copy(to, from)
+		copied, err = io.Copy(to, ratelimit.Upload(ctx).Reader(ctx, from))
 		return err
 	})
 	return copied, err
@@ -133,9 +137,12 @@ func (s *SMB) Remove(ctx context.Context, target string, logger *log.Entry) erro
 }
 
 func (s *SMB) exec(u url.URL, command func(fs *smb2.Share, sharepath string) error) error {
+	password, err := s.password.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get SMB password: %w", err)
+	}
 	var (
 		username = s.username
-		password = s.password
 		domain   = s.domain
 	)
 
@@ -0,0 +1,124 @@
+package storage
+
+import "github.com/databacker/api/go/api"
+
+// target types that are supported here but not (yet) defined in the api
+const (
+	TargetTypeSCP  api.TargetType = "scp"
+	TargetTypeHTTP api.TargetType = "http"
+)
+
+// SCP defines model for an SCP/SFTP target spec.
+type SCP struct {
+	// Username username for the target, overrides the URL and ssh config
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
+
+	// Password password for the target, used if key authentication fails
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	// PrivateKey PEM-encoded private key, inline
+	PrivateKey *string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
+
+	// PrivateKeyPath path to the private key file
+	PrivateKeyPath *string `json:"privateKeyPath,omitempty" yaml:"privateKeyPath,omitempty"`
+
+	// Passphrase passphrase for an encrypted private key
+	Passphrase *string `json:"passphrase,omitempty" yaml:"passphrase,omitempty"`
+
+	// Port port to connect to, overrides the URL and ssh config
+	Port *int `json:"port,omitempty" yaml:"port,omitempty"`
+
+	// KnownHosts path to the known_hosts file, overrides the ssh config
+	KnownHosts *string `json:"knownHosts,omitempty" yaml:"knownHosts,omitempty"`
+
+	// HostKeyFingerprints pinned host key fingerprints, e.g. SHA256:<base64>; when set, known_hosts is not used
+	HostKeyFingerprints *[]string `json:"hostKeyFingerprints,omitempty" yaml:"hostKeyFingerprints,omitempty"`
+
+	// StrictHostKeyChecking one of yes, accept-new, no; overrides the ssh config
+	StrictHostKeyChecking *string `json:"strictHostKeyChecking,omitempty" yaml:"strictHostKeyChecking,omitempty"`
+
+	// JumpHost jump hosts to connect through, comma-separated [user@]host[:port], same as ProxyJump
+	JumpHost *string `json:"jumpHost,omitempty" yaml:"jumpHost,omitempty"`
+}
+
+// S3Objects defines model for the settings of uploaded objects in an S3 target spec. It is read
+// from the same spec as api.S3.
+type S3Objects struct {
+	// ServerSideEncryption one of AES256 (SSE-S3), aws:kms (SSE-KMS), aws:kms:dsse
+	ServerSideEncryption *string `json:"serverSideEncryption,omitempty" yaml:"serverSideEncryption,omitempty"`
+
+	// SSEKMSKeyID KMS key ID or ARN for SSE-KMS, implies aws:kms
+	SSEKMSKeyID *string `json:"sseKMSKeyID,omitempty" yaml:"sseKMSKeyID,omitempty"`
+
+	// SSECustomerKey base64-encoded 256-bit key for SSE-C
+	SSECustomerKey *string `json:"sseCustomerKey,omitempty" yaml:"sseCustomerKey,omitempty"`
+
+	// StorageClass storage class for uploaded objects, e.g. STANDARD_IA
+	StorageClass *string `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+
+	// Tags tags for uploaded objects
+	Tags *map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// Metadata user metadata for uploaded objects
+	Metadata *map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+
+	// ACL canned ACL for uploaded objects
+	ACL *string `json:"acl,omitempty" yaml:"acl,omitempty"`
+
+	// ChecksumAlgorithm checksum algorithm for uploads, e.g. CRC32C or SHA256
+	ChecksumAlgorithm *string `json:"checksumAlgorithm,omitempty" yaml:"checksumAlgorithm,omitempty"`
+
+	// ObjectLockMode GOVERNANCE or COMPLIANCE, retaining objects until the date given by the retention policy
+	ObjectLockMode *string `json:"objectLockMode,omitempty" yaml:"objectLockMode,omitempty"`
+
+	// ObjectLockLegalHold place a legal hold on uploaded objects
+	ObjectLockLegalHold *bool `json:"objectLockLegalHold,omitempty" yaml:"objectLockLegalHold,omitempty"`
+}
+
+// HTTP defines model for a generic HTTP(S) target spec. The URL may be http:// or https://.
+type HTTP struct {
+	// Method method for uploading, PUT for the raw file as the body (default), or POST for a multipart form
+	Method *string `json:"method,omitempty" yaml:"method,omitempty"`
+
+	// UploadURL template for the URL to upload to, given {{ .base }} and {{ .filename }}
+	UploadURL *string `json:"uploadURL,omitempty" yaml:"uploadURL,omitempty"`
+
+	// DownloadURL template for the URL to download from, given {{ .base }} and {{ .filename }}
+	DownloadURL *string `json:"downloadURL,omitempty" yaml:"downloadURL,omitempty"`
+
+	// IndexURL template for the URL of the JSON index of backups, given {{ .base }} and {{ .dirname }}
+	IndexURL *string `json:"indexURL,omitempty" yaml:"indexURL,omitempty"`
+
+	// DeleteURL template for the URL to delete, given {{ .base }} and {{ .filename }}
+	DeleteURL *string `json:"deleteURL,omitempty" yaml:"deleteURL,omitempty"`
+
+	// FormField name of the form field for the file when uploading via POST, default "file"
+	FormField *string `json:"formField,omitempty" yaml:"formField,omitempty"`
+
+	// BearerToken token for bearer authentication
+	BearerToken *string `json:"bearerToken,omitempty" yaml:"bearerToken,omitempty"`
+
+	// Username username for basic authentication
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
+
+	// Password password for basic authentication
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	// Headers additional headers to send with every request
+	Headers *map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	// ClientCert path to the PEM-encoded client certificate for mTLS
+	ClientCert *string `json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+
+	// ClientKey path to the PEM-encoded client key for mTLS
+	ClientKey *string `json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+
+	// CACert path to the PEM-encoded CA certificate to trust, in addition to the system CAs
+	CACert *string `json:"caCert,omitempty" yaml:"caCert,omitempty"`
+
+	// Certificates fingerprints of acceptable server certificates, for mTLS with Credentials
+	Certificates *[]string `json:"certificates,omitempty" yaml:"certificates,omitempty"`
+
+	// Credentials base64-encoded curve25519 private key for mTLS, as for remote configuration
+	Credentials *string `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,3 +19,48 @@ type Storage interface {
 	// Remove remove a particular file
 	Remove(ctx context.Context, target string, logger *log.Entry) error
 }
+
+// Locker is implemented by storage that can protect files from removal, e.g. S3 Object Lock.
+type Locker interface {
+	// Locked report whether a particular file cannot be removed now, and if so, why
+	Locked(ctx context.Context, target string, logger *log.Entry) (locked bool, reason string, err error)
+}
+
+// BatchRemover is implemented by storage that can remove many files more efficiently than one at a time.
+type BatchRemover interface {
+	// RemoveBatch remove all of the given files. On partial failure, the error covers every file that was not removed.
+	RemoveBatch(ctx context.Context, targets []string, logger *log.Entry) error
+}
+
+// ChecksumStore is implemented by storage that keeps the checksums of a file with the file itself, e.g. as
+// object metadata, rather than in separate checksum files. The checksums are stored from the object metadata
+// in the context on Push, under keys with the prefix util.MetadataChecksumPrefix.
+type ChecksumStore interface {
+	// Checksums the hex-encoded checksums of a particular file, by algorithm, or none if it has none
+	Checksums(ctx context.Context, target string, logger *log.Entry) (map[string]string, error)
+}
+
+// MetadataStore is implemented by storage that keeps object metadata with each file, e.g. S3, as stored from the
+// object metadata and retain-until time in the context on Push.
+type MetadataStore interface {
+	// Metadata the object metadata of a particular file, with keys in lower case, and the time until which it
+	// is retained, or the zero time if it has none
+	Metadata(ctx context.Context, target string, logger *log.Entry) (metadata map[string]string, retainUntil time.Time, err error)
+}
+
+// RemoveAll remove all of the given files from the storage, in batches if the storage supports it,
+// else one at a time.
+func RemoveAll(ctx context.Context, store Storage, targets []string, logger *log.Entry) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	if batch, ok := store.(BatchRemover); ok {
+		return batch.RemoveBatch(ctx, targets, logger)
+	}
+	for _, target := range targets {
+		if err := store.Remove(ctx, target, logger); err != nil {
+			return fmt.Errorf("failed to remove file %s: %v", target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+const (
+	metadataKey    contextKey = "mysql-backup-object-metadata"
+	retainUntilKey contextKey = "mysql-backup-retain-until"
+)
+
+// keys for metadata about a backup, that storage backends may attach to the stored object
+const (
+	MetadataRunID      = "run-id"
+	MetadataSchemas    = "schemas"
+	MetadataServerUUID = "server-uuid"
+	MetadataTimestamp  = "timestamp"
+	// MetadataChecksumPrefix prefix for the checksums of the backup, followed by the algorithm, e.g. checksum-sha256
+	MetadataChecksumPrefix = "checksum-"
+)
+
+// ContextWithObjectMetadata adds metadata about the backup to the context, for storage backends
+// that can store metadata alongside the object. Merges with any metadata already in the context.
+func ContextWithObjectMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	merged := ObjectMetadataFromContext(ctx)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, metadata)
+	return context.WithValue(ctx, metadataKey, merged)
+}
+
+// ObjectMetadataFromContext retrieves a copy of the backup metadata from the context, or nil if there is none.
+func ObjectMetadataFromContext(ctx context.Context) map[string]string {
+	metadata, ok := ctx.Value(metadataKey).(map[string]string)
+	if !ok {
+		return nil
+	}
+	return maps.Clone(metadata)
+}
+
+// ContextWithRetainUntil adds the time until which the backup is to be retained, according to the
+// retention policy, for storage backends that can prevent removal until then.
+func ContextWithRetainUntil(ctx context.Context, until time.Time) context.Context {
+	return context.WithValue(ctx, retainUntilKey, until)
+}
+
+// RetainUntilFromContext retrieves the time until which the backup is to be retained, or the zero time if there is none.
+func RetainUntilFromContext(ctx context.Context) time.Time {
+	until, _ := ctx.Value(retainUntilKey).(time.Time)
+	return until
+}